@@ -1,7 +1,9 @@
 package btc_rune
 
 type Assignment struct {
-	ID     uint64 `json:"id"`
-	Output uint64 `json:"output"`
-	Amount uint64 `json:"amount"`
+	ID     uint64  `json:"id"`
+	Rune   *RuneID `json:"rune,omitempty"`
+	Symbol string  `json:"symbol,omitempty"`
+	Output uint64  `json:"output"`
+	Amount Uint128 `json:"amount"`
 }
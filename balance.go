@@ -0,0 +1,49 @@
+package btc_rune
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+var ErrInvalidOutpoint = errors.New("outpoint must be TXID:VOUT")
+
+// RuneBalance is the amount of one rune held by an unspent transaction output
+type RuneBalance struct {
+	TxID    string  `json:"txid" gorm:"primaryKey"`
+	Vout    uint32  `json:"vout" gorm:"primaryKey"`
+	Rune    RuneID  `json:"rune" gorm:"primaryKey;index"`
+	Amount  Uint128 `json:"amount"`
+	Address string  `json:"address,omitempty" gorm:"index"`
+	Value   int64   `json:"value"`
+	Height  int64   `json:"height"`
+}
+
+// Outpoint returns the txid:vout the balance is held by
+func (b *RuneBalance) Outpoint() string {
+	return fmt.Sprintf("%s:%d", b.TxID, b.Vout)
+}
+
+// ParseOutpoint parses the TXID:VOUT form returned by RuneBalance.Outpoint
+func ParseOutpoint(s string) (wire.OutPoint, error) {
+	txID, vout, ok := strings.Cut(s, ":")
+	if !ok {
+		return wire.OutPoint{}, ErrInvalidOutpoint
+	}
+
+	hash, err := chainhash.NewHashFromStr(txID)
+	if err != nil || len(txID) != 2*chainhash.HashSize {
+		return wire.OutPoint{}, ErrInvalidOutpoint
+	}
+
+	index, err := strconv.ParseUint(vout, 10, 32)
+	if err != nil {
+		return wire.OutPoint{}, ErrInvalidOutpoint
+	}
+
+	return wire.OutPoint{Hash: *hash, Index: uint32(index)}, nil
+}
@@ -0,0 +1,30 @@
+package btc_rune
+
+import (
+	"testing"
+)
+
+func TestParseOutpoint(t *testing.T) {
+	b := &RuneBalance{TxID: "20fe5bf84176ae20de1f930718dd9c80fc26a7e8f7a8d3a4e9c7d74773a9063d", Vout: 7}
+	op, err := ParseOutpoint(b.Outpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.Hash.String() != b.TxID || op.Index != 7 {
+		t.Fatalf("Expected %s - Got %s", b.Outpoint(), op)
+	}
+
+	for _, s := range []string{
+		"",
+		"20fe5bf84176ae20de1f930718dd9c80fc26a7e8f7a8d3a4e9c7d74773a9063d",
+		"20fe5bf84176ae20de1f930718dd9c80fc26a7e8f7a8d3a4e9c7d74773a9063d:",
+		"20fe5bf84176ae20de1f930718dd9c80fc26a7e8f7a8d3a4e9c7d74773a9063d:-1",
+		"20fe5bf84176ae20de1f930718dd9c80fc26a7e8f7a8d3a4e9c7d74773a9063d:4294967296",
+		"20fe:0",
+		"zzfe5bf84176ae20de1f930718dd9c80fc26a7e8f7a8d3a4e9c7d74773a9063d:0",
+	} {
+		if _, err := ParseOutpoint(s); err != ErrInvalidOutpoint {
+			t.Fatalf("%q: Expected ErrInvalidOutpoint - Got %v", s, err)
+		}
+	}
+}
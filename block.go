@@ -0,0 +1,11 @@
+package btc_rune
+
+import "time"
+
+// Block is a block the ledger has applied, the highest one is the indexed tip
+type Block struct {
+	Height    int64     `json:"height" gorm:"primaryKey;autoIncrement:false"`
+	Hash      string    `json:"hash" gorm:"uniqueIndex"`
+	PrevHash  string    `json:"prevHash"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,248 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// ErrUnsupported is returned for lookups a source cannot answer
+var ErrUnsupported = errors.New("not supported by chain source")
+
+// blockLocation is where a block record sits in the block files
+type blockLocation struct {
+	file   int
+	offset int64
+	size   uint32
+	prev   chainhash.Hash
+}
+
+// BlockFiles reads the chain from bitcoind's blocks/blk*.dat files.
+// The files hold blocks in arrival order, the best chain is rebuilt from
+// the parent links of their headers when the directory is opened.
+//
+// Only blocks already written when opening are served, it never notifies new tips.
+type BlockFiles struct {
+	files  []string
+	xorKey []byte
+
+	locations map[chainhash.Hash]*blockLocation
+	heights   []chainhash.Hash
+}
+
+// OpenBlockFiles indexes the block files in dir, which is normally bitcoind's blocks directory.
+// Files obfuscated with the key in xor.dat are read transparently.
+func OpenBlockFiles(dir string, params *chaincfg.Params) (*BlockFiles, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "blk*.dat"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	s := &BlockFiles{
+		files:     files,
+		locations: map[chainhash.Hash]*blockLocation{},
+	}
+
+	s.xorKey, err = os.ReadFile(filepath.Join(dir, "xor.dat"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(s.xorKey) > 0 && bytes.Count(s.xorKey, []byte{0}) == len(s.xorKey) {
+		s.xorKey = nil
+	}
+
+	for i := range files {
+		err = s.index(i, params.Net)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, s.buildChain(params.GenesisHash)
+}
+
+// index records the location of every block in file i
+func (s *BlockFiles) index(i int, net wire.BitcoinNet) error {
+	f, err := os.Open(s.files[i])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	record := make([]byte, 8+wire.MaxBlockHeaderPayload)
+	for offset+int64(len(record)) <= info.Size() {
+		_, err = f.ReadAt(record, offset)
+		if err != nil {
+			return err
+		}
+		s.xor(record, offset)
+
+		// bitcoind preallocates files, the zero filled tail ends them
+		if binary.LittleEndian.Uint32(record[0:4]) != uint32(net) {
+			break
+		}
+		size := binary.LittleEndian.Uint32(record[4:8])
+
+		var header wire.BlockHeader
+		err = header.Deserialize(bytes.NewReader(record[8:]))
+		if err != nil {
+			return fmt.Errorf("%s at %d: %w", s.files[i], offset, err)
+		}
+
+		s.locations[header.BlockHash()] = &blockLocation{
+			file:   i,
+			offset: offset + 8,
+			size:   size,
+			prev:   header.PrevBlock,
+		}
+		offset += 8 + int64(size)
+	}
+	return nil
+}
+
+// buildChain picks the highest block connected to genesis as the tip, ties go to the first seen
+func (s *BlockFiles) buildChain(genesis *chainhash.Hash) error {
+	if _, ok := s.locations[*genesis]; !ok {
+		return fmt.Errorf("%w: genesis %s", ErrBlockNotFound, genesis)
+	}
+
+	// Heights are -1 for blocks whose ancestors are missing from the files
+	heights := map[chainhash.Hash]int64{*genesis: 0}
+	height := func(hash chainhash.Hash) int64 {
+		// Walk back to the first block of known height, then fill forward
+		var path []chainhash.Hash
+		h, known := heights[hash]
+		for !known {
+			loc, ok := s.locations[hash]
+			if !ok {
+				h = -1
+				break
+			}
+			path = append(path, hash)
+			hash = loc.prev
+			h, known = heights[hash]
+		}
+
+		for i := len(path) - 1; i >= 0; i-- {
+			if h >= 0 {
+				h++
+			}
+			heights[path[i]] = h
+		}
+		return h
+	}
+
+	tip, best := *genesis, int64(0)
+	for _, hash := range s.ordered() {
+		if h := height(hash); h > best {
+			tip, best = hash, h
+		}
+	}
+
+	s.heights = make([]chainhash.Hash, best+1)
+	for h := best; h >= 0; h-- {
+		s.heights[h] = tip
+		tip = s.locations[tip].prev
+	}
+	return nil
+}
+
+// ordered returns the indexed hashes in file order
+func (s *BlockFiles) ordered() []chainhash.Hash {
+	hashes := make([]chainhash.Hash, 0, len(s.locations))
+	for hash := range s.locations {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		a, b := s.locations[hashes[i]], s.locations[hashes[j]]
+		if a.file != b.file {
+			return a.file < b.file
+		}
+		return a.offset < b.offset
+	})
+	return hashes
+}
+
+// xor undoes the obfuscation of buf read at offset
+func (s *BlockFiles) xor(buf []byte, offset int64) {
+	if len(s.xorKey) == 0 {
+		return
+	}
+	for i := range buf {
+		buf[i] ^= s.xorKey[(offset+int64(i))%int64(len(s.xorKey))]
+	}
+}
+
+func (s *BlockFiles) BlockHash(height int64) (*chainhash.Hash, error) {
+	if height < 0 || height >= int64(len(s.heights)) {
+		return nil, fmt.Errorf("%w: height %d", ErrBlockNotFound, height)
+	}
+	hash := s.heights[height]
+	return &hash, nil
+}
+
+func (s *BlockFiles) BlockByHeight(height int64) (*wire.MsgBlock, error) {
+	hash, err := s.BlockHash(height)
+	if err != nil {
+		return nil, err
+	}
+	return s.BlockByHash(hash)
+}
+
+func (s *BlockFiles) BlockByHash(hash *chainhash.Hash) (*wire.MsgBlock, error) {
+	loc, ok := s.locations[*hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBlockNotFound, hash)
+	}
+
+	f, err := os.Open(s.files[loc.file])
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, loc.size)
+	_, err = f.ReadAt(buf, loc.offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	s.xor(buf, loc.offset)
+
+	var block wire.MsgBlock
+	err = block.Deserialize(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// Transaction is unsupported, the block files carry no transaction index
+func (s *BlockFiles) Transaction(hash *chainhash.Hash) (*wire.MsgTx, error) {
+	return nil, fmt.Errorf("%w: transaction lookup without txindex", ErrUnsupported)
+}
+
+func (s *BlockFiles) Tip() (int64, *chainhash.Hash, error) {
+	height := int64(len(s.heights) - 1)
+	hash, err := s.BlockHash(height)
+	return height, hash, err
+}
+
+func (s *BlockFiles) Subscribe(stop <-chan struct{}) <-chan *chainhash.Hash {
+	return nil
+}
@@ -0,0 +1,37 @@
+// Package chain abstracts where blocks and transactions are read from.
+//
+// The indexer only needs ordered access to the best chain, a Source can be
+// a live node, bitcoind's block files for offline initial indexing, or a
+// directory of hex fixtures for deterministic tests.
+package chain
+
+import (
+	"errors"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+var (
+	ErrBlockNotFound = errors.New("block not found")
+	ErrTxNotFound    = errors.New("transaction not found")
+	// ErrUnavailable is returned when the source cannot be reached
+	ErrUnavailable = errors.New("chain source unavailable")
+)
+
+// Source is a backend serving the best chain
+type Source interface {
+	// BlockHash returns the hash of the best chain block at height
+	BlockHash(height int64) (*chainhash.Hash, error)
+	// BlockByHeight returns the best chain block at height
+	BlockByHeight(height int64) (*wire.MsgBlock, error)
+	// BlockByHash returns the block with hash
+	BlockByHash(hash *chainhash.Hash) (*wire.MsgBlock, error)
+	// Transaction returns the transaction with hash
+	Transaction(hash *chainhash.Hash) (*wire.MsgTx, error)
+	// Tip returns the height and hash of the best chain tip
+	Tip() (int64, *chainhash.Hash, error)
+	// Subscribe notifies new tips until stop is closed.
+	// Sources that never change return a nil channel.
+	Subscribe(stop <-chan struct{}) <-chan *chainhash.Hash
+}
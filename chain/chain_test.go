@@ -0,0 +1,207 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+var params = &chaincfg.RegressionNetParams
+
+// newBlock builds a block on prev, nonce keeps siblings apart
+func newBlock(prev *wire.MsgBlock, nonce uint32) *wire.MsgBlock {
+	block := wire.NewMsgBlock(&wire.BlockHeader{PrevBlock: prev.BlockHash(), Nonce: nonce})
+
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: ^uint32(0)}, []byte{byte(nonce)}, nil))
+	coinbase.AddTxOut(wire.NewTxOut(50, []byte{txscript.OP_TRUE}))
+	block.AddTransaction(coinbase)
+	return block
+}
+
+// newChain returns genesis followed by n blocks
+func newChain(n int) []*wire.MsgBlock {
+	blocks := []*wire.MsgBlock{params.GenesisBlock}
+	for i := 0; i < n; i++ {
+		blocks = append(blocks, newBlock(blocks[len(blocks)-1], uint32(i)))
+	}
+	return blocks
+}
+
+func serialize(t *testing.T, v interface{ Serialize(w io.Writer) error }) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := v.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeBlockFile writes blocks as blk records, padded like a preallocated file and obfuscated with key
+func writeBlockFile(t *testing.T, path string, key []byte, blocks ...*wire.MsgBlock) {
+	t.Helper()
+
+	var data []byte
+	for _, block := range blocks {
+		raw := serialize(t, block)
+		data = binary.LittleEndian.AppendUint32(data, uint32(params.Net))
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(raw)))
+		data = append(data, raw...)
+	}
+	data = append(data, make([]byte, 256)...)
+
+	for i := range data {
+		if len(key) > 0 {
+			data[i] ^= key[i%len(key)]
+		}
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectChain(t *testing.T, s Source, blocks []*wire.MsgBlock, start int64) {
+	t.Helper()
+
+	height, hash, err := s.Tip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tip := blocks[len(blocks)-1].BlockHash()
+	if height != start+int64(len(blocks)-1) || *hash != tip {
+		t.Fatalf("Expected tip %s at %d - Got %s at %d", tip, start+int64(len(blocks)-1), hash, height)
+	}
+
+	for i, expected := range blocks {
+		block, err := s.BlockByHeight(start + int64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.BlockHash() != expected.BlockHash() || len(block.Transactions) != len(expected.Transactions) {
+			t.Fatalf("%d: Expected %s - Got %s", start+int64(i), expected.BlockHash(), block.BlockHash())
+		}
+
+		hash := expected.BlockHash()
+		if _, err = s.BlockByHash(&hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = s.BlockByHeight(start + int64(len(blocks))); !errors.Is(err, ErrBlockNotFound) {
+		t.Fatalf("Expected ErrBlockNotFound past the tip - Got %v", err)
+	}
+}
+
+func TestBlockFiles(t *testing.T) {
+	blocks := newChain(4)
+	stale := newBlock(blocks[2], 100)
+	orphan := newBlock(newBlock(blocks[4], 200), 201)
+
+	for _, key := range [][]byte{nil, {0x5a, 0x01, 0xff, 0x10, 0x22, 0x33, 0x44, 0x55}} {
+		dir := t.TempDir()
+		if key != nil {
+			if err := os.WriteFile(filepath.Join(dir, "xor.dat"), key, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Out of order across files, with a stale sibling and an orphan
+		writeBlockFile(t, filepath.Join(dir, "blk00000.dat"), key, blocks[0], blocks[2], blocks[1], stale)
+		writeBlockFile(t, filepath.Join(dir, "blk00001.dat"), key, blocks[4], orphan, blocks[3])
+
+		s, err := OpenBlockFiles(dir, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectChain(t, s, blocks, 0)
+
+		hash := stale.BlockHash()
+		if _, err = s.BlockByHash(&hash); err != nil {
+			t.Fatalf("Expected stale blocks to stay readable by hash - Got %v", err)
+		}
+		if _, err = s.Transaction(&hash); !errors.Is(err, ErrUnsupported) {
+			t.Fatalf("Expected ErrUnsupported - Got %v", err)
+		}
+	}
+}
+
+func TestBlockFilesMissingGenesis(t *testing.T) {
+	dir := t.TempDir()
+	writeBlockFile(t, filepath.Join(dir, "blk00000.dat"), nil, newChain(2)[1:]...)
+
+	if _, err := OpenBlockFiles(dir, params); !errors.Is(err, ErrBlockNotFound) {
+		t.Fatalf("Expected ErrBlockNotFound - Got %v", err)
+	}
+}
+
+func TestFixtures(t *testing.T) {
+	dir := t.TempDir()
+	blocks := newChain(3)[1:]
+	for i, block := range blocks {
+		name := filepath.Join(dir, strconv.Itoa(840000+i)+".hex")
+		if err := os.WriteFile(name, []byte(hex.EncodeToString(serialize(t, block))+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mempoolTx := wire.NewMsgTx(wire.TxVersion)
+	mempoolTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: blocks[0].Transactions[0].TxHash()}, nil, nil))
+	mempoolTx.AddTxOut(wire.NewTxOut(10, []byte{txscript.OP_TRUE}))
+	txName := filepath.Join(dir, mempoolTx.TxHash().String()+".hex")
+	if err := os.WriteFile(txName, []byte(hex.EncodeToString(serialize(t, mempoolTx))), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenFixtures(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectChain(t, s, blocks, 840000)
+
+	for _, hash := range []chainhash.Hash{blocks[1].Transactions[0].TxHash(), mempoolTx.TxHash()} {
+		tx, err := s.Transaction(&hash)
+		if err != nil || tx.TxHash() != hash {
+			t.Fatalf("Expected transaction %s - Got %v", hash, err)
+		}
+	}
+
+	if err = os.Remove(filepath.Join(dir, "840001.hex")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenFixtures(dir); !errors.Is(err, ErrBlockNotFound) {
+		t.Fatalf("Expected gap in fixture heights to fail - Got %v", err)
+	}
+}
+
+func TestRPCError(t *testing.T) {
+	for name, c := range map[string]struct {
+		err      error
+		expected error
+	}{
+		"unknown hash": {btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "Block not found"), ErrBlockNotFound},
+		"out of range": {btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, "Block height out of range"), ErrBlockNotFound},
+		"warming up":   {btcjson.NewRPCError(btcjson.ErrRPCInWarmup, "Loading block index"), ErrUnavailable},
+		"unreachable":  {errors.New("dial tcp: connection refused"), ErrUnavailable},
+	} {
+		if err := rpcError(c.err, ErrBlockNotFound); !errors.Is(err, c.expected) {
+			t.Fatalf("%s: Expected %v - Got %v", name, c.expected, err)
+		}
+	}
+
+	other := btcjson.NewRPCError(btcjson.ErrRPCMisc, "Transaction index not enabled")
+	if err := rpcError(other, ErrTxNotFound); err != other {
+		t.Fatalf("Expected other node errors unchanged - Got %v", err)
+	}
+}
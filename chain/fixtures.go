@@ -0,0 +1,139 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Fixtures serves a chain from a directory of hex encoded fixtures.
+//
+// Blocks are stored as <height>.hex, standalone transactions as <txid>.hex.
+// Transactions are also looked up in the fixture blocks. Heights need not
+// start at zero but must be contiguous.
+type Fixtures struct {
+	start  int64
+	blocks []*wire.MsgBlock
+	hashes map[chainhash.Hash]*wire.MsgBlock
+	txs    map[chainhash.Hash]*wire.MsgTx
+}
+
+// OpenFixtures loads every fixture in dir
+func OpenFixtures(dir string) (*Fixtures, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.hex"))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Fixtures{
+		hashes: map[chainhash.Hash]*wire.MsgBlock{},
+		txs:    map[chainhash.Hash]*wire.MsgTx{},
+	}
+
+	heights := map[int64]*wire.MsgBlock{}
+	for _, file := range files {
+		raw, err := readHex(file)
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimSuffix(filepath.Base(file), ".hex")
+		if height, err := strconv.ParseInt(name, 10, 64); err == nil {
+			var block wire.MsgBlock
+			err = block.Deserialize(bytes.NewReader(raw))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			heights[height] = &block
+			continue
+		}
+
+		var tx wire.MsgTx
+		err = tx.Deserialize(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		s.txs[tx.TxHash()] = &tx
+	}
+
+	if len(heights) == 0 {
+		return s, nil
+	}
+
+	s.start = -1
+	for height := range heights {
+		if s.start < 0 || height < s.start {
+			s.start = height
+		}
+	}
+	for height := s.start; height < s.start+int64(len(heights)); height++ {
+		block, ok := heights[height]
+		if !ok {
+			return nil, fmt.Errorf("%w: fixture heights must be contiguous, missing %d", ErrBlockNotFound, height)
+		}
+
+		s.blocks = append(s.blocks, block)
+		s.hashes[block.BlockHash()] = block
+		for _, tx := range block.Transactions {
+			s.txs[tx.TxHash()] = tx
+		}
+	}
+	return s, nil
+}
+
+func readHex(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(string(data)))
+}
+
+func (s *Fixtures) BlockHash(height int64) (*chainhash.Hash, error) {
+	block, err := s.BlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	hash := block.BlockHash()
+	return &hash, nil
+}
+
+func (s *Fixtures) BlockByHeight(height int64) (*wire.MsgBlock, error) {
+	if height < s.start || height >= s.start+int64(len(s.blocks)) {
+		return nil, fmt.Errorf("%w: height %d", ErrBlockNotFound, height)
+	}
+	return s.blocks[height-s.start], nil
+}
+
+func (s *Fixtures) BlockByHash(hash *chainhash.Hash) (*wire.MsgBlock, error) {
+	block, ok := s.hashes[*hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBlockNotFound, hash)
+	}
+	return block, nil
+}
+
+func (s *Fixtures) Transaction(hash *chainhash.Hash) (*wire.MsgTx, error) {
+	tx, ok := s.txs[*hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTxNotFound, hash)
+	}
+	return tx, nil
+}
+
+func (s *Fixtures) Tip() (int64, *chainhash.Hash, error) {
+	height := s.start + int64(len(s.blocks)) - 1
+	hash, err := s.BlockHash(height)
+	return height, hash, err
+}
+
+func (s *Fixtures) Subscribe(stop <-chan struct{}) <-chan *chainhash.Hash {
+	return nil
+}
@@ -0,0 +1,113 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// RPC reads the chain from a node over JSON-RPC
+type RPC struct {
+	client *rpcclient.Client
+
+	// pollInterval is how often Subscribe checks the best block
+	pollInterval time.Duration
+}
+
+func NewRPC(client *rpcclient.Client, pollInterval time.Duration) *RPC {
+	return &RPC{client: client, pollInterval: pollInterval}
+}
+
+func (s *RPC) BlockHash(height int64) (*chainhash.Hash, error) {
+	hash, err := s.client.GetBlockHash(height)
+	if err != nil {
+		return nil, rpcError(err, ErrBlockNotFound)
+	}
+	return hash, nil
+}
+
+func (s *RPC) BlockByHeight(height int64) (*wire.MsgBlock, error) {
+	hash, err := s.BlockHash(height)
+	if err != nil {
+		return nil, err
+	}
+	return s.BlockByHash(hash)
+}
+
+func (s *RPC) BlockByHash(hash *chainhash.Hash) (*wire.MsgBlock, error) {
+	block, err := s.client.GetBlock(hash)
+	if err != nil {
+		return nil, rpcError(err, ErrBlockNotFound)
+	}
+	return block, nil
+}
+
+func (s *RPC) Transaction(hash *chainhash.Hash) (*wire.MsgTx, error) {
+	tx, err := s.client.GetRawTransaction(hash)
+	if err != nil {
+		return nil, rpcError(err, ErrTxNotFound)
+	}
+	return tx.MsgTx(), nil
+}
+
+func (s *RPC) Tip() (int64, *chainhash.Hash, error) {
+	hash, err := s.client.GetBestBlockHash()
+	if err != nil {
+		return 0, nil, rpcError(err, ErrBlockNotFound)
+	}
+
+	header, err := s.client.GetBlockHeaderVerbose(hash)
+	if err != nil {
+		return 0, nil, rpcError(err, ErrBlockNotFound)
+	}
+	return int64(header.Height), hash, nil
+}
+
+// rpcError maps a node error onto the package errors. The node answers an unknown hash
+// or a height out of range with an invalid key or parameter error, which becomes notFound.
+// A node still starting up, or no reply at all such as a refused connection, is ErrUnavailable.
+func rpcError(err error, notFound error) error {
+	var rpcErr *btcjson.RPCError
+	if !errors.As(err, &rpcErr) {
+		return fmt.Errorf("%w: %s", ErrUnavailable, err)
+	}
+
+	switch rpcErr.Code {
+	case btcjson.ErrRPCInvalidAddressOrKey, btcjson.ErrRPCInvalidParameter:
+		return fmt.Errorf("%w: %s", notFound, rpcErr.Message)
+	case btcjson.ErrRPCInWarmup:
+		return fmt.Errorf("%w: %s", ErrUnavailable, rpcErr.Message)
+	default:
+		return err
+	}
+}
+
+// Subscribe polls the best block hash, the first poll always notifies
+func (s *RPC) Subscribe(stop <-chan struct{}) <-chan *chainhash.Hash {
+	tips := make(chan *chainhash.Hash, 1)
+	go func() {
+		var last *chainhash.Hash
+		for {
+			hash, err := s.client.GetBestBlockHash()
+			if err == nil && (last == nil || !hash.IsEqual(last)) {
+				last = hash
+				select {
+				case tips <- hash:
+				default:
+				}
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-time.After(s.pollInterval):
+			}
+		}
+	}()
+	return tips
+}
@@ -0,0 +1,168 @@
+// Package codec is the single source of truth for the rune OP_RETURN wire formats.
+//
+// Two protocol versions are understood. The legacy layout from the original
+// blog post (see legacy.go) and the finalized runestone layout (see runestone.go).
+// Decode reports which one a script was read under in Transaction.Version.
+//
+// Malformed scripts are not errors. They decode to a cenotaph transaction with
+// the specific malformations listed in Transaction.Flaws, so the indexer can
+// burn the input runes as the protocol requires.
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+var (
+	ErrNotRunestone    = errors.New("not a rune script")
+	ErrUnknownProtocol = errors.New("unknown rune protocol version")
+)
+
+// Encode returns the OP_RETURN output script carrying tx in the layout of tx.Version.
+// A zero Version is encoded with the legacy layout. Runestone edicts are encoded in rune id order.
+func Encode(tx *btc_rune.Transaction) ([]byte, error) {
+	switch tx.Version {
+	case 0, btc_rune.ProtocolLegacy:
+		return encodeLegacy(tx)
+	case btc_rune.ProtocolRunestone:
+		return encodeRunestone(tx)
+	default:
+		return nil, ErrUnknownProtocol
+	}
+}
+
+// Decode parses a rune OP_RETURN script of either protocol version.
+// The only error returned is ErrNotRunestone, malformations are reported as flaws.
+// The returned transaction has no Hash set, callers fill it from the containing tx.
+func Decode(script []byte) (*btc_rune.Transaction, error) {
+	var tx *btc_rune.Transaction
+	switch Version(script) {
+	case btc_rune.ProtocolLegacy:
+		tx = decodeLegacy(script)
+	case btc_rune.ProtocolRunestone:
+		tx = decodeRunestone(script)
+	default:
+		return nil, ErrNotRunestone
+	}
+
+	return finalize(tx, 0), nil
+}
+
+// DecodeTx decodes the first rune script in msg and validates it against the
+// transaction outputs. The returned transaction has its Hash set.
+func DecodeTx(msg *wire.MsgTx) (*btc_rune.Transaction, error) {
+	script, ok := Find(msg)
+	if !ok {
+		return nil, ErrNotRunestone
+	}
+
+	tx, err := Decode(script)
+	if err != nil {
+		return nil, err
+	}
+	tx.Hash = msg.TxHash().String()
+
+	// Runestone edicts may target len(outputs) to split between all outputs
+	limit := uint64(len(msg.TxOut))
+	if tx.Version == btc_rune.ProtocolLegacy {
+		limit--
+	}
+
+	var flaws btc_rune.Flaws
+	for _, a := range tx.Transfers {
+		if a.Output > limit {
+			flaws |= btc_rune.FlawOutputOutOfRange
+		}
+	}
+	if tx.Pointer != nil && *tx.Pointer >= uint64(len(msg.TxOut)) {
+		flaws |= btc_rune.FlawOutputOutOfRange
+	}
+
+	return finalize(tx, flaws), nil
+}
+
+// finalize adds flaws to tx and strips everything a cenotaph does not carry
+func finalize(tx *btc_rune.Transaction, flaws btc_rune.Flaws) *btc_rune.Transaction {
+	tx.Flaws |= flaws
+	if tx.Flaws == 0 {
+		return tx
+	}
+
+	tx.Cenotaph = true
+	tx.Transfers = nil
+	tx.Pointer = nil
+	if tx.Issuance != nil && tx.Version == btc_rune.ProtocolRunestone {
+		// Cenotaph etchings still reserve their name but carry no supply or terms
+		tx.Issuance = &btc_rune.Rune{Symbol: tx.Issuance.Symbol}
+	}
+	return tx
+}
+
+// Version returns the protocol version of script, or 0 if it is not a rune script
+func Version(script []byte) btc_rune.Protocol {
+	switch {
+	case isLegacyScript(script):
+		return btc_rune.ProtocolLegacy
+	case isRunestoneScript(script):
+		return btc_rune.ProtocolRunestone
+	default:
+		return 0
+	}
+}
+
+// IsRuneScript reports whether script is a rune OP_RETURN of any protocol version
+func IsRuneScript(script []byte) bool {
+	return Version(script) != 0
+}
+
+// Find returns the first rune output script in tx
+func Find(tx *wire.MsgTx) ([]byte, bool) {
+	for _, txOut := range tx.TxOut {
+		if IsRuneScript(txOut.PkScript) {
+			return txOut.PkScript, true
+		}
+	}
+	return nil, false
+}
+
+// pushes returns the data pushes of script, flagging any other opcode
+func pushes(script []byte) ([][]byte, btc_rune.Flaws) {
+	var data [][]byte
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+	for tokenizer.Next() {
+		if tokenizer.Opcode() > txscript.OP_PUSHDATA4 {
+			return nil, btc_rune.FlawNonPushOpcode
+		}
+		data = append(data, tokenizer.Data())
+	}
+	if tokenizer.Err() != nil {
+		// Push runs past the end of the script
+		return nil, btc_rune.FlawNonPushOpcode
+	}
+	return data, 0
+}
+
+// appendPush appends a minimal length data push without the small integer
+// opcode substitution txscript.ScriptBuilder performs, so every field decodes as data.
+func appendPush(script []byte, data []byte) []byte {
+	switch l := len(data); {
+	case l == 0:
+		script = append(script, txscript.OP_0)
+	case l < txscript.OP_PUSHDATA1:
+		script = append(script, byte(l))
+	case l <= 0xff:
+		script = append(script, txscript.OP_PUSHDATA1, byte(l))
+	case l <= 0xffff:
+		script = append(script, txscript.OP_PUSHDATA2)
+		script = binary.LittleEndian.AppendUint16(script, uint16(l))
+	default:
+		script = append(script, txscript.OP_PUSHDATA4)
+		script = binary.LittleEndian.AppendUint32(script, uint32(l))
+	}
+	return append(script, data...)
+}
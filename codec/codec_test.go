@@ -0,0 +1,246 @@
+package codec
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"testing/quick"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// genLegacy produces an arbitrary encodable legacy transaction
+func genLegacy(r *rand.Rand) *btc_rune.Transaction {
+	tx := &btc_rune.Transaction{Version: btc_rune.ProtocolLegacy}
+
+	for i := r.Intn(8); i > 0; i-- {
+		tx.Transfers = append(tx.Transfers, &btc_rune.Assignment{
+			ID:     genUint64(r),
+			Output: genUint64(r),
+			Amount: genUint128(r),
+		})
+	}
+
+	if r.Intn(2) == 0 {
+		tx.Issuance = &btc_rune.Rune{
+			Symbol:   genSymbol(r, 40),
+			Decimals: genUint64(r),
+		}
+	}
+
+	return tx
+}
+
+// genRunestone produces an arbitrary encodable runestone, its edicts in no particular order
+func genRunestone(r *rand.Rand) *btc_rune.Transaction {
+	tx := &btc_rune.Transaction{Version: btc_rune.ProtocolRunestone}
+
+	for i := r.Intn(8); i > 0; i-- {
+		tx.Transfers = append(tx.Transfers, &btc_rune.Assignment{
+			Rune:   genRuneID(r),
+			Output: genUint64(r),
+			Amount: genUint128(r),
+		})
+	}
+
+	if r.Intn(2) == 0 {
+		tx.Issuance = &btc_rune.Rune{
+			Decimals: uint64(r.Intn(MaxDivisibility + 1)),
+			Spacers:  uint32(r.Intn(MaxSpacers + 1)),
+			Premine:  genUint128(r),
+			Turbo:    r.Intn(2) == 0,
+		}
+		if r.Intn(4) != 0 {
+			tx.Issuance.Symbol = genSymbol(r, 27)
+		}
+		if r.Intn(2) == 0 {
+			tx.Issuance.CurrencySymbol = string(rune('!' + r.Intn(0x2000)))
+		}
+		if r.Intn(2) == 0 {
+			tx.Issuance.Terms = &btc_rune.Terms{
+				Amount:      genOptional128(r),
+				Cap:         genOptional128(r),
+				HeightStart: genOptional(r),
+				HeightEnd:   genOptional(r),
+				OffsetStart: genOptional(r),
+				OffsetEnd:   genOptional(r),
+			}
+		}
+	}
+
+	if r.Intn(2) == 0 {
+		tx.Mint = genRuneID(r)
+	}
+	tx.Pointer = genOptional(r)
+
+	return tx
+}
+
+// genRuneID produces a valid id, only 0:0 lives in block 0
+func genRuneID(r *rand.Rand) *btc_rune.RuneID {
+	id := &btc_rune.RuneID{Block: genUint64(r) >> 1}
+	if id.Block > 0 {
+		id.Tx = r.Uint32()
+	}
+	return id
+}
+
+// genSymbol produces a name of up to max letters
+func genSymbol(r *rand.Rand, max int) string {
+	symbol := make([]byte, 1+r.Intn(max))
+	for i := range symbol {
+		symbol[i] = byte('A' + r.Intn(26))
+	}
+	return string(symbol)
+}
+
+func genOptional(r *rand.Rand) *uint64 {
+	if r.Intn(2) == 0 {
+		return nil
+	}
+	v := genUint64(r)
+	return &v
+}
+
+func genOptional128(r *rand.Rand) *btc_rune.Uint128 {
+	if r.Intn(2) == 0 {
+		return nil
+	}
+	v := genUint128(r)
+	return &v
+}
+
+// genUint128 favours small values and the 64 and 128 bit limits
+func genUint128(r *rand.Rand) btc_rune.Uint128 {
+	switch r.Intn(4) {
+	case 0:
+		return btc_rune.NewUint128(genUint64(r))
+	case 1:
+		return btc_rune.MaxUint128
+	default:
+		return btc_rune.Uint128{Hi: genUint64(r), Lo: r.Uint64()}
+	}
+}
+
+// genUint64 favours small values and varint length boundaries
+func genUint64(r *rand.Rand) uint64 {
+	switch r.Intn(3) {
+	case 0:
+		return uint64(r.Intn(256))
+	case 1:
+		return 1<<(7*uint(r.Intn(10))) - uint64(r.Intn(2))
+	default:
+		return r.Uint64()
+	}
+}
+
+// canonical returns tx as it decodes, runestone edicts sorted by rune id
+func canonical(tx *btc_rune.Transaction) *btc_rune.Transaction {
+	if tx.Version != btc_rune.ProtocolRunestone || tx.Transfers == nil {
+		return tx
+	}
+	out := *tx
+	out.Transfers = make(btc_rune.Transfers, len(tx.Transfers))
+	copy(out.Transfers, tx.Transfers)
+	sort.SliceStable(out.Transfers, func(i, j int) bool {
+		return out.Transfers[i].Rune.Less(*out.Transfers[j].Rune)
+	})
+	return &out
+}
+
+type arbitraryTx struct {
+	*btc_rune.Transaction
+}
+
+func (arbitraryTx) Generate(r *rand.Rand, _ int) reflect.Value {
+	if r.Intn(2) == 0 {
+		return reflect.ValueOf(arbitraryTx{genLegacy(r)})
+	}
+	return reflect.ValueOf(arbitraryTx{genRunestone(r)})
+}
+
+func TestRoundTrip(t *testing.T) {
+	check := func(in arbitraryTx) bool {
+		before := append(btc_rune.Transfers(nil), in.Transfers...)
+		script, err := Encode(in.Transaction)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		if !reflect.DeepEqual(before, in.Transfers) {
+			t.Log("Encode reordered its input")
+			return false
+		}
+
+		if Version(script) != in.Version {
+			t.Logf("Expected version %s - Got %s", in.Version, Version(script))
+			return false
+		}
+
+		out, err := Decode(script)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+
+		if out.Cenotaph {
+			t.Logf("Unexpected cenotaph: %s", out.Flaws)
+			return false
+		}
+
+		return reflect.DeepEqual(canonical(in.Transaction), out)
+	}
+
+	if err := quick.Check(check, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeNotRune(t *testing.T) {
+	for _, script := range [][]byte{nil, {0x6a}, {0x6a, 0x01, 'X'}, {0x76, 0xa9}} {
+		if _, err := Decode(script); err != ErrNotRunestone {
+			t.Fatalf("%x: Expected ErrNotRunestone - Got %v", script, err)
+		}
+	}
+}
+
+func TestDecodeTxOutputRange(t *testing.T) {
+	pointer := uint64(2)
+	runestone, err := Encode(&btc_rune.Transaction{
+		Version:   btc_rune.ProtocolRunestone,
+		Transfers: btc_rune.Transfers{{Rune: &btc_rune.RuneID{Block: 1}, Output: 2, Amount: btc_rune.NewUint128(1)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := wire.NewMsgTx(wire.TxVersion)
+	msg.AddTxOut(wire.NewTxOut(0, runestone))
+	msg.AddTxOut(wire.NewTxOut(546, []byte{txscript.OP_TRUE}))
+
+	// Output == len(outputs) splits between all outputs
+	tx, err := DecodeTx(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Cenotaph || tx.Hash != msg.TxHash().String() {
+		t.Fatalf("Expected valid runestone - Got %+v", tx)
+	}
+
+	runestone, _ = Encode(&btc_rune.Transaction{Version: btc_rune.ProtocolRunestone, Pointer: &pointer})
+	msg.TxOut[0].PkScript = runestone
+	tx, _ = DecodeTx(msg)
+	if !tx.Flaws.Has(btc_rune.FlawOutputOutOfRange) {
+		t.Fatalf("Expected pointer out of range - Got %s", tx.Flaws)
+	}
+
+	legacy, _ := Encode(&btc_rune.Transaction{Transfers: btc_rune.Transfers{{ID: 1, Output: 2, Amount: btc_rune.NewUint128(1)}}})
+	msg.TxOut[0].PkScript = legacy
+	tx, _ = DecodeTx(msg)
+	if !tx.Flaws.Has(btc_rune.FlawOutputOutOfRange) {
+		t.Fatalf("Expected legacy output out of range - Got %s", tx.Flaws)
+	}
+}
@@ -0,0 +1,156 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// A legacy rune output script is laid out as
+//
+//	OP_RETURN <push 'R'> <push transfers> [<push issuance>]
+//
+// where transfers is a sequence of (ID, OUTPUT, AMOUNT) varint triples and
+// issuance is the base-26 symbol varint (see EncodeSymbol) followed by the
+// decimals varint.
+
+// Magic is the data push identifying a legacy rune OP_RETURN output
+const Magic = 'R'
+
+var ErrEmptySymbol = errors.New("issuance symbol is empty")
+
+func isLegacyScript(script []byte) bool {
+	return len(script) > 2 && script[0] == txscript.OP_RETURN && script[1] == txscript.OP_DATA_1 && script[2] == Magic
+}
+
+func encodeLegacy(tx *btc_rune.Transaction) ([]byte, error) {
+	script := []byte{txscript.OP_RETURN}
+	script = appendPush(script, []byte{Magic})
+
+	var transfers bytes.Buffer
+	for _, a := range tx.Transfers {
+		putUvarint(&transfers, a.ID)
+		putUvarint(&transfers, a.Output)
+		PutUvarint128(&transfers, a.Amount)
+	}
+	script = appendPush(script, transfers.Bytes())
+
+	if tx.Issuance != nil {
+		issuance, err := encodeIssuance(tx.Issuance)
+		if err != nil {
+			return nil, err
+		}
+		script = appendPush(script, issuance)
+	}
+
+	return script, nil
+}
+
+func decodeLegacy(script []byte) *btc_rune.Transaction {
+	tx := btc_rune.Transaction{
+		Version: btc_rune.ProtocolLegacy,
+	}
+
+	data, flaws := pushes(script[3:])
+	if flaws != 0 {
+		tx.Flaws = flaws
+		return &tx
+	}
+	if len(data) > 2 {
+		tx.Flaws = btc_rune.FlawTooManyPushes
+		return &tx
+	}
+
+	if len(data) > 0 {
+		tx.Transfers, flaws = decodeTransfers(data[0])
+		tx.Flaws |= flaws
+	}
+
+	if len(data) > 1 {
+		tx.Issuance, flaws = decodeIssuance(data[1])
+		tx.Flaws |= flaws
+	}
+
+	return &tx
+}
+
+func decodeTransfers(data []byte) (btc_rune.Transfers, btc_rune.Flaws) {
+	r := bytes.NewReader(data)
+
+	var transfers btc_rune.Transfers
+	for r.Len() > 0 {
+		var fields [2]uint64
+		for i := range fields {
+			v, err := readUvarint(r)
+			if err == ErrVarintTruncated && i > 0 {
+				// Complete varints that do not form a whole triple
+				return transfers, btc_rune.FlawTrailingBytes
+			}
+			if err != nil {
+				return transfers, varintFlaw(err)
+			}
+			fields[i] = v
+		}
+
+		amount, err := ReadUvarint128(r)
+		if err == ErrVarintTruncated {
+			return transfers, btc_rune.FlawTrailingBytes
+		}
+		if err != nil {
+			return transfers, varintFlaw(err)
+		}
+
+		transfers = append(transfers, &btc_rune.Assignment{
+			ID:     fields[0],
+			Output: fields[1],
+			Amount: amount,
+		})
+	}
+	return transfers, 0
+}
+
+func encodeIssuance(r *btc_rune.Rune) ([]byte, error) {
+	symbol, err := EncodeSymbol(r.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	putBigUvarint(&buf, symbol)
+	putUvarint(&buf, r.Decimals)
+	return buf.Bytes(), nil
+}
+
+func decodeIssuance(data []byte) (*btc_rune.Rune, btc_rune.Flaws) {
+	r := bytes.NewReader(data)
+
+	value, err := readBigUvarint(r)
+	if err != nil {
+		return nil, btc_rune.FlawInvalidIssuance | varintFlaw(err)
+	}
+
+	if r.Len() == 0 {
+		return nil, btc_rune.FlawInvalidIssuance
+	}
+
+	decimals, err := readUvarint(r)
+	if err != nil {
+		return nil, btc_rune.FlawInvalidIssuance | varintFlaw(err)
+	}
+
+	symbol, err := DecodeSymbol(value)
+	if err != nil {
+		return nil, btc_rune.FlawInvalidIssuance
+	}
+
+	issuance := &btc_rune.Rune{
+		Symbol:   symbol,
+		Decimals: decimals,
+	}
+	if r.Len() > 0 {
+		return issuance, btc_rune.FlawTrailingBytes
+	}
+	return issuance, 0
+}
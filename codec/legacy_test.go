@@ -0,0 +1,104 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+func TestLegacyLayout(t *testing.T) {
+	tx := &btc_rune.Transaction{
+		Transfers: btc_rune.Transfers{{ID: 1, Output: 2, Amount: btc_rune.NewUint128(300)}},
+		Issuance:  &btc_rune.Rune{Symbol: "AB", Decimals: 8},
+	}
+
+	script, err := Encode(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		txscript.OP_RETURN,
+		txscript.OP_DATA_1, 'R',
+		txscript.OP_DATA_4, 1, 2, 0xac, 0x02,
+		txscript.OP_DATA_2, 27, 8,
+	}
+	if !bytes.Equal(expected, script) {
+		t.Fatalf("Expected %x - Got %x", expected, script)
+	}
+}
+
+func TestLegacyFlaws(t *testing.T) {
+	type testCase struct {
+		script   []byte
+		expected btc_rune.Flaws
+	}
+
+	testCases := map[string]testCase{
+		"non push": {
+			script:   []byte{txscript.OP_RETURN, txscript.OP_DATA_1, 'R', txscript.OP_DUP},
+			expected: btc_rune.FlawNonPushOpcode,
+		},
+		"push past end": {
+			script:   []byte{txscript.OP_RETURN, txscript.OP_DATA_1, 'R', txscript.OP_DATA_2, 1},
+			expected: btc_rune.FlawNonPushOpcode,
+		},
+		"too many": {
+			script:   []byte{txscript.OP_RETURN, txscript.OP_DATA_1, 'R', txscript.OP_0, txscript.OP_0, txscript.OP_0},
+			expected: btc_rune.FlawTooManyPushes,
+		},
+		"trailing transfer bytes": {
+			script:   []byte{txscript.OP_RETURN, txscript.OP_DATA_1, 'R', txscript.OP_DATA_5, 1, 2, 3, 4, 5},
+			expected: btc_rune.FlawTrailingBytes,
+		},
+		"truncated transfer varint": {
+			script:   []byte{txscript.OP_RETURN, txscript.OP_DATA_1, 'R', txscript.OP_DATA_3, 1, 2, 0x80},
+			expected: btc_rune.FlawTrailingBytes,
+		},
+		"truncated first varint": {
+			script:   []byte{txscript.OP_RETURN, txscript.OP_DATA_1, 'R', txscript.OP_DATA_1, 0x80},
+			expected: btc_rune.FlawTruncatedVarint,
+		},
+		"varint overflow": {
+			script: []byte{txscript.OP_RETURN, txscript.OP_DATA_1, 'R', txscript.OP_DATA_11,
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+			expected: btc_rune.FlawVarintOverflow,
+		},
+		"missing decimals": {
+			script:   []byte{txscript.OP_RETURN, txscript.OP_DATA_1, 'R', txscript.OP_0, txscript.OP_DATA_1, 3},
+			expected: btc_rune.FlawInvalidIssuance,
+		},
+		"truncated symbol": {
+			script:   []byte{txscript.OP_RETURN, txscript.OP_DATA_1, 'R', txscript.OP_0, txscript.OP_DATA_2, 0x80, 0x80},
+			expected: btc_rune.FlawInvalidIssuance | btc_rune.FlawTruncatedVarint,
+		},
+		"trailing issuance bytes": {
+			script:   []byte{txscript.OP_RETURN, txscript.OP_DATA_1, 'R', txscript.OP_0, txscript.OP_DATA_3, 3, 0, 1},
+			expected: btc_rune.FlawTrailingBytes,
+		},
+	}
+
+	for name, tc := range testCases {
+		tx, err := Decode(tc.script)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !tx.Cenotaph || tx.Flaws != tc.expected {
+			t.Fatalf("%s: Expected cenotaph %s - Got %v %s", name, tc.expected, tx.Cenotaph, tx.Flaws)
+		}
+		if tx.Transfers != nil {
+			t.Fatalf("%s: cenotaph kept transfers", name)
+		}
+	}
+}
+
+func TestLegacyEncodeInvalidSymbol(t *testing.T) {
+	for _, sym := range []string{"", "abc", "A1"} {
+		_, err := Encode(&btc_rune.Transaction{Issuance: &btc_rune.Rune{Symbol: sym}})
+		if err == nil {
+			t.Fatalf("Expected error for %q", sym)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// A runestone output script is laid out as
+//
+//	OP_RETURN OP_13 <push>...
+//
+// The pushes are concatenated into a stream of LEB128 integers read as
+// tag/value pairs. Tag 0 (body) ends the pairs, every integer after it
+// belongs to an edict of (block delta, tx delta, amount, output).
+
+const (
+	tagBody         = 0
+	tagDivisibility = 1
+	tagFlags        = 2
+	tagSpacers      = 3
+	tagRune         = 4
+	tagSymbol       = 5
+	tagPremine      = 6
+	tagCap          = 8
+	tagAmount       = 10
+	tagHeightStart  = 12
+	tagHeightEnd    = 14
+	tagOffsetStart  = 16
+	tagOffsetEnd    = 18
+	tagMint         = 20
+	tagPointer      = 22
+	tagCenotaph     = 126
+	tagNop          = 127
+)
+
+const (
+	flagEtching  = 0
+	flagTerms    = 1
+	flagTurbo    = 2
+	flagCenotaph = 127
+)
+
+const (
+	MaxDivisibility = 38
+	MaxSpacers      = 0b00000111_11111111_11111111_11111111
+)
+
+var (
+	ErrTruncatedField      = errors.New("runestone tag has no value")
+	ErrTrailingIntegers    = errors.New("runestone edict data is not a multiple of 4 integers")
+	ErrUnrecognizedEvenTag = errors.New("unrecognized even runestone tag")
+	ErrUnrecognizedFlag    = errors.New("unrecognized runestone flag")
+	ErrInvalidField        = errors.New("invalid runestone field value")
+	ErrEdictRuneID         = errors.New("invalid runestone edict rune id")
+	ErrMissingRuneID       = errors.New("runestone edict has no rune id")
+)
+
+func isRunestoneScript(script []byte) bool {
+	return len(script) > 1 && script[0] == txscript.OP_RETURN && script[1] == txscript.OP_13
+}
+
+// encodeRunestone returns the runestone script of tx.
+// Edicts are canonicalised into rune id order, as their ids are delta encoded, so a decoded
+// runestone holds tx's edicts sorted by rune. tx is not modified.
+func encodeRunestone(tx *btc_rune.Transaction) ([]byte, error) {
+	var payload bytes.Buffer
+	put := func(tag uint64, value btc_rune.Uint128) {
+		putUvarint(&payload, tag)
+		PutUvarint128(&payload, value)
+	}
+	putU64 := func(tag uint64, value uint64) {
+		put(tag, btc_rune.NewUint128(value))
+	}
+	putOptional := func(tag uint64, value *uint64) {
+		if value != nil {
+			putU64(tag, *value)
+		}
+	}
+	putOptional128 := func(tag uint64, value *btc_rune.Uint128) {
+		if value != nil {
+			put(tag, *value)
+		}
+	}
+
+	if r := tx.Issuance; r != nil {
+		flags := uint64(1) << flagEtching
+		if r.Terms != nil {
+			flags |= 1 << flagTerms
+		}
+		if r.Turbo {
+			flags |= 1 << flagTurbo
+		}
+		putU64(tagFlags, flags)
+
+		if r.Symbol != "" {
+			name, err := EncodeSymbol(r.Symbol)
+			if err != nil {
+				return nil, err
+			}
+			value, err := btc_rune.Uint128FromBig(name)
+			if err != nil {
+				return nil, fmt.Errorf("%w: rune name exceeds 128 bits", ErrInvalidField)
+			}
+			put(tagRune, value)
+		}
+		if r.Decimals != 0 {
+			putU64(tagDivisibility, r.Decimals)
+		}
+		if r.Spacers != 0 {
+			putU64(tagSpacers, uint64(r.Spacers))
+		}
+		if r.CurrencySymbol != "" {
+			c, size := utf8.DecodeRuneInString(r.CurrencySymbol)
+			if c == utf8.RuneError || size != len(r.CurrencySymbol) {
+				return nil, fmt.Errorf("%w: currency symbol must be a single character", ErrInvalidField)
+			}
+			putU64(tagSymbol, uint64(c))
+		}
+		if !r.Premine.IsZero() {
+			put(tagPremine, r.Premine)
+		}
+		if t := r.Terms; t != nil {
+			putOptional128(tagAmount, t.Amount)
+			putOptional128(tagCap, t.Cap)
+			putOptional(tagHeightStart, t.HeightStart)
+			putOptional(tagHeightEnd, t.HeightEnd)
+			putOptional(tagOffsetStart, t.OffsetStart)
+			putOptional(tagOffsetEnd, t.OffsetEnd)
+		}
+	}
+
+	if tx.Mint != nil {
+		putU64(tagMint, tx.Mint.Block)
+		putU64(tagMint, uint64(tx.Mint.Tx))
+	}
+	putOptional(tagPointer, tx.Pointer)
+
+	if len(tx.Transfers) > 0 {
+		edicts := make(btc_rune.Transfers, len(tx.Transfers))
+		copy(edicts, tx.Transfers)
+		for _, e := range edicts {
+			if e.Rune == nil {
+				return nil, ErrMissingRuneID
+			}
+		}
+		sort.SliceStable(edicts, func(i, j int) bool {
+			return edicts[i].Rune.Less(*edicts[j].Rune)
+		})
+
+		putUvarint(&payload, tagBody)
+		previous := btc_rune.RuneID{}
+		for _, e := range edicts {
+			blockDelta := e.Rune.Block - previous.Block
+			txDelta := uint64(e.Rune.Tx)
+			if blockDelta == 0 {
+				txDelta -= uint64(previous.Tx)
+			}
+			putUvarint(&payload, blockDelta)
+			putUvarint(&payload, txDelta)
+			PutUvarint128(&payload, e.Amount)
+			putUvarint(&payload, e.Output)
+			previous = *e.Rune
+		}
+	}
+
+	script := []byte{txscript.OP_RETURN, txscript.OP_13}
+	data := payload.Bytes()
+	for len(data) > 0 {
+		n := len(data)
+		if n > txscript.MaxScriptElementSize {
+			n = txscript.MaxScriptElementSize
+		}
+		script = appendPush(script, data[:n])
+		data = data[n:]
+	}
+	return script, nil
+}
+
+func decodeRunestone(script []byte) *btc_rune.Transaction {
+	tx := btc_rune.Transaction{
+		Version: btc_rune.ProtocolRunestone,
+	}
+
+	data, flaws := pushes(script[2:])
+	if flaws != 0 {
+		tx.Flaws = flaws
+		return &tx
+	}
+
+	integers, flaws := readIntegers(bytes.Join(data, nil))
+	if flaws != 0 {
+		tx.Flaws = flaws
+		return &tx
+	}
+
+	m := newMessage(integers)
+	tx.Transfers = m.edicts()
+
+	flags := m.take(tagFlags)
+	if flags.Lo&(1<<flagEtching) != 0 {
+		tx.Issuance = m.etching(flags.Lo&(1<<flagTerms) != 0)
+		tx.Issuance.Turbo = flags.Lo&(1<<flagTurbo) != 0
+	}
+
+	if mint := m.fields[tagMint]; len(mint) >= 2 {
+		block, id := mint[0], mint[1]
+		if block.IsUint64() && id.IsUint64() && id.Lo <= 1<<32-1 && (block.Lo > 0 || id.Lo == 0) {
+			m.fields[tagMint] = mint[2:]
+			tx.Mint = &btc_rune.RuneID{Block: block.Lo, Tx: uint32(id.Lo)}
+		}
+	}
+
+	tx.Pointer = m.takeUint64(tagPointer)
+
+	flags.Lo &^= 1<<flagEtching | 1<<flagTerms | 1<<flagTurbo
+	if !flags.IsZero() {
+		m.flaws |= btc_rune.FlawUnrecognizedFlag
+	}
+
+	// Anything even left over was either unknown or held an invalid value
+	for tag, values := range m.fields {
+		if len(values) > 0 && tag%2 == 0 {
+			m.flaws |= btc_rune.FlawUnrecognizedEvenTag
+		}
+	}
+
+	tx.Flaws |= m.flaws
+	return &tx
+}
+
+// readIntegers splits a runestone payload into its 128 bit integers
+func readIntegers(payload []byte) ([]btc_rune.Uint128, btc_rune.Flaws) {
+	r := bytes.NewReader(payload)
+
+	var integers []btc_rune.Uint128
+	for r.Len() > 0 {
+		v, err := ReadUvarint128(r)
+		if err != nil {
+			return nil, varintFlaw(err)
+		}
+		integers = append(integers, v)
+	}
+	return integers, 0
+}
+
+// message is a runestone payload split into tagged fields and edict integers
+type message struct {
+	fields map[uint64][]btc_rune.Uint128
+	body   []btc_rune.Uint128
+	flaws  btc_rune.Flaws
+}
+
+func newMessage(integers []btc_rune.Uint128) *message {
+	m := message{
+		fields: map[uint64][]btc_rune.Uint128{},
+	}
+
+	for i := 0; i < len(integers); i += 2 {
+		tag := integers[i]
+		if tag.IsZero() {
+			m.body = integers[i+1:]
+			break
+		}
+
+		if i+1 >= len(integers) {
+			m.flaws |= btc_rune.FlawTruncatedField
+			break
+		}
+
+		if !tag.IsUint64() {
+			if tag.Lo%2 == 0 {
+				m.flaws |= btc_rune.FlawUnrecognizedEvenTag
+			}
+			continue
+		}
+		m.fields[tag.Lo] = append(m.fields[tag.Lo], integers[i+1])
+	}
+
+	return &m
+}
+
+// take removes and returns the first value of tag, or zero if absent
+func (m *message) take(tag uint64) btc_rune.Uint128 {
+	values := m.fields[tag]
+	if len(values) == 0 {
+		return btc_rune.Uint128{}
+	}
+	m.fields[tag] = values[1:]
+	return values[0]
+}
+
+// takeUint128 removes and returns the first value of tag, or nil if absent
+func (m *message) takeUint128(tag uint64) *btc_rune.Uint128 {
+	if len(m.fields[tag]) == 0 {
+		return nil
+	}
+	value := m.take(tag)
+	return &value
+}
+
+// takeUint64 removes and returns the first value of tag, or nil if absent.
+// Values that do not fit are left in place, even tags then flag the runestone.
+func (m *message) takeUint64(tag uint64) *uint64 {
+	return m.takeIf(tag, func(v uint64) bool { return true })
+}
+
+// takeIf removes and returns the first value of tag when valid accepts it.
+// Rejected values are left in place, even tags then flag the runestone.
+func (m *message) takeIf(tag uint64, valid func(uint64) bool) *uint64 {
+	values := m.fields[tag]
+	if len(values) == 0 || !values[0].IsUint64() || !valid(values[0].Lo) {
+		return nil
+	}
+
+	value := m.take(tag).Lo
+	return &value
+}
+
+func (m *message) etching(hasTerms bool) *btc_rune.Rune {
+	r := btc_rune.Rune{}
+
+	if name := m.takeUint128(tagRune); name != nil {
+		r.Symbol, _ = DecodeSymbol(name.Big())
+	}
+
+	if divisibility := m.takeIf(tagDivisibility, func(v uint64) bool { return v <= MaxDivisibility }); divisibility != nil {
+		r.Decimals = *divisibility
+	}
+
+	if spacers := m.takeIf(tagSpacers, func(v uint64) bool { return v <= MaxSpacers }); spacers != nil {
+		r.Spacers = uint32(*spacers)
+	}
+
+	if symbol := m.takeIf(tagSymbol, func(v uint64) bool { return v <= utf8.MaxRune && utf8.ValidRune(rune(v)) }); symbol != nil {
+		r.CurrencySymbol = string(rune(*symbol))
+	}
+
+	if premine := m.takeUint128(tagPremine); premine != nil {
+		r.Premine = *premine
+	}
+
+	if hasTerms {
+		r.Terms = &btc_rune.Terms{
+			Amount:      m.takeUint128(tagAmount),
+			Cap:         m.takeUint128(tagCap),
+			HeightStart: m.takeUint64(tagHeightStart),
+			HeightEnd:   m.takeUint64(tagHeightEnd),
+			OffsetStart: m.takeUint64(tagOffsetStart),
+			OffsetEnd:   m.takeUint64(tagOffsetEnd),
+		}
+	}
+
+	return &r
+}
+
+// edicts decodes the body, stopping at the first invalid edict
+func (m *message) edicts() btc_rune.Transfers {
+	if len(m.body)%4 != 0 {
+		m.flaws |= btc_rune.FlawTrailingIntegers
+	}
+
+	var edicts btc_rune.Transfers
+	id := btc_rune.RuneID{}
+	for i := 0; i+4 <= len(m.body); i += 4 {
+		blockDelta, txDelta, amount, output := m.body[i], m.body[i+1], m.body[i+2], m.body[i+3]
+		if !blockDelta.IsUint64() || !txDelta.IsUint64() {
+			m.flaws |= btc_rune.FlawEdictRuneID
+			return edicts
+		}
+		if !output.IsUint64() {
+			m.flaws |= btc_rune.FlawOutputOutOfRange
+			return edicts
+		}
+
+		next, ok := nextRuneID(id, blockDelta.Lo, txDelta.Lo)
+		if !ok {
+			m.flaws |= btc_rune.FlawEdictRuneID
+			return edicts
+		}
+		id = next
+
+		runeID := id
+		edicts = append(edicts, &btc_rune.Assignment{
+			Rune:   &runeID,
+			Amount: amount,
+			Output: output.Lo,
+		})
+	}
+	return edicts
+}
+
+// nextRuneID applies a delta encoded edict id to the previous one.
+// Block 0 only holds the id 0:0 which refers to the rune etched by the transaction.
+func nextRuneID(previous btc_rune.RuneID, blockDelta, txDelta uint64) (btc_rune.RuneID, bool) {
+	if blockDelta == 0 {
+		tx := uint64(previous.Tx) + txDelta
+		if tx > 1<<32-1 || (previous.Block == 0 && tx > 0) {
+			return btc_rune.RuneID{}, false
+		}
+		return btc_rune.RuneID{Block: previous.Block, Tx: uint32(tx)}, true
+	}
+
+	block := previous.Block + blockDelta
+	if block < previous.Block || txDelta > 1<<32-1 {
+		return btc_rune.RuneID{}, false
+	}
+	return btc_rune.RuneID{Block: block, Tx: uint32(txDelta)}, true
+}
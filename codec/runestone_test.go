@@ -0,0 +1,168 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+func TestRunestoneLayout(t *testing.T) {
+	amount := btc_rune.NewUint128(1000)
+	tx := &btc_rune.Transaction{
+		Version: btc_rune.ProtocolRunestone,
+		Issuance: &btc_rune.Rune{
+			Symbol:   "AB",
+			Decimals: 2,
+			Terms:    &btc_rune.Terms{Amount: &amount},
+		},
+		Transfers: btc_rune.Transfers{
+			{Rune: &btc_rune.RuneID{Block: 840000, Tx: 5}, Output: 1, Amount: btc_rune.NewUint128(7)},
+			{Rune: &btc_rune.RuneID{Block: 840000, Tx: 2}, Output: 0, Amount: btc_rune.NewUint128(3)},
+		},
+	}
+
+	script, err := Encode(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		txscript.OP_RETURN, txscript.OP_13,
+		txscript.OP_DATA_20,
+		tagFlags, 0b011,
+		tagRune, 27,
+		tagDivisibility, 2,
+		tagAmount, 0xe8, 0x07,
+		tagBody,
+		0xc0, 0xa2, 0x33, 2, 3, 0,
+		0, 3, 7, 1,
+	}
+	if !bytes.Equal(expected, script) {
+		t.Fatalf("Expected %x - Got %x", expected, script)
+	}
+}
+
+func TestRunestoneMultiplePushes(t *testing.T) {
+	script := []byte{
+		txscript.OP_RETURN, txscript.OP_13,
+		txscript.OP_DATA_1, tagPointer,
+		txscript.OP_DATA_1, 3,
+	}
+
+	tx, err := Decode(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Version != btc_rune.ProtocolRunestone {
+		t.Fatalf("Expected runestone - Got %s", tx.Version)
+	}
+	if tx.Pointer == nil || *tx.Pointer != 3 {
+		t.Fatalf("Expected pointer 3 - Got %v", tx.Pointer)
+	}
+}
+
+func TestRunestoneFlaws(t *testing.T) {
+	type testCase struct {
+		payload  []byte
+		expected btc_rune.Flaws
+	}
+
+	testCases := map[string]testCase{
+		"truncated field":   {payload: []byte{tagPointer}, expected: btc_rune.FlawTruncatedField},
+		"trailing integers": {payload: []byte{tagBody, 1, 1, 1}, expected: btc_rune.FlawTrailingIntegers},
+		"cenotaph tag":      {payload: []byte{tagCenotaph, 1}, expected: btc_rune.FlawUnrecognizedEvenTag},
+		"unknown even tag":  {payload: []byte{24, 1}, expected: btc_rune.FlawUnrecognizedEvenTag},
+		"flag":              {payload: []byte{tagFlags, 0b1000}, expected: btc_rune.FlawUnrecognizedFlag},
+		"edict id overflow": {payload: []byte{tagBody, 1, 0x80, 0x80, 0x80, 0x80, 0x10, 1, 0}, expected: btc_rune.FlawEdictRuneID},
+		"edict block zero":  {payload: []byte{tagBody, 0, 1, 1, 0}, expected: btc_rune.FlawEdictRuneID},
+		"truncated varint":  {payload: []byte{tagPointer, 0x80}, expected: btc_rune.FlawTruncatedVarint},
+		"varint overflow": {
+			payload:  []byte{tagPointer, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+			expected: btc_rune.FlawVarintOverflow,
+		},
+		"pointer over 64 bits": {
+			payload:  []byte{tagPointer, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01},
+			expected: btc_rune.FlawUnrecognizedEvenTag,
+		},
+	}
+
+	for name, tc := range testCases {
+		script := appendPush([]byte{txscript.OP_RETURN, txscript.OP_13}, tc.payload)
+		tx, err := Decode(script)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if !tx.Cenotaph || tx.Flaws != tc.expected {
+			t.Fatalf("%s: Expected cenotaph %s - Got %v %s", name, tc.expected, tx.Cenotaph, tx.Flaws)
+		}
+	}
+}
+
+func TestRunestoneCenotaphKeepsName(t *testing.T) {
+	payload := []byte{tagFlags, 1, tagRune, 27, tagPremine, 100, tagCenotaph, 0, tagBody, 1, 1, 5, 0}
+	tx, err := Decode(appendPush([]byte{txscript.OP_RETURN, txscript.OP_13}, payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !tx.Cenotaph || tx.Transfers != nil {
+		t.Fatalf("Expected cenotaph without transfers - Got %+v", tx)
+	}
+	if tx.Issuance == nil || tx.Issuance.Symbol != "AB" || !tx.Issuance.Premine.IsZero() {
+		t.Fatalf("Expected bare AB etching - Got %+v", tx.Issuance)
+	}
+}
+
+func TestRunestoneInvalidOddFieldIgnored(t *testing.T) {
+	tx, err := Decode(appendPush([]byte{txscript.OP_RETURN, txscript.OP_13}, []byte{tagFlags, 1, tagDivisibility, 39}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Cenotaph || tx.Issuance.Decimals != 0 {
+		t.Fatalf("Expected divisibility to be ignored - Got %+v", tx)
+	}
+}
+
+func TestRunestoneOddTagIgnored(t *testing.T) {
+	script := appendPush([]byte{txscript.OP_RETURN, txscript.OP_13}, []byte{tagNop, 5, 129, 1, 9})
+	if _, err := Decode(script); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunestoneNameTooLong(t *testing.T) {
+	_, err := Encode(&btc_rune.Transaction{
+		Version:  btc_rune.ProtocolRunestone,
+		Issuance: &btc_rune.Rune{Symbol: "BCGDENLQRQWDSLRUGSNLBTMFIJAW"},
+	})
+	if !errors.Is(err, ErrInvalidField) {
+		t.Fatalf("Expected ErrInvalidField - Got %v", err)
+	}
+}
+
+func TestRunestone128BitAmounts(t *testing.T) {
+	premine := btc_rune.MaxUint128
+	tx := &btc_rune.Transaction{
+		Version:  btc_rune.ProtocolRunestone,
+		Issuance: &btc_rune.Rune{Symbol: "PEPE", Decimals: 18, Premine: premine},
+		Transfers: btc_rune.Transfers{
+			{Rune: &btc_rune.RuneID{}, Output: 1, Amount: btc_rune.Uint128{Hi: 1 << 60, Lo: 7}},
+		},
+	}
+
+	script, err := Encode(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := Decode(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Issuance.Premine != premine || out.Transfers[0].Amount != tx.Transfers[0].Amount {
+		t.Fatalf("128 bit values did not survive - Got %s %s", out.Issuance.Premine, out.Transfers[0].Amount)
+	}
+}
@@ -0,0 +1,77 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/alphabatem/btc_rune"
+)
+
+var (
+	ErrInvalidSymbol  = errors.New("symbol must only contain A-Z")
+	ErrNegativeSymbol = errors.New("symbol value is negative")
+)
+
+var big26 = big.NewInt(26)
+
+// EncodeSymbol maps an A-Z name of any length to its bijective base-26 value.
+//
+// Names are numbered A=0 ... Z=25, AA=26 ... ZZ=701, AAA=702 and so on, so
+// every name has exactly one value and every value exactly one name.
+func EncodeSymbol(symbol string) (*big.Int, error) {
+	if symbol == "" {
+		return nil, ErrEmptySymbol
+	}
+
+	n := new(big.Int)
+	for i := 0; i < len(symbol); i++ {
+		c := symbol[i]
+		if c < 'A' || c > 'Z' {
+			return nil, fmt.Errorf("%w: %q at %d", ErrInvalidSymbol, c, i)
+		}
+
+		if i > 0 {
+			n.Add(n, big.NewInt(1))
+		}
+		n.Mul(n, big26)
+		n.Add(n, big.NewInt(int64(c-'A')))
+	}
+	return n, nil
+}
+
+// DecodeSymbol is the inverse of EncodeSymbol
+func DecodeSymbol(value *big.Int) (string, error) {
+	if value.Sign() < 0 {
+		return "", ErrNegativeSymbol
+	}
+
+	n := new(big.Int).Add(value, big.NewInt(1))
+	rem := new(big.Int)
+
+	var symbol []byte
+	for n.Sign() > 0 {
+		n.Sub(n, big.NewInt(1))
+		n.DivMod(n, big26, rem)
+		symbol = append(symbol, byte('A'+rem.Int64()))
+	}
+
+	for i, j := 0, len(symbol)-1; i < j; i, j = i+1, j-1 {
+		symbol[i], symbol[j] = symbol[j], symbol[i]
+	}
+	return string(symbol), nil
+}
+
+// reservedSymbol is the first name handed to runestone etchings that do not choose one
+var reservedSymbol, _ = new(big.Int).SetString("6402364363415443603228541259936211926", 10)
+
+// ReservedSymbol returns the name assigned to an unnamed etching at id
+func ReservedSymbol(id btc_rune.RuneID) string {
+	n := new(big.Int).SetUint64(id.Block)
+	n.Lsh(n, 32)
+	n.Or(n, new(big.Int).SetUint64(uint64(id.Tx)))
+	n.Add(n, reservedSymbol)
+
+	symbol, _ := DecodeSymbol(n)
+	return symbol
+}
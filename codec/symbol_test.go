@@ -0,0 +1,106 @@
+package codec
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"testing/quick"
+
+	"github.com/alphabatem/btc_rune"
+)
+
+func TestSymbolVectors(t *testing.T) {
+	type testCase struct {
+		symbol   string
+		expected string
+	}
+
+	testCases := []testCase{
+		{symbol: "A", expected: "0"},
+		{symbol: "B", expected: "1"},
+		{symbol: "Z", expected: "25"},
+		{symbol: "AA", expected: "26"},
+		{symbol: "AZ", expected: "51"},
+		{symbol: "BA", expected: "52"},
+		{symbol: "ZZ", expected: "701"},
+		{symbol: "AAA", expected: "702"},
+		{symbol: "RUNE", expected: "330932"},
+		{symbol: "PEPE", expected: "285016"},
+		{symbol: "BCGDENLQRQWDSLRUGSNLBTMFIJAV", expected: "340282366920938463463374607431768211455"},
+		{symbol: "BCGDENLQRQWDSLRUGSNLBTMFIJAW", expected: "340282366920938463463374607431768211456"},
+	}
+
+	for _, tc := range testCases {
+		n, err := EncodeSymbol(tc.symbol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.String() != tc.expected {
+			t.Fatalf("%s: Expected %s - Got %s", tc.symbol, tc.expected, n)
+		}
+
+		expected, _ := new(big.Int).SetString(tc.expected, 10)
+		sym, err := DecodeSymbol(expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sym != tc.symbol {
+			t.Fatalf("%s: Expected %s - Got %s", tc.expected, tc.symbol, sym)
+		}
+	}
+}
+
+func TestSymbolRoundTrip(t *testing.T) {
+	check := func(b []byte) bool {
+		n := new(big.Int).SetBytes(b)
+		sym, err := DecodeSymbol(n)
+		if err != nil {
+			return false
+		}
+		out, err := EncodeSymbol(sym)
+		return err == nil && out.Cmp(n) == 0
+	}
+
+	if err := quick.Check(check, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodeSymbolInvalid(t *testing.T) {
+	for _, sym := range []string{"a", "AB1", "A B", "É"} {
+		if _, err := EncodeSymbol(sym); !errors.Is(err, ErrInvalidSymbol) {
+			t.Fatalf("%q: Expected ErrInvalidSymbol - Got %v", sym, err)
+		}
+	}
+
+	if _, err := EncodeSymbol(""); !errors.Is(err, ErrEmptySymbol) {
+		t.Fatalf("Expected ErrEmptySymbol - Got %v", err)
+	}
+
+	if _, err := DecodeSymbol(big.NewInt(-1)); !errors.Is(err, ErrNegativeSymbol) {
+		t.Fatalf("Expected ErrNegativeSymbol - Got %v", err)
+	}
+}
+
+func TestReservedSymbol(t *testing.T) {
+	first, _ := EncodeSymbol("AAAAAAAAAAAAAAAAAAAAAAAAAAA")
+	if first.Cmp(reservedSymbol) != 0 {
+		t.Fatalf("Expected reserved start %s - Got %s", reservedSymbol, first)
+	}
+
+	testCases := map[btc_rune.RuneID]string{
+		{Block: 0, Tx: 0}:  "AAAAAAAAAAAAAAAAAAAAAAAAAAA",
+		{Block: 0, Tx: 1}:  "AAAAAAAAAAAAAAAAAAAAAAAAAAB",
+		{Block: 0, Tx: 26}: "AAAAAAAAAAAAAAAAAAAAAAAAABA",
+	}
+
+	for id, expected := range testCases {
+		if got := ReservedSymbol(id); got != expected {
+			t.Fatalf("%s: Expected %s - Got %s", id, expected, got)
+		}
+	}
+
+	if ReservedSymbol(btc_rune.RuneID{Block: 1}) == ReservedSymbol(btc_rune.RuneID{Tx: 1 << 31}) {
+		t.Fatal("Reserved names must be unique per id")
+	}
+}
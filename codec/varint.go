@@ -0,0 +1,120 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+
+	"github.com/alphabatem/btc_rune"
+)
+
+// maxVarintLen128 is the longest LEB128 encoding of a 128 bit value
+const maxVarintLen128 = 19
+
+var (
+	ErrVarintTruncated = errors.New("varint truncated")
+	ErrVarintOverflow  = errors.New("varint overflows")
+)
+
+// putUvarint appends value to buf as an unsigned LEB128 varint
+func putUvarint(buf *bytes.Buffer, value uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], value)
+	buf.Write(tmp[:n])
+}
+
+// readUvarint reads an unsigned LEB128 varint from r
+func readUvarint(r *bytes.Reader) (uint64, error) {
+	value, err := binary.ReadUvarint(r)
+	switch err {
+	case nil:
+		return value, nil
+	case io.EOF, io.ErrUnexpectedEOF:
+		return 0, ErrVarintTruncated
+	default:
+		return 0, ErrVarintOverflow
+	}
+}
+
+// PutUvarint128 appends a 128 bit value to buf as an unsigned LEB128 varint
+func PutUvarint128(buf *bytes.Buffer, value btc_rune.Uint128) {
+	for value.Hi != 0 || value.Lo >= 0x80 {
+		buf.WriteByte(byte(value.Lo) | 0x80)
+		value.Lo = value.Lo>>7 | value.Hi<<57
+		value.Hi >>= 7
+	}
+	buf.WriteByte(byte(value.Lo))
+}
+
+// ReadUvarint128 reads an unsigned LEB128 varint of at most 128 bits from r
+func ReadUvarint128(r *bytes.Reader) (btc_rune.Uint128, error) {
+	var value btc_rune.Uint128
+	for i := 0; i < maxVarintLen128; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return btc_rune.Uint128{}, ErrVarintTruncated
+		}
+
+		shift := uint(7 * i)
+		low := uint64(b & 0x7f)
+		if i == maxVarintLen128-1 && low > 0b11 {
+			return btc_rune.Uint128{}, ErrVarintOverflow
+		}
+
+		switch {
+		case shift < 64:
+			value.Lo |= low << shift
+			if shift > 57 {
+				value.Hi |= low >> (64 - shift)
+			}
+		default:
+			value.Hi |= low << (shift - 64)
+		}
+
+		if b < 0x80 {
+			return value, nil
+		}
+	}
+	return btc_rune.Uint128{}, ErrVarintOverflow
+}
+
+// putBigUvarint appends a non-negative value of any size to buf as an unsigned LEB128 varint
+func putBigUvarint(buf *bytes.Buffer, value *big.Int) {
+	v := new(big.Int).Set(value)
+	low := new(big.Int)
+	for {
+		b := byte(low.And(v, big.NewInt(0x7f)).Uint64())
+		v.Rsh(v, 7)
+		if v.Sign() == 0 {
+			buf.WriteByte(b)
+			return
+		}
+		buf.WriteByte(b | 0x80)
+	}
+}
+
+// readBigUvarint reads an unsigned LEB128 varint of any size from r
+func readBigUvarint(r *bytes.Reader) (*big.Int, error) {
+	value := new(big.Int)
+	for shift := uint(0); ; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrVarintTruncated
+		}
+
+		value.Or(value, new(big.Int).Lsh(big.NewInt(int64(b&0x7f)), shift))
+		if b < 0x80 {
+			return value, nil
+		}
+	}
+}
+
+// varintFlaw maps a varint read error to its flaw
+func varintFlaw(err error) btc_rune.Flaws {
+	if err == ErrVarintOverflow {
+		return btc_rune.FlawVarintOverflow
+	}
+	return btc_rune.FlawTruncatedVarint
+}
@@ -0,0 +1,70 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"testing/quick"
+
+	"github.com/alphabatem/btc_rune"
+)
+
+func TestUvarint128Vectors(t *testing.T) {
+	type testCase struct {
+		value    btc_rune.Uint128
+		expected string
+	}
+
+	testCases := []testCase{
+		{value: btc_rune.NewUint128(0), expected: "00"},
+		{value: btc_rune.NewUint128(127), expected: "7f"},
+		{value: btc_rune.NewUint128(128), expected: "8001"},
+		{value: btc_rune.NewUint128(^uint64(0)), expected: "ffffffffffffffffff01"},
+		{value: btc_rune.Uint128{Hi: 1}, expected: "80808080808080808002"},
+		{value: btc_rune.MaxUint128, expected: "ffffffffffffffffffffffffffffffffffff03"},
+	}
+
+	for _, tc := range testCases {
+		var buf bytes.Buffer
+		PutUvarint128(&buf, tc.value)
+		if hex.EncodeToString(buf.Bytes()) != tc.expected {
+			t.Fatalf("%s: Expected %s - Got %x", tc.value, tc.expected, buf.Bytes())
+		}
+
+		got, err := ReadUvarint128(bytes.NewReader(buf.Bytes()))
+		if err != nil || got != tc.value {
+			t.Fatalf("%s: decode failed %v %s", tc.value, err, got)
+		}
+	}
+}
+
+func TestUvarint128RoundTrip(t *testing.T) {
+	check := func(hi, lo uint64) bool {
+		v := btc_rune.Uint128{Hi: hi, Lo: lo}
+		var buf bytes.Buffer
+		PutUvarint128(&buf, v)
+
+		got, err := ReadUvarint128(bytes.NewReader(buf.Bytes()))
+		return err == nil && got == v
+	}
+
+	if err := quick.Check(check, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUvarint128Errors(t *testing.T) {
+	overflow, _ := hex.DecodeString("ffffffffffffffffffffffffffffffffffff04")
+	if _, err := ReadUvarint128(bytes.NewReader(overflow)); err != ErrVarintOverflow {
+		t.Fatalf("Expected overflow - Got %v", err)
+	}
+
+	long, _ := hex.DecodeString("8080808080808080808080808080808080808000")
+	if _, err := ReadUvarint128(bytes.NewReader(long)); err != ErrVarintOverflow {
+		t.Fatalf("Expected overflow - Got %v", err)
+	}
+
+	if _, err := ReadUvarint128(bytes.NewReader([]byte{0x80})); err != ErrVarintTruncated {
+		t.Fatalf("Expected truncation - Got %v", err)
+	}
+}
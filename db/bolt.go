@@ -0,0 +1,948 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/cloakd/common/context"
+	"github.com/cloakd/common/services"
+	bolt "go.etcd.io/bbolt"
+	"os"
+)
+
+var (
+	bucketRunes    = []byte("runes")
+	bucketSymbols  = []byte("rune_symbols")
+	bucketIssuance = []byte("rune_issuance")
+	bucketNumbers  = []byte("rune_numbers")
+	bucketCounts   = []byte("rune_counts")
+	bucketBalances = []byte("balances")
+	bucketEvents   = []byte("events")
+	bucketBlocks   = []byte("blocks")
+	bucketUndo     = []byte("undo")
+	bucketAddress  = []byte("address_outpoints")
+	bucketStats    = []byte("rune_stats")
+	bucketHoldings = []byte("holdings")
+	bucketHolders  = []byte("rune_holders")
+
+	bucketWebhooks       = []byte("webhooks")
+	bucketDeliveries     = []byte("deliveries")
+	bucketDue            = []byte("delivery_due")
+	bucketHookDeliveries = []byte("webhook_deliveries")
+)
+
+// BoltService stores the index in an embedded bbolt key-value file.
+// Outpoints are point reads and writes on binary keys, there is no SQL layer in the way.
+type BoltService struct {
+	services.DefaultService
+	store storage.Store
+
+	database string
+}
+
+// Id returns Service ID, shared by every storage backend
+func (ds BoltService) Id() string {
+	return STORAGE_SVC
+}
+
+// Store returns the index repository
+func (ds BoltService) Store() storage.Store {
+	return ds.store
+}
+
+// Configure the service
+func (ds *BoltService) Configure(ctx *context.Context) error {
+	ds.database = os.Getenv("DB_DATABASE")
+
+	return ds.DefaultService.Configure(ctx)
+}
+
+// Start the service and open the database file
+func (ds *BoltService) Start() (err error) {
+	ds.store, err = NewBoltStore(ds.database)
+	return err
+}
+
+// Shutdown Gracefully close the database file
+func (ds *BoltService) Shutdown() {
+	if ds.store != nil {
+		_ = ds.store.Close()
+	}
+}
+
+// NewBoltStore opens the bbolt file at path as a storage backend
+func NewBoltStore(path string) (storage.Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, NoFreelistSync: true})
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{boltTx{db: db}}, nil
+}
+
+// boltStore implements storage.Store on bbolt
+type boltStore struct {
+	boltTx
+}
+
+// boltTx implements storage.Tx, calls outside of an open transaction run in their own
+type boltTx struct {
+	db *bolt.DB
+	tx *bolt.Tx
+}
+
+func (s *boltStore) Atomic(fn func(tx storage.Tx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{db: s.db, tx: tx})
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func (t boltTx) view(fn func(tx *bolt.Tx) error) error {
+	if t.tx != nil {
+		return fn(t.tx)
+	}
+	return t.db.View(fn)
+}
+
+func (t boltTx) update(fn func(tx *bolt.Tx) error) error {
+	if t.tx != nil {
+		return fn(t.tx)
+	}
+	return t.db.Update(fn)
+}
+
+func (t boltTx) Rune(id btc_rune.RuneID) (r *btc_rune.Rune, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		r, err = getRune(tx, runeIDKey(id))
+		return err
+	})
+	return r, err
+}
+
+func (t boltTx) RuneBySymbol(symbol string) (*btc_rune.Rune, error) {
+	return t.runeByIndex(bucketSymbols, []byte(symbol))
+}
+
+func (t boltTx) RuneByIssuance(txID string) (*btc_rune.Rune, error) {
+	return t.runeByIndex(bucketIssuance, []byte(txID))
+}
+
+func (t boltTx) RuneByNumber(version btc_rune.Protocol, number uint64) (*btc_rune.Rune, error) {
+	return t.runeByIndex(bucketNumbers, numberKey(version, number))
+}
+
+func (t boltTx) runeByIndex(index, key []byte) (r *btc_rune.Rune, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		id := tx.Bucket(index).Get(key)
+		if id == nil {
+			return storage.ErrNotFound
+		}
+		r, err = getRune(tx, id)
+		return err
+	})
+	return r, err
+}
+
+func getRune(tx *bolt.Tx, key []byte) (*btc_rune.Rune, error) {
+	data := tx.Bucket(bucketRunes).Get(key)
+	if data == nil {
+		return nil, storage.ErrNotFound
+	}
+
+	return decodeRune(data)
+}
+
+func putRune(tx *bolt.Tx, r *btc_rune.Rune) error {
+	data, err := encodeRune(r)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketRunes).Put(runeIDKey(r.ID), data)
+}
+
+func (t boltTx) CountRunes(version btc_rune.Protocol) (count uint64, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucketCounts).Get([]byte{byte(version)}); v != nil {
+			count = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+	return count, err
+}
+
+// addCount adjusts the number of runes registered under version
+func addCount(tx *bolt.Tx, version btc_rune.Protocol, delta int64) error {
+	b := tx.Bucket(bucketCounts)
+	var count uint64
+	if v := b.Get([]byte{byte(version)}); v != nil {
+		count = binary.BigEndian.Uint64(v)
+	}
+
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(int64(count)+delta))
+	return b.Put([]byte{byte(version)}, v)
+}
+
+func (t boltTx) CreateRune(r *btc_rune.Rune) error {
+	return t.update(func(tx *bolt.Tx) error {
+		key := runeIDKey(r.ID)
+		for index, k := range map[string][]byte{
+			string(bucketSymbols):  []byte(r.Symbol),
+			string(bucketIssuance): []byte(r.IssuanceTx),
+			string(bucketNumbers):  numberKey(r.Version, r.Number),
+		} {
+			if len(k) == 0 {
+				continue
+			}
+			err := tx.Bucket([]byte(index)).Put(k, key)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := putRune(tx, r)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(bucketStats).Put(key, encodeStats(btc_rune.NewRuneStats(r.ID)))
+		if err != nil {
+			return err
+		}
+		return addCount(tx, r.Version, 1)
+	})
+}
+
+func (t boltTx) DeleteRune(id btc_rune.RuneID) error {
+	return t.update(func(tx *bolt.Tx) error {
+		r, err := getRune(tx, runeIDKey(id))
+		if err == storage.ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, del := range []struct {
+			bucket []byte
+			key    []byte
+		}{
+			{bucketSymbols, []byte(r.Symbol)},
+			{bucketIssuance, []byte(r.IssuanceTx)},
+			{bucketNumbers, numberKey(r.Version, r.Number)},
+			{bucketRunes, runeIDKey(id)},
+			{bucketStats, runeIDKey(id)},
+		} {
+			if len(del.key) == 0 {
+				continue
+			}
+			err = tx.Bucket(del.bucket).Delete(del.key)
+			if err != nil {
+				return err
+			}
+		}
+		return addCount(tx, r.Version, -1)
+	})
+}
+
+func (t boltTx) AddMints(id btc_rune.RuneID, delta int64) error {
+	return t.update(func(tx *bolt.Tx) error {
+		r, err := getRune(tx, runeIDKey(id))
+		if err != nil {
+			return err
+		}
+		r.Mints = uint64(int64(r.Mints) + delta)
+		return putRune(tx, r)
+	})
+}
+
+func (t boltTx) RuneStats(id btc_rune.RuneID) (s *btc_rune.RuneStats, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		key := runeIDKey(id)
+		value := tx.Bucket(bucketStats).Get(key)
+		if value == nil {
+			return storage.ErrNotFound
+		}
+		s, err = decodeStats(key, value)
+		return err
+	})
+	return s, err
+}
+
+func (t boltTx) SaveRuneStats(s *btc_rune.RuneStats) error {
+	return t.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketStats)
+		key := runeIDKey(s.RuneID())
+		if b.Get(key) == nil {
+			return storage.ErrNotFound
+		}
+		return b.Put(key, encodeStats(s))
+	})
+}
+
+// Runes sorts in memory, the stats of every rune are small
+func (t boltTx) Runes(q storage.RuneQuery) (entries []*storage.RuneEntry, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		statsBucket := tx.Bucket(bucketStats)
+
+		var stats []*btc_rune.RuneStats
+		add := func(key []byte) error {
+			value := statsBucket.Get(key)
+			if value == nil {
+				return nil
+			}
+			s, err := decodeStats(key, value)
+			if err != nil {
+				return err
+			}
+			stats = append(stats, s)
+			return nil
+		}
+
+		if q.Prefix != "" {
+			prefix := []byte(q.Prefix)
+			c := tx.Bucket(bucketSymbols).Cursor()
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				if err := add(v); err != nil {
+					return err
+				}
+			}
+		} else {
+			err := statsBucket.ForEach(func(k, v []byte) error {
+				s, err := decodeStats(k, v)
+				if err != nil {
+					return err
+				}
+				stats = append(stats, s)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		storage.SortStats(stats, q)
+		if q.Offset >= len(stats) {
+			return nil
+		}
+		stats = stats[q.Offset:]
+		if q.Limit > 0 && q.Limit < len(stats) {
+			stats = stats[:q.Limit]
+		}
+
+		for _, s := range stats {
+			r, err := getRune(tx, runeIDKey(s.RuneID()))
+			if err != nil {
+				return err
+			}
+			entries = append(entries, &storage.RuneEntry{Rune: r, Stats: s})
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (t boltTx) Holding(address string, id btc_rune.RuneID) (amount btc_rune.Uint128, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		value := tx.Bucket(bucketHoldings).Get(holdingKey(address, id))
+		if value == nil {
+			return nil
+		}
+		amount, err = decodeAmount(value)
+		return err
+	})
+	return amount, err
+}
+
+func (t boltTx) SetHolding(h *btc_rune.Holding) error {
+	return t.update(func(tx *bolt.Tx) error {
+		holdings := tx.Bucket(bucketHoldings)
+		holders := tx.Bucket(bucketHolders)
+		key := holdingKey(h.Address, h.Rune)
+
+		if value := holdings.Get(key); value != nil {
+			previous, err := decodeAmount(value)
+			if err != nil {
+				return err
+			}
+			err = holders.Delete(holderKey(h.Rune, previous, h.Address))
+			if err != nil {
+				return err
+			}
+		}
+
+		if h.Amount.IsZero() {
+			return holdings.Delete(key)
+		}
+		err := holdings.Put(key, encodeAmount(h.Amount))
+		if err != nil {
+			return err
+		}
+		return holders.Put(holderKey(h.Rune, h.Amount, h.Address), nil)
+	})
+}
+
+func (t boltTx) Holders(id btc_rune.RuneID, offset, limit int) (holdings []*btc_rune.Holding, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		prefix := runeIDKey(id)
+		c := tx.Bucket(bucketHolders).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) && len(holdings) < limit; k, _ = c.Next() {
+			if offset > 0 {
+				offset--
+				continue
+			}
+			h, err := decodeHolder(k)
+			if err != nil {
+				return err
+			}
+			holdings = append(holdings, h)
+		}
+		return nil
+	})
+	return holdings, err
+}
+
+func (t boltTx) Balances(outpoints []wire.OutPoint) (balances []*btc_rune.RuneBalance, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketBalances)
+		for _, op := range outpoints {
+			key := outpointKey(op)
+			value := b.Get(key)
+			if value == nil {
+				continue
+			}
+
+			held, err := decodeBalances(key, value)
+			if err != nil {
+				return err
+			}
+			balances = append(balances, held...)
+		}
+		return nil
+	})
+	return balances, err
+}
+
+func (t boltTx) AddressBalances(address string) (balances []*btc_rune.RuneBalance, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketBalances)
+		prefix := addressPrefix(address)
+		c := tx.Bucket(bucketAddress).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			key := k[len(prefix):]
+			value := b.Get(key)
+			if value == nil {
+				return fmt.Errorf("%w: address %s indexes missing outpoint", errCorrupt, address)
+			}
+
+			held, err := decodeBalances(key, value)
+			if err != nil {
+				return err
+			}
+			balances = append(balances, held...)
+		}
+		return nil
+	})
+	return balances, err
+}
+
+func (t boltTx) CreateBalances(balances []*btc_rune.RuneBalance) error {
+	return t.modifyBalances(balances, func(held []*btc_rune.RuneBalance, b *btc_rune.RuneBalance) []*btc_rune.RuneBalance {
+		return append(removeRune(held, b.Rune), b)
+	})
+}
+
+func (t boltTx) DeleteBalances(balances []*btc_rune.RuneBalance) error {
+	return t.modifyBalances(balances, func(held []*btc_rune.RuneBalance, b *btc_rune.RuneBalance) []*btc_rune.RuneBalance {
+		return removeRune(held, b.Rune)
+	})
+}
+
+// modifyBalances rewrites the outpoint of every balance with modify applied
+func (t boltTx) modifyBalances(balances []*btc_rune.RuneBalance, modify func(held []*btc_rune.RuneBalance, b *btc_rune.RuneBalance) []*btc_rune.RuneBalance) error {
+	if len(balances) == 0 {
+		return nil
+	}
+
+	return t.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketBalances)
+		index := tx.Bucket(bucketAddress)
+		for _, b := range balances {
+			op, err := balanceOutpoint(b)
+			if err != nil {
+				return err
+			}
+
+			key := outpointKey(op)
+			var held []*btc_rune.RuneBalance
+			if value := bucket.Get(key); value != nil {
+				held, err = decodeBalances(key, value)
+				if err != nil {
+					return err
+				}
+			}
+
+			var address string
+			if len(held) > 0 {
+				address = held[0].Address
+			}
+
+			held = modify(held, b)
+			if len(held) == 0 {
+				err = bucket.Delete(key)
+			} else {
+				err = bucket.Put(key, encodeBalances(held))
+			}
+			if err != nil {
+				return err
+			}
+
+			err = indexAddress(index, key, address, held)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// indexAddress moves the address index entry of the outpoint key from the address
+// its balances were held by to the address of held, dropping it when nothing is held
+func indexAddress(index *bolt.Bucket, key []byte, address string, held []*btc_rune.RuneBalance) error {
+	var next string
+	if len(held) > 0 {
+		next = held[0].Address
+	}
+	if address == next && len(held) > 0 {
+		return nil
+	}
+
+	if address != "" {
+		err := index.Delete(append(addressPrefix(address), key...))
+		if err != nil {
+			return err
+		}
+	}
+	if next == "" {
+		return nil
+	}
+	return index.Put(append(addressPrefix(next), key...), nil)
+}
+
+func removeRune(held []*btc_rune.RuneBalance, id btc_rune.RuneID) []*btc_rune.RuneBalance {
+	kept := held[:0]
+	for _, b := range held {
+		if b.Rune != id {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+func (t boltTx) CreateEvent(e *btc_rune.Event) error {
+	return t.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketEvents)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		e.ID = id
+		if e.CreatedAt.IsZero() {
+			e.CreatedAt = time.Now()
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(heightKey(int64(id)), data)
+	})
+}
+
+func (t boltTx) Events(after uint64, eventType btc_rune.EventType, limit int) (events []*btc_rune.Event, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketEvents).Cursor()
+		for k, v := c.Seek(heightKey(int64(after + 1))); k != nil && len(events) < limit; k, v = c.Next() {
+			var e btc_rune.Event
+			err := json.Unmarshal(v, &e)
+			if err != nil {
+				return err
+			}
+			if eventType == "" || e.Type == eventType {
+				events = append(events, &e)
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (t boltTx) LastEvent() (event *btc_rune.Event, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		_, v := tx.Bucket(bucketEvents).Cursor().Last()
+		if v == nil {
+			return nil
+		}
+		event = &btc_rune.Event{}
+		return json.Unmarshal(v, event)
+	})
+	return event, err
+}
+
+// BlockEvents scans back from the newest event, reorgs only disconnect blocks near the tip
+func (t boltTx) BlockEvents(height int64, hash string) (events []*btc_rune.Event, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketEvents).Cursor()
+		k, v := c.Last()
+		for ; k != nil; k, v = c.Prev() {
+			var e btc_rune.Event
+			err := json.Unmarshal(v, &e)
+			if err != nil {
+				return err
+			}
+			if e.Type == btc_rune.EventBlockConnected && e.Height == height && e.BlockHash == hash {
+				break
+			}
+		}
+		if k == nil {
+			return nil
+		}
+
+		for k, v = c.Prev(); k != nil; k, v = c.Prev() {
+			e := &btc_rune.Event{}
+			err := json.Unmarshal(v, e)
+			if err != nil {
+				return err
+			}
+			if !e.Type.TxEvent() || e.Height != height || e.BlockHash != hash {
+				break
+			}
+			events = append(events, e)
+		}
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (t boltTx) Tip() (block *btc_rune.Block, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		_, v := tx.Bucket(bucketBlocks).Cursor().Last()
+		block, err = decodeBlock(v)
+		return err
+	})
+	return block, err
+}
+
+func (t boltTx) Block(height int64) (block *btc_rune.Block, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		block, err = decodeBlock(tx.Bucket(bucketBlocks).Get(heightKey(height)))
+		return err
+	})
+	return block, err
+}
+
+func decodeBlock(data []byte) (*btc_rune.Block, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	var block btc_rune.Block
+	err := json.Unmarshal(data, &block)
+	return &block, err
+}
+
+func (t boltTx) CreateBlock(b *btc_rune.Block) error {
+	return t.update(func(tx *bolt.Tx) error {
+		if b.CreatedAt.IsZero() {
+			b.CreatedAt = time.Now()
+		}
+		data, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketBlocks).Put(heightKey(b.Height), data)
+	})
+}
+
+func (t boltTx) DeleteBlock(height int64) error {
+	return t.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketBlocks).Delete(heightKey(height))
+	})
+}
+
+// Undo records are keyed by height then sequence, so a block's journal is one contiguous range
+func (t boltTx) CreateUndo(records []*btc_rune.UndoRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	return t.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketUndo)
+		for _, r := range records {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			r.ID = id
+
+			value := append([]byte{byte(r.Op)}, r.Data...)
+			err = b.Put(heightKey(r.Height, id), value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (t boltTx) Undo(height int64) (records []*btc_rune.UndoRecord, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		prefix := heightKey(height)
+		c := tx.Bucket(bucketUndo).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if len(k) != 16 || len(v) == 0 {
+				return errCorrupt
+			}
+			records = append(records, &btc_rune.UndoRecord{
+				ID:     binary.BigEndian.Uint64(k[8:]),
+				Height: height,
+				Op:     btc_rune.UndoOp(v[0]),
+				Data:   append([]byte(nil), v[1:]...),
+			})
+		}
+		return nil
+	})
+	return records, err
+}
+
+func (t boltTx) DeleteUndo(height int64) error {
+	return t.deleteUndo(func(h int64) bool { return h == height }, heightKey(height))
+}
+
+func (t boltTx) PruneUndo(height int64) error {
+	if height < 0 {
+		return nil
+	}
+	return t.deleteUndo(func(h int64) bool { return h <= height }, heightKey(0))
+}
+
+// deleteUndo deletes records from start on while match holds for their height
+func (t boltTx) deleteUndo(match func(height int64) bool, start []byte) error {
+	return t.update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketUndo).Cursor()
+		for k, _ := c.Seek(start); k != nil && match(int64(binary.BigEndian.Uint64(k))); k, _ = c.Seek(start) {
+			err := c.Delete()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (t boltTx) CreateWebhook(w *btc_rune.Webhook) error {
+	return t.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketWebhooks)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		w.ID = id
+		if w.CreatedAt.IsZero() {
+			w.CreatedAt = time.Now()
+		}
+		return putJSON(b, heightKey(int64(id)), w)
+	})
+}
+
+func (t boltTx) Webhook(id uint64) (w *btc_rune.Webhook, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		value := tx.Bucket(bucketWebhooks).Get(heightKey(int64(id)))
+		if value == nil {
+			return storage.ErrNotFound
+		}
+		w = &btc_rune.Webhook{}
+		return json.Unmarshal(value, w)
+	})
+	return w, err
+}
+
+func (t boltTx) Webhooks() (hooks []*btc_rune.Webhook, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketWebhooks).ForEach(func(_, value []byte) error {
+			w := &btc_rune.Webhook{}
+			err := json.Unmarshal(value, w)
+			hooks = append(hooks, w)
+			return err
+		})
+	})
+	return hooks, err
+}
+
+func (t boltTx) SaveWebhook(w *btc_rune.Webhook) error {
+	return t.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketWebhooks)
+		key := heightKey(int64(w.ID))
+		if b.Get(key) == nil {
+			return storage.ErrNotFound
+		}
+		return putJSON(b, key, w)
+	})
+}
+
+func (t boltTx) DeleteWebhook(id uint64) error {
+	return t.update(func(tx *bolt.Tx) error {
+		prefix := heightKey(int64(id))
+		c := tx.Bucket(bucketHookDeliveries).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+			d, err := getDelivery(tx, binary.BigEndian.Uint64(k[8:]))
+			if err != nil {
+				return err
+			}
+			err = unindexDelivery(tx, d)
+			if err != nil {
+				return err
+			}
+			err = tx.Bucket(bucketDeliveries).Delete(heightKey(int64(d.ID)))
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(bucketWebhooks).Delete(prefix)
+	})
+}
+
+func (t boltTx) CreateDeliveries(deliveries []*btc_rune.Delivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	return t.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketDeliveries)
+		now := time.Now()
+		for _, d := range deliveries {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			d.ID = id
+			if d.CreatedAt.IsZero() {
+				d.CreatedAt = now
+			}
+
+			err = putDelivery(tx, d)
+			if err != nil {
+				return err
+			}
+			err = tx.Bucket(bucketHookDeliveries).Put(heightKey(int64(d.Webhook), d.ID), nil)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (t boltTx) SaveDelivery(d *btc_rune.Delivery) error {
+	return t.update(func(tx *bolt.Tx) error {
+		prev, err := getDelivery(tx, d.ID)
+		if err != nil {
+			return err
+		}
+		if prev.Status == btc_rune.DeliveryPending {
+			err = tx.Bucket(bucketDue).Delete(dueKey(prev))
+			if err != nil {
+				return err
+			}
+		}
+		return putDelivery(tx, d)
+	})
+}
+
+func (t boltTx) DueDeliveries(now time.Time, limit int) (deliveries []*btc_rune.Delivery, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		end := heightKey(now.UnixNano(), ^uint64(0))
+		c := tx.Bucket(bucketDue).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, end) <= 0 && len(deliveries) < limit; k, _ = c.Next() {
+			d, err := getDelivery(tx, binary.BigEndian.Uint64(k[8:]))
+			if err != nil {
+				return err
+			}
+			deliveries = append(deliveries, d)
+		}
+		return nil
+	})
+	return deliveries, err
+}
+
+func (t boltTx) Deliveries(webhook uint64, status btc_rune.DeliveryStatus, after uint64, limit int) (deliveries []*btc_rune.Delivery, err error) {
+	err = t.view(func(tx *bolt.Tx) error {
+		prefix := heightKey(int64(webhook))
+		c := tx.Bucket(bucketHookDeliveries).Cursor()
+		for k, _ := c.Seek(heightKey(int64(webhook), after+1)); k != nil && bytes.HasPrefix(k, prefix) && len(deliveries) < limit; k, _ = c.Next() {
+			d, err := getDelivery(tx, binary.BigEndian.Uint64(k[8:]))
+			if err != nil {
+				return err
+			}
+			if status == "" || d.Status == status {
+				deliveries = append(deliveries, d)
+			}
+		}
+		return nil
+	})
+	return deliveries, err
+}
+
+func dueKey(d *btc_rune.Delivery) []byte {
+	return heightKey(d.NextAttempt.UnixNano(), d.ID)
+}
+
+func getDelivery(tx *bolt.Tx, id uint64) (*btc_rune.Delivery, error) {
+	value := tx.Bucket(bucketDeliveries).Get(heightKey(int64(id)))
+	if value == nil {
+		return nil, storage.ErrNotFound
+	}
+	d := &btc_rune.Delivery{}
+	err := json.Unmarshal(value, d)
+	if err != nil {
+		return nil, fmt.Errorf("%w: delivery: %s", errCorrupt, err)
+	}
+	return d, nil
+}
+
+// putDelivery stores d and adds it to the due index while it is pending
+func putDelivery(tx *bolt.Tx, d *btc_rune.Delivery) error {
+	err := putJSON(tx.Bucket(bucketDeliveries), heightKey(int64(d.ID)), d)
+	if err != nil || d.Status != btc_rune.DeliveryPending {
+		return err
+	}
+	return tx.Bucket(bucketDue).Put(dueKey(d), nil)
+}
+
+func unindexDelivery(tx *bolt.Tx, d *btc_rune.Delivery) error {
+	err := tx.Bucket(bucketHookDeliveries).Delete(heightKey(int64(d.Webhook), d.ID))
+	if err != nil || d.Status != btc_rune.DeliveryPending {
+		return err
+	}
+	return tx.Bucket(bucketDue).Delete(dueKey(d))
+}
+
+func putJSON(b *bolt.Bucket, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, data)
+}
@@ -0,0 +1,258 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// bucketSchema is the bolt schema_version table, keyed by 8 byte big endian version
+var bucketSchema = []byte("schema_version")
+
+// boltMigration is a numbered layout change of the bolt backend, run in one update with its schema_version record
+type boltMigration struct {
+	name string
+	up   func(tx *bolt.Tx) error
+	down func(tx *bolt.Tx) error
+}
+
+// boltMigrations are the bolt layout changes in order, the n'th entry is version n. Append only.
+var boltMigrations = []boltMigration{
+	{
+		name: "initial buckets",
+		up: func(tx *bolt.Tx) error {
+			for _, name := range bucketsV1 {
+				_, err := tx.CreateBucketIfNotExists(name)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		down: func(tx *bolt.Tx) error {
+			for _, name := range bucketsV1 {
+				err := tx.DeleteBucket(name)
+				if err != nil && err != bolt.ErrBucketNotFound {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		name: "address index",
+		up: func(tx *bolt.Tx) error {
+			index, err := tx.CreateBucketIfNotExists(bucketAddress)
+			if err != nil {
+				return err
+			}
+			return tx.Bucket(bucketBalances).ForEach(func(key, value []byte) error {
+				held, err := decodeBalances(key, value)
+				if err != nil {
+					return err
+				}
+				return indexAddress(index, key, "", held)
+			})
+		},
+		down: func(tx *bolt.Tx) error {
+			return tx.DeleteBucket(bucketAddress)
+		},
+	},
+	{
+		name: "rune stats and holdings",
+		up: func(tx *bolt.Tx) error {
+			for _, name := range [][]byte{bucketStats, bucketHoldings, bucketHolders} {
+				_, err := tx.CreateBucketIfNotExists(name)
+				if err != nil {
+					return err
+				}
+			}
+			return backfillBoltStats(tx)
+		},
+		down: func(tx *bolt.Tx) error {
+			for _, name := range [][]byte{bucketStats, bucketHoldings, bucketHolders} {
+				err := tx.DeleteBucket(name)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		name: "webhooks",
+		up: func(tx *bolt.Tx) error {
+			for _, name := range bucketsV4 {
+				_, err := tx.CreateBucketIfNotExists(name)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		down: func(tx *bolt.Tx) error {
+			for _, name := range bucketsV4 {
+				err := tx.DeleteBucket(name)
+				if err != nil && err != bolt.ErrBucketNotFound {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+var bucketsV1 = [][]byte{
+	[]byte("runes"), []byte("rune_symbols"), []byte("rune_issuance"), []byte("rune_numbers"), []byte("rune_counts"),
+	[]byte("balances"), []byte("events"), []byte("blocks"), []byte("undo"),
+}
+
+var bucketsV4 = [][]byte{
+	[]byte("webhooks"), []byte("deliveries"), []byte("delivery_due"), []byte("webhook_deliveries"),
+}
+
+// boltSchemaRecord is the value of a schema_version entry
+type boltSchemaRecord struct {
+	Name      string    `json:"name"`
+	AppliedAt time.Time `json:"appliedAt"`
+}
+
+func (s *boltStore) Migrate() error {
+	return s.MigrateTo(s.LatestSchema())
+}
+
+func (s *boltStore) LatestSchema() int {
+	return len(boltMigrations)
+}
+
+func (s *boltStore) SchemaVersion() (version int, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		version = boltSchemaVersion(tx)
+		return nil
+	})
+	return version, err
+}
+
+func boltSchemaVersion(tx *bolt.Tx) int {
+	b := tx.Bucket(bucketSchema)
+	if b == nil {
+		return 0
+	}
+	k, _ := b.Cursor().Last()
+	if k == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(k))
+}
+
+func (s *boltStore) MigrateTo(version int) error {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	steps, err := storage.Steps(current, version, s.LatestSchema())
+	if err != nil {
+		return err
+	}
+
+	for _, v := range steps {
+		m := boltMigrations[v-1]
+		err = s.db.Update(func(tx *bolt.Tx) error {
+			versions, err := tx.CreateBucketIfNotExists(bucketSchema)
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(v))
+
+			if v > current {
+				if err = m.up(tx); err != nil {
+					return err
+				}
+				record, err := json.Marshal(boltSchemaRecord{Name: m.name, AppliedAt: time.Now()})
+				if err != nil {
+					return err
+				}
+				return versions.Put(key, record)
+			}
+
+			if err = m.down(tx); err != nil {
+				return err
+			}
+			return versions.Delete(key)
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", v, m.name, err)
+		}
+	}
+	return nil
+}
+
+// backfillBoltStats derives the stats and holdings of every rune from its unspent balances.
+// Burns and transfers made before the stats existed are not recoverable and start at zero.
+func backfillBoltStats(tx *bolt.Tx) error {
+	stats := map[btc_rune.RuneID]*btc_rune.RuneStats{}
+	err := tx.Bucket(bucketRunes).ForEach(func(k, v []byte) error {
+		r, err := decodeRune(v)
+		if err != nil {
+			return err
+		}
+		stats[r.ID] = btc_rune.NewRuneStats(r.ID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	holdings := map[btc_rune.Holding]btc_rune.Uint128{}
+	err = tx.Bucket(bucketBalances).ForEach(func(k, v []byte) error {
+		held, err := decodeBalances(k, v)
+		if err != nil {
+			return err
+		}
+		for _, b := range held {
+			s, ok := stats[b.Rune]
+			if !ok {
+				continue
+			}
+			if s.Supply, err = s.Supply.Add(b.Amount); err != nil {
+				return err
+			}
+			if b.Height > s.LastHeight {
+				s.LastHeight = b.Height
+			}
+			if b.Address == "" {
+				continue
+			}
+			key := btc_rune.Holding{Address: b.Address, Rune: b.Rune}
+			if holdings[key], err = holdings[key].Add(b.Amount); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	t := boltTx{tx: tx}
+	for key, amount := range holdings {
+		stats[key.Rune].Holders++
+		err = t.SetHolding(&btc_rune.Holding{Address: key.Address, Rune: key.Rune, Amount: amount})
+		if err != nil {
+			return err
+		}
+	}
+	for id, s := range stats {
+		err = tx.Bucket(bucketStats).Put(runeIDKey(id), encodeStats(s))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/alphabatem/btc_rune/storage/storagetest"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func openBolt(t testing.TB) storage.Store {
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "rune.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestBoltStore(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store { return openBolt(t) })
+}
+
+func TestBalanceEncoding(t *testing.T) {
+	held := []*btc_rune.RuneBalance{
+		storagetest.Balance(1, 7, btc_rune.RuneID{Block: 840000, Tx: 1}, 1),
+		storagetest.Balance(1, 7, btc_rune.RuneID{Block: 1, Tx: 0}, 0),
+		storagetest.Balance(1, 7, btc_rune.RuneID{Block: ^uint64(0), Tx: ^uint32(0)}, 0),
+	}
+	held[2].Amount = btc_rune.MaxUint128
+
+	op, err := balanceOutpoint(held[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, value := outpointKey(op), encodeBalances(held)
+
+	// Three runes on one output cost little more than the shared fields
+	if len(value) > 64 {
+		t.Fatalf("Expected a compact encoding - Got %d bytes", len(value))
+	}
+
+	decoded, err := decodeBalances(key, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(held) {
+		t.Fatalf("Expected %d balances - Got %d", len(held), len(decoded))
+	}
+	for i, b := range decoded {
+		if *b != *held[i] {
+			t.Fatalf("%d: Expected %+v - Got %+v", i, held[i], b)
+		}
+	}
+
+	for _, corrupt := range [][]byte{nil, value[:len(value)-1], append(append([]byte{}, value...), 0)} {
+		if _, err = decodeBalances(key, corrupt); err == nil {
+			t.Fatalf("%x: Expected error", corrupt)
+		}
+	}
+}
+
+// BenchmarkStores applies blocks of outpoint spends and credits through each backend
+func BenchmarkStores(b *testing.B) {
+	for name, open := range map[string]func(testing.TB) storage.Store{
+		"sqlite": func(t testing.TB) storage.Store {
+			s, err := NewSqliteStore(filepath.Join(t.TempDir(), "rune.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = s.Migrate(); err != nil {
+				t.Fatal(err)
+			}
+			return s
+		},
+		"bolt": openBolt,
+	} {
+		b.Run(name, func(b *testing.B) {
+			s := open(b)
+			defer s.Close()
+
+			id := btc_rune.RuneID{Block: 840000, Tx: 1}
+			const perBlock = 200
+			var previous []*btc_rune.RuneBalance
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				credits := make([]*btc_rune.RuneBalance, perBlock)
+				outpoints := make([]wire.OutPoint, perBlock)
+				for j := range credits {
+					credits[j] = storagetest.Balance(0, uint32(j), id, uint64(j))
+					credits[j].TxID = fmt.Sprintf("%064x", i+1)
+					credits[j].Height = int64(i)
+					if previous != nil {
+						op, _ := balanceOutpoint(previous[j])
+						outpoints[j] = op
+					}
+				}
+
+				err := s.Atomic(func(tx storage.Tx) error {
+					spent, err := tx.Balances(outpoints)
+					if err != nil {
+						return err
+					}
+					if err = tx.DeleteBalances(spent); err != nil {
+						return err
+					}
+					if err = tx.CreateBalances(credits); err != nil {
+						return err
+					}
+					return tx.CreateBlock(&btc_rune.Block{Height: int64(i), Hash: fmt.Sprintf("%064x", i+1)})
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				previous = credits
+			}
+		})
+	}
+}
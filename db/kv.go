@@ -0,0 +1,280 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/codec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Binary layout of the key-value backend.
+//
+// Integers in keys are big endian so keys sort numerically:
+//
+//	outpoint  txid (32, internal order) | vout (4)
+//	rune id   block (8) | tx (4)
+//	undo      height (8) | sequence (8)
+//	address   address | 0x00 | outpoint, an index of the outpoints paying address
+//	holding   address | 0x00 | rune id
+//	holder    rune id | ^amount (16) | address, so a rune's holders iterate largest first
+//	delivery  webhook (8) | id (8), and while pending next attempt unix nanoseconds (8) | id (8)
+//
+// An outpoint's balances are stored together as
+//
+//	height varint | value varint | address length uvarint | address | count uvarint
+//	count x ( block uvarint | tx uvarint | amount LEB128 )
+//
+// since every rune on an output shares its address, value and height.
+
+var errCorrupt = errors.New("corrupt kv record")
+
+const (
+	outpointKeyLen = chainhash.HashSize + 4
+	runeIDKeyLen   = 12
+)
+
+func outpointKey(op wire.OutPoint) []byte {
+	key := make([]byte, outpointKeyLen)
+	copy(key, op.Hash[:])
+	binary.BigEndian.PutUint32(key[chainhash.HashSize:], op.Index)
+	return key
+}
+
+// balanceOutpoint returns the outpoint b is held by
+func balanceOutpoint(b *btc_rune.RuneBalance) (wire.OutPoint, error) {
+	hash, err := chainhash.NewHashFromStr(b.TxID)
+	if err != nil {
+		return wire.OutPoint{}, err
+	}
+	return wire.OutPoint{Hash: *hash, Index: b.Vout}, nil
+}
+
+func runeIDKey(id btc_rune.RuneID) []byte {
+	key := make([]byte, runeIDKeyLen)
+	binary.BigEndian.PutUint64(key, id.Block)
+	binary.BigEndian.PutUint32(key[8:], id.Tx)
+	return key
+}
+
+// addressPrefix prefixes the address index keys of address, addresses never contain a zero byte
+func addressPrefix(address string) []byte {
+	return append([]byte(address), 0)
+}
+
+func holdingKey(address string, id btc_rune.RuneID) []byte {
+	return append(addressPrefix(address), runeIDKey(id)...)
+}
+
+func holderKey(id btc_rune.RuneID, amount btc_rune.Uint128, address string) []byte {
+	key := make([]byte, runeIDKeyLen+16, runeIDKeyLen+16+len(address))
+	copy(key, runeIDKey(id))
+	binary.BigEndian.PutUint64(key[runeIDKeyLen:], ^amount.Hi)
+	binary.BigEndian.PutUint64(key[runeIDKeyLen+8:], ^amount.Lo)
+	return append(key, address...)
+}
+
+// decodeHolder decodes a holder key
+func decodeHolder(key []byte) (*btc_rune.Holding, error) {
+	if len(key) < runeIDKeyLen+16 {
+		return nil, fmt.Errorf("%w: holder of %d bytes", errCorrupt, len(key))
+	}
+	return &btc_rune.Holding{
+		Address: string(key[runeIDKeyLen+16:]),
+		Rune: btc_rune.RuneID{
+			Block: binary.BigEndian.Uint64(key),
+			Tx:    binary.BigEndian.Uint32(key[8:]),
+		},
+		Amount: btc_rune.Uint128{
+			Hi: ^binary.BigEndian.Uint64(key[runeIDKeyLen:]),
+			Lo: ^binary.BigEndian.Uint64(key[runeIDKeyLen+8:]),
+		},
+	}, nil
+}
+
+func encodeAmount(amount btc_rune.Uint128) []byte {
+	var buf bytes.Buffer
+	codec.PutUvarint128(&buf, amount)
+	return buf.Bytes()
+}
+
+func decodeAmount(value []byte) (btc_rune.Uint128, error) {
+	amount, err := codec.ReadUvarint128(bytes.NewReader(value))
+	if err != nil {
+		return btc_rune.Uint128{}, fmt.Errorf("%w: amount: %s", errCorrupt, err)
+	}
+	return amount, nil
+}
+
+// encodeStats encodes the stats of a rune, stored under its id
+//
+//	supply LEB128 | burned LEB128 | holders uvarint | transfers uvarint | last height varint
+func encodeStats(s *btc_rune.RuneStats) []byte {
+	var buf bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+
+	codec.PutUvarint128(&buf, s.Supply)
+	codec.PutUvarint128(&buf, s.Burned)
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], s.Holders)])
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], s.Transfers)])
+	buf.Write(tmp[:binary.PutVarint(tmp[:], s.LastHeight)])
+	return buf.Bytes()
+}
+
+func decodeStats(key, value []byte) (*btc_rune.RuneStats, error) {
+	if len(key) != runeIDKeyLen {
+		return nil, fmt.Errorf("%w: rune id of %d bytes", errCorrupt, len(key))
+	}
+	s := &btc_rune.RuneStats{
+		Block: binary.BigEndian.Uint64(key),
+		Tx:    binary.BigEndian.Uint32(key[8:]),
+	}
+
+	r := bytes.NewReader(value)
+	var err error
+	if s.Supply, err = codec.ReadUvarint128(r); err != nil {
+		return nil, fmt.Errorf("%w: supply: %s", errCorrupt, err)
+	}
+	if s.Burned, err = codec.ReadUvarint128(r); err != nil {
+		return nil, fmt.Errorf("%w: burned: %s", errCorrupt, err)
+	}
+	if s.Holders, err = binary.ReadUvarint(r); err != nil {
+		return nil, fmt.Errorf("%w: holders: %s", errCorrupt, err)
+	}
+	if s.Transfers, err = binary.ReadUvarint(r); err != nil {
+		return nil, fmt.Errorf("%w: transfers: %s", errCorrupt, err)
+	}
+	if s.LastHeight, err = binary.ReadVarint(r); err != nil {
+		return nil, fmt.Errorf("%w: last height: %s", errCorrupt, err)
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", errCorrupt, r.Len())
+	}
+	return s, nil
+}
+
+// heightKey prefixes keys by height, negative heights never occur
+func heightKey(height int64, suffix ...uint64) []byte {
+	key := make([]byte, 8+8*len(suffix))
+	binary.BigEndian.PutUint64(key, uint64(height))
+	for i, v := range suffix {
+		binary.BigEndian.PutUint64(key[8+8*i:], v)
+	}
+	return key
+}
+
+// numberKey indexes a rune by its protocol version and issuance number
+func numberKey(version btc_rune.Protocol, number uint64) []byte {
+	key := make([]byte, 9)
+	key[0] = byte(version)
+	binary.BigEndian.PutUint64(key[1:], number)
+	return key
+}
+
+// kvRune stores a rune as JSON with its protocol version as a plain number,
+// the API text form does not round trip versions it does not know
+type kvRune struct {
+	*btc_rune.Rune
+	Version uint8 `json:"version"`
+}
+
+func encodeRune(r *btc_rune.Rune) ([]byte, error) {
+	return json.Marshal(kvRune{Rune: r, Version: uint8(r.Version)})
+}
+
+func decodeRune(data []byte) (*btc_rune.Rune, error) {
+	k := kvRune{Rune: &btc_rune.Rune{}}
+	err := json.Unmarshal(data, &k)
+	if err != nil {
+		return nil, fmt.Errorf("%w: rune: %s", errCorrupt, err)
+	}
+	k.Rune.Version = btc_rune.Protocol(k.Version)
+	return k.Rune, nil
+}
+
+// encodeBalances encodes the balances of a single outpoint
+func encodeBalances(balances []*btc_rune.RuneBalance) []byte {
+	var buf bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+
+	first := balances[0]
+	buf.Write(tmp[:binary.PutVarint(tmp[:], first.Height)])
+	buf.Write(tmp[:binary.PutVarint(tmp[:], first.Value)])
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], uint64(len(first.Address)))])
+	buf.WriteString(first.Address)
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], uint64(len(balances)))])
+
+	for _, b := range balances {
+		buf.Write(tmp[:binary.PutUvarint(tmp[:], b.Rune.Block)])
+		buf.Write(tmp[:binary.PutUvarint(tmp[:], uint64(b.Rune.Tx))])
+		codec.PutUvarint128(&buf, b.Amount)
+	}
+	return buf.Bytes()
+}
+
+// decodeBalances decodes the balances stored under an outpoint key
+func decodeBalances(key, value []byte) ([]*btc_rune.RuneBalance, error) {
+	if len(key) != outpointKeyLen {
+		return nil, fmt.Errorf("%w: outpoint of %d bytes", errCorrupt, len(key))
+	}
+	var hash chainhash.Hash
+	copy(hash[:], key)
+	txID := hash.String()
+	vout := binary.BigEndian.Uint32(key[chainhash.HashSize:])
+
+	r := bytes.NewReader(value)
+	height, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: height: %s", errCorrupt, err)
+	}
+	sats, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: value: %s", errCorrupt, err)
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return nil, fmt.Errorf("%w: address length", errCorrupt)
+	}
+	address := make([]byte, n)
+	_, _ = r.Read(address)
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil || count > uint64(r.Len()) {
+		return nil, fmt.Errorf("%w: balance count", errCorrupt)
+	}
+
+	balances := make([]*btc_rune.RuneBalance, count)
+	for i := range balances {
+		block, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: rune block: %s", errCorrupt, err)
+		}
+		tx, err := binary.ReadUvarint(r)
+		if err != nil || tx > uint64(^uint32(0)) {
+			return nil, fmt.Errorf("%w: rune tx", errCorrupt)
+		}
+		amount, err := codec.ReadUvarint128(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: amount: %s", errCorrupt, err)
+		}
+
+		balances[i] = &btc_rune.RuneBalance{
+			TxID:    txID,
+			Vout:    vout,
+			Rune:    btc_rune.RuneID{Block: block, Tx: uint32(tx)},
+			Amount:  amount,
+			Address: string(address),
+			Value:   sats,
+			Height:  height,
+		}
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", errCorrupt, r.Len())
+	}
+	return balances, nil
+}
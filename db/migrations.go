@@ -0,0 +1,317 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/storage"
+	"gorm.io/gorm"
+)
+
+// sqlMigration is a numbered schema change of the SQL backends, run in a transaction with its schema_version row
+type sqlMigration struct {
+	name string
+	up   func(tx *gorm.DB) error
+	down func(tx *gorm.DB) error
+}
+
+// sqlMigrations are the SQL schema changes in order, the n'th entry is version n.
+//
+// Append only: a released migration never changes, so the models it creates
+// are frozen copies rather than the live btc_rune types.
+var sqlMigrations = []sqlMigration{
+	{
+		name: "initial schema",
+		// AutoMigrate also adopts databases created before migrations were versioned
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&runeV1{}, &runeBalanceV1{}, &blockV1{}, &undoRecordV1{}, &eventV1{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&runeV1{}, &runeBalanceV1{}, &blockV1{}, &undoRecordV1{}, &eventV1{})
+		},
+	},
+	{
+		name: "rune stats and holdings",
+		up: func(tx *gorm.DB) error {
+			err := tx.AutoMigrate(&runeStatsV2{}, &holdingV2{})
+			if err != nil {
+				return err
+			}
+			return backfillSQLStats(tx)
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&runeStatsV2{}, &holdingV2{})
+		},
+	},
+	{
+		name: "webhooks",
+		up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&webhookV3{}, &deliveryV3{})
+		},
+		down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&webhookV3{}, &deliveryV3{})
+		},
+	},
+}
+
+// schemaVersion records an applied migration, the highest version is the schema version
+type schemaVersion struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaVersion) TableName() string { return "schema_version" }
+
+func (s *gormStore) Migrate() error {
+	return s.MigrateTo(s.LatestSchema())
+}
+
+func (s *gormStore) LatestSchema() int {
+	return len(sqlMigrations)
+}
+
+func (s *gormStore) SchemaVersion() (int, error) {
+	if !s.db.Migrator().HasTable(&schemaVersion{}) {
+		return 0, nil
+	}
+
+	var version int
+	err := s.db.Model(&schemaVersion{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// MigrateTo runs each step in its own transaction, concurrent migrators collide on the
+// schema_version primary key and all but one roll back
+func (s *gormStore) MigrateTo(version int) error {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	steps, err := storage.Steps(current, version, s.LatestSchema())
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+
+	err = s.db.AutoMigrate(&schemaVersion{})
+	if err != nil {
+		return err
+	}
+
+	for _, v := range steps {
+		m := sqlMigrations[v-1]
+		err = s.db.Transaction(func(tx *gorm.DB) error {
+			if v > current {
+				if err := m.up(tx); err != nil {
+					return err
+				}
+				return tx.Create(&schemaVersion{Version: v, Name: m.name, AppliedAt: time.Now()}).Error
+			}
+
+			if err := m.down(tx); err != nil {
+				return err
+			}
+			return tx.Where("version = ?", v).Delete(&schemaVersion{}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", v, m.name, err)
+		}
+	}
+	return nil
+}
+
+// backfillSQLStats derives the stats and holdings of every rune from its unspent balances.
+// Burns and transfers made before the stats existed are not recoverable and start at zero.
+func backfillSQLStats(tx *gorm.DB) error {
+	var ids []btc_rune.RuneID
+	err := tx.Model(&runeV1{}).Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+	stats := map[btc_rune.RuneID]*runeStatsV2{}
+	for _, id := range ids {
+		stats[id] = &runeStatsV2{Block: id.Block, Tx: id.Tx}
+	}
+
+	holdings := map[btc_rune.Holding]btc_rune.Uint128{}
+	rows, err := tx.Model(&runeBalanceV1{}).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b runeBalanceV1
+		err = tx.ScanRows(rows, &b)
+		if err != nil {
+			return err
+		}
+		s, ok := stats[b.Rune]
+		if !ok {
+			continue
+		}
+		if s.Supply, err = s.Supply.Add(b.Amount); err != nil {
+			return err
+		}
+		if b.Height > s.LastHeight {
+			s.LastHeight = b.Height
+		}
+		if b.Address == "" {
+			continue
+		}
+		key := btc_rune.Holding{Address: b.Address, Rune: b.Rune}
+		if holdings[key], err = holdings[key].Add(b.Amount); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	var held []*holdingV2
+	for key, amount := range holdings {
+		stats[key.Rune].Holders++
+		held = append(held, &holdingV2{Address: key.Address, Rune: key.Rune, Amount: amount})
+	}
+	var rowsV2 []*runeStatsV2
+	for _, s := range stats {
+		rowsV2 = append(rowsV2, s)
+	}
+
+	if len(rowsV2) > 0 {
+		if err = tx.CreateInBatches(rowsV2, 500).Error; err != nil {
+			return err
+		}
+	}
+	if len(held) > 0 {
+		return tx.CreateInBatches(held, 500).Error
+	}
+	return nil
+}
+
+// Models as of version 1
+
+type runeV1 struct {
+	ID             btc_rune.RuneID `gorm:"primaryKey"`
+	Number         uint64          `gorm:"index"`
+	Version        btc_rune.Protocol
+	IssuanceTx     string `gorm:"index"`
+	Symbol         string `gorm:"index"`
+	Decimals       uint64
+	Mints          uint64
+	Spacers        uint32
+	CurrencySymbol string
+	Premine        btc_rune.Uint128
+	Terms          *termsV1 `gorm:"embedded;embeddedPrefix:terms_"`
+	Turbo          bool
+}
+
+func (runeV1) TableName() string { return "runes" }
+
+type termsV1 struct {
+	Amount      *btc_rune.Uint128
+	Cap         *btc_rune.Uint128
+	HeightStart *uint64
+	HeightEnd   *uint64
+	OffsetStart *uint64
+	OffsetEnd   *uint64
+}
+
+type runeBalanceV1 struct {
+	TxID    string          `gorm:"primaryKey"`
+	Vout    uint32          `gorm:"primaryKey"`
+	Rune    btc_rune.RuneID `gorm:"primaryKey;index"`
+	Amount  btc_rune.Uint128
+	Address string `gorm:"index"`
+	Value   int64
+	Height  int64
+}
+
+func (runeBalanceV1) TableName() string { return "rune_balances" }
+
+type blockV1 struct {
+	Height    int64  `gorm:"primaryKey;autoIncrement:false"`
+	Hash      string `gorm:"uniqueIndex"`
+	PrevHash  string
+	CreatedAt time.Time
+}
+
+func (blockV1) TableName() string { return "blocks" }
+
+type undoRecordV1 struct {
+	ID     uint64 `gorm:"primaryKey"`
+	Height int64  `gorm:"index"`
+	Op     btc_rune.UndoOp
+	Data   []byte
+}
+
+func (undoRecordV1) TableName() string { return "undo_records" }
+
+type eventV1 struct {
+	ID        uint64             `gorm:"primaryKey"`
+	Type      btc_rune.EventType `gorm:"index"`
+	Height    int64              `gorm:"index"`
+	BlockHash string
+	Data      []byte
+	CreatedAt time.Time
+}
+
+func (eventV1) TableName() string { return "events" }
+
+// Models as of version 2
+
+type runeStatsV2 struct {
+	Block      uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Tx         uint32 `gorm:"primaryKey;autoIncrement:false"`
+	Supply     btc_rune.Uint128
+	Burned     btc_rune.Uint128
+	Holders    uint64 `gorm:"index"`
+	Transfers  uint64 `gorm:"index"`
+	LastHeight int64
+}
+
+func (runeStatsV2) TableName() string { return "rune_stats" }
+
+type holdingV2 struct {
+	Address string          `gorm:"primaryKey"`
+	Rune    btc_rune.RuneID `gorm:"primaryKey;index"`
+	Amount  btc_rune.Uint128
+}
+
+func (holdingV2) TableName() string { return "holdings" }
+
+// Models as of version 3
+
+type webhookV3 struct {
+	ID        uint64 `gorm:"primaryKey"`
+	URL       string
+	Secret    string
+	Runes     []byte
+	Addresses []byte
+	Types     []byte
+	Since     uint64
+	Cursor    uint64
+	CreatedAt time.Time
+}
+
+func (webhookV3) TableName() string { return "webhooks" }
+
+type deliveryV3 struct {
+	ID          uint64 `gorm:"primaryKey"`
+	Webhook     uint64 `gorm:"index"`
+	Event       uint64
+	Type        btc_rune.EventType
+	Payload     []byte
+	Status      btc_rune.DeliveryStatus `gorm:"index"`
+	Attempts    int
+	NextAttempt time.Time `gorm:"index"`
+	LastStatus  int
+	LastError   string
+	CreatedAt   time.Time
+	DeliveredAt *time.Time
+}
+
+func (deliveryV3) TableName() string { return "deliveries" }
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/alphabatem/btc_rune/storage/storagetest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestSqliteSchemaTooNew(t *testing.T) {
+	s, err := NewSqliteStore(filepath.Join(t.TempDir(), "rune.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	db := s.(*gormStore).db
+	if err = db.Create(&schemaVersion{Version: s.LatestSchema() + 1, Name: "from the future"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Migrate(); !errors.Is(err, storage.ErrSchemaTooNew) {
+		t.Fatalf("Expected ErrSchemaTooNew - Got %v", err)
+	}
+}
+
+func TestBoltSchemaTooNew(t *testing.T) {
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "rune.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.(*boltStore).db.Update(func(tx *bolt.Tx) error {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(s.LatestSchema()+1))
+		return tx.Bucket(bucketSchema).Put(key, []byte("{}"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Migrate(); !errors.Is(err, storage.ErrSchemaTooNew) {
+		t.Fatalf("Expected ErrSchemaTooNew - Got %v", err)
+	}
+}
+
+// A database created by AutoMigrate before schema versions were recorded keeps its data
+func TestSqliteAdoptUnversioned(t *testing.T) {
+	db, err := OpenSqlite(filepath.Join(t.TempDir(), "rune.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&btc_rune.Rune{}, &btc_rune.RuneBalance{}, &btc_rune.Block{}, &btc_rune.UndoRecord{}, &btc_rune.Event{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &gormStore{gormTx{db: db}}
+	defer s.Close()
+	if err = s.CreateBlock(&btc_rune.Block{Height: 840000, Hash: "kept"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	version, err := s.SchemaVersion()
+	if err != nil || version != s.LatestSchema() {
+		t.Fatalf("Expected version %d - Got %d %v", s.LatestSchema(), version, err)
+	}
+	tip, err := s.Tip()
+	if err != nil || tip == nil || tip.Hash != "kept" {
+		t.Fatalf("Expected adopted tip - Got %+v %v", tip, err)
+	}
+}
+
+// The address index is built from the balances stored before it existed
+func TestBoltAddressIndexBackfill(t *testing.T) {
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "rune.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.MigrateTo(1); err != nil {
+		t.Fatal(err)
+	}
+
+	balance := storagetest.Balance(1, 2, btc_rune.RuneID{Block: 840000, Tx: 1}, 10)
+	err = s.(*boltStore).db.Update(func(tx *bolt.Tx) error {
+		op, err := balanceOutpoint(balance)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketBalances).Put(outpointKey(op), encodeBalances([]*btc_rune.RuneBalance{balance}))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.AddressBalances(balance.Address)
+	if err != nil || len(got) != 1 || *got[0] != *balance {
+		t.Fatalf("Expected backfilled balance %+v - Got %+v %v", balance, got, err)
+	}
+}
+
+// Stats and holdings are derived from the runes and balances stored before they existed
+func TestStatsBackfill(t *testing.T) {
+	id := btc_rune.RuneID{Block: 840000, Tx: 1}
+	balances := []*btc_rune.RuneBalance{
+		storagetest.Balance(1, 0, id, 10),
+		storagetest.Balance(1, 1, id, 5),
+		storagetest.Balance(2, 0, id, 7),
+	}
+	balances[2].Address = "bc1qother"
+	balances[2].Height = 840005
+
+	check := func(t *testing.T, s storage.Store) {
+		if err := s.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		stats, err := s.RuneStats(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Supply != btc_rune.NewUint128(22) || stats.Holders != 2 || stats.LastHeight != 840005 {
+			t.Fatalf("Expected backfilled stats - Got %+v", stats)
+		}
+		holders, err := s.Holders(id, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(holders) != 2 || holders[0].Address != "bc1qtest" || holders[0].Amount != btc_rune.NewUint128(15) {
+			t.Fatalf("Expected backfilled holders - Got %+v", holders)
+		}
+	}
+
+	t.Run("sqlite", func(t *testing.T) {
+		s, err := NewSqliteStore(filepath.Join(t.TempDir(), "rune.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		if err = s.MigrateTo(1); err != nil {
+			t.Fatal(err)
+		}
+
+		db := s.(*gormStore).db
+		if err = db.Create(&runeV1{ID: id, Symbol: "BACKFILL"}).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err = s.CreateBalances(balances); err != nil {
+			t.Fatal(err)
+		}
+		check(t, s)
+	})
+
+	t.Run("bolt", func(t *testing.T) {
+		s, err := NewBoltStore(filepath.Join(t.TempDir(), "rune.bolt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		if err = s.MigrateTo(2); err != nil {
+			t.Fatal(err)
+		}
+
+		err = s.(*boltStore).db.Update(func(tx *bolt.Tx) error {
+			return putRune(tx, &btc_rune.Rune{ID: id, Symbol: "BACKFILL"})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = s.CreateBalances(balances); err != nil {
+			t.Fatal(err)
+		}
+		check(t, s)
+	})
+}
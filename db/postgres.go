@@ -0,0 +1,97 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/cloakd/common/context"
+	"github.com/cloakd/common/services"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"os"
+)
+
+// PostgresService stores the index in PostgreSQL, which several API replicas can share
+type PostgresService struct {
+	services.DefaultService
+	db    *gorm.DB
+	store storage.Store
+
+	dsn string
+}
+
+// Id returns Service ID, shared by every storage backend
+func (ds PostgresService) Id() string {
+	return STORAGE_SVC
+}
+
+// Db Access to raw PostgresService db
+func (ds PostgresService) Db() *gorm.DB {
+	return ds.db
+}
+
+// Store returns the index repository
+func (ds PostgresService) Store() storage.Store {
+	return ds.store
+}
+
+// Configure reads DB_DSN, or builds it from DB_HOST, DB_PORT, DB_USERNAME, DB_PASSWORD, DB_DATABASE and DB_SSLMODE
+func (ds *PostgresService) Configure(ctx *context.Context) error {
+	ds.dsn = os.Getenv("DB_DSN")
+	if ds.dsn == "" {
+		ds.dsn = fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+			env("DB_HOST", "localhost"),
+			env("DB_PORT", "5432"),
+			os.Getenv("DB_USERNAME"),
+			os.Getenv("DB_PASSWORD"),
+			os.Getenv("DB_DATABASE"),
+			env("DB_SSLMODE", "disable"),
+		)
+	}
+
+	return ds.DefaultService.Configure(ctx)
+}
+
+// Start the service and open connection to the database
+func (ds *PostgresService) Start() (err error) {
+	ds.db, err = OpenPostgres(ds.dsn)
+	if err != nil {
+		return err
+	}
+
+	ds.store = &gormStore{gormTx{db: ds.db}}
+	return nil
+}
+
+// Shutdown Gracefully close the database connection
+func (ds *PostgresService) Shutdown() {
+	if ds.store != nil {
+		_ = ds.store.Close()
+	}
+}
+
+// OpenPostgres connects to the PostgreSQL database at dsn
+func OpenPostgres(dsn string) (*gorm.DB, error) {
+	return gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger:      logger.Default.LogMode(logger.Error),
+		PrepareStmt: true,
+	})
+}
+
+// NewPostgresStore connects to the PostgreSQL database at dsn as a storage backend
+func NewPostgresStore(dsn string) (storage.Store, error) {
+	db, err := OpenPostgres(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &gormStore{gormTx{db: db}}, nil
+}
+
+// env returns the variable key, or def when unset
+func env(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
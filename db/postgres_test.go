@@ -0,0 +1,45 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/alphabatem/btc_rune/storage/storagetest"
+)
+
+// TestPostgresStore runs against the database in POSTGRES_TEST_DSN, a keyword/value DSN such as
+// "host=localhost user=postgres password=postgres dbname=rune_test sslmode=disable".
+// Every case gets a schema of its own which is dropped afterwards.
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN not set")
+	}
+
+	admin, err := OpenPostgres(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		schema := fmt.Sprintf("storagetest_%d", time.Now().UnixNano())
+		if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		})
+
+		s, err := NewPostgresStore(dsn + " search_path=" + schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
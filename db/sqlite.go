@@ -1,21 +1,22 @@
 package db
 
 import (
-	"errors"
 	"fmt"
 
+	"github.com/alphabatem/btc_rune/storage"
 	"github.com/cloakd/common/context"
 	"github.com/cloakd/common/services"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
-	"log"
 	"os"
 )
 
+// SqliteService stores the index in a single SQLite file
 type SqliteService struct {
 	services.DefaultService
-	db *gorm.DB
+	db    *gorm.DB
+	store storage.Store
 
 	username string
 	password string
@@ -23,11 +24,9 @@ type SqliteService struct {
 	host     string
 }
 
-const SQLITE_SVC = "sqlite_svc"
-
-// Id returns Service ID
+// Id returns Service ID, shared by every storage backend
 func (ds SqliteService) Id() string {
-	return SQLITE_SVC
+	return STORAGE_SVC
 }
 
 // Db Access to raw SqliteService db
@@ -35,6 +34,11 @@ func (ds SqliteService) Db() *gorm.DB {
 	return ds.db
 }
 
+// Store returns the index repository
+func (ds SqliteService) Store() storage.Store {
+	return ds.store
+}
+
 // Configure the service
 func (ds *SqliteService) Configure(ctx *context.Context) error {
 	ds.database = fmt.Sprintf("%s", os.Getenv("DB_DATABASE"))
@@ -43,72 +47,36 @@ func (ds *SqliteService) Configure(ctx *context.Context) error {
 }
 
 // Start the service and open connection to the database
-// Migrate any tables that have changed since last runtime
 func (ds *SqliteService) Start() (err error) {
-	ds.db, err = gorm.Open(sqlite.Open(ds.database), &gorm.Config{
-		Logger:      logger.Default.LogMode(logger.Error),
-		PrepareStmt: true,
-	})
+	ds.db, err = OpenSqlite(ds.database)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-// Find returns the db query for a statement
-func (ds *SqliteService) Find(out interface{}, where string, args ...interface{}) error {
-	return ds.error(ds.db.Find(out, where, args).Error)
-}
-
-// Create a new item in the SqliteService
-func (ds *SqliteService) Create(val interface{}) (interface{}, error) {
-	err := ds.db.Create(val).Error
-	return val, ds.error(err)
-}
-
-// Update an existing item
-func (ds *SqliteService) Update(old interface{}, new interface{}) (interface{}, error) {
-	err := ds.db.Model(old).Updates(new).Error
-	return new, ds.error(err)
-}
-
-// Delete an existing item
-func (ds *SqliteService) Delete(val interface{}) error {
-	err := ds.db.Delete(val).Error
-	return ds.error(err)
-}
-
-// Migrate creates any new tables needed
-func (ds *SqliteService) Migrate(values ...interface{}) error {
-	err := ds.db.AutoMigrate(values).Error()
-	if err != "" {
-		return errors.New(err)
-	}
+	ds.store = &gormStore{gormTx{db: ds.db}}
 	return nil
 }
 
 // Shutdown Gracefully close the database connection
 func (ds *SqliteService) Shutdown() {
-	//
-}
-
-// Parse an error returned from the database into a more contextual error that can be used with http response codes
-func (ds *SqliteService) error(err error) error {
-	if err == nil {
-		return nil
+	if ds.store != nil {
+		_ = ds.store.Close()
 	}
+}
 
-	var code int
+// OpenSqlite opens the SQLite database at path
+func OpenSqlite(path string) (*gorm.DB, error) {
+	return gorm.Open(sqlite.Open(path), &gorm.Config{
+		Logger:      logger.Default.LogMode(logger.Error),
+		PrepareStmt: true,
+	})
+}
 
-	switch err {
-	case gorm.ErrRecordNotFound:
-		code = 404
-		break
-	default:
-		code = 500
+// NewSqliteStore opens the SQLite database at path as a storage backend
+func NewSqliteStore(path string) (storage.Store, error) {
+	db, err := OpenSqlite(path)
+	if err != nil {
+		return nil, err
 	}
-
-	log.Println(code) //TODO implement
-	return err
+	return &gormStore{gormTx{db: db}}, nil
 }
@@ -0,0 +1,22 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/alphabatem/btc_rune/storage/storagetest"
+)
+
+func TestSqliteStore(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Store {
+		s, err := NewSqliteStore(filepath.Join(t.TempDir(), "rune.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
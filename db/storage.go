@@ -0,0 +1,32 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/cloakd/common/context"
+)
+
+// STORAGE_SVC is the Id of whichever storage backend is running
+const STORAGE_SVC = "storage_svc"
+
+// StorageService is a service backing the index with a storage.Store
+type StorageService interface {
+	context.Service
+	// Store returns the repository, available once the service started
+	Store() storage.Store
+}
+
+// NewStorageService returns the backend named driver, sqlite when empty
+func NewStorageService(driver string) (StorageService, error) {
+	switch driver {
+	case "", "sqlite":
+		return &SqliteService{}, nil
+	case "postgres":
+		return &PostgresService{}, nil
+	case "bolt":
+		return &BoltService{}, nil
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q", driver)
+	}
+}
@@ -0,0 +1,447 @@
+package db
+
+import (
+	"time"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/btcsuite/btcd/wire"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Max outpoints per IN query and rows per INSERT, well below the SQLite and PostgreSQL variable limits
+const outpointQueryChunk = 500
+
+// gormStore implements storage.Store on any gorm dialect
+type gormStore struct {
+	gormTx
+}
+
+// gormTx implements storage.Tx on a gorm handle, either the database or an open transaction
+type gormTx struct {
+	db *gorm.DB
+}
+
+func (s *gormStore) Atomic(fn func(tx storage.Tx) error) error {
+	return s.db.Transaction(func(db *gorm.DB) error {
+		return fn(gormTx{db: db})
+	})
+}
+
+func (s *gormStore) Close() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+func (t gormTx) Rune(id btc_rune.RuneID) (*btc_rune.Rune, error) {
+	return t.firstRune("id = ?", id)
+}
+
+func (t gormTx) RuneBySymbol(symbol string) (*btc_rune.Rune, error) {
+	return t.firstRune("symbol = ?", symbol)
+}
+
+func (t gormTx) RuneByIssuance(txID string) (*btc_rune.Rune, error) {
+	return t.firstRune("issuance_tx = ?", txID)
+}
+
+func (t gormTx) RuneByNumber(version btc_rune.Protocol, number uint64) (*btc_rune.Rune, error) {
+	return t.firstRune("version = ? AND number = ?", version, number)
+}
+
+func (t gormTx) firstRune(where string, args ...interface{}) (*btc_rune.Rune, error) {
+	var runes []*btc_rune.Rune
+	err := t.db.Where(where, args...).Limit(1).Find(&runes).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(runes) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return fixTerms(runes[0]), nil
+}
+
+// fixTerms clears the terms of r when it has none.
+// Embedded terms always scan into a struct, runes without terms store only NULLs.
+func fixTerms(r *btc_rune.Rune) *btc_rune.Rune {
+	if r.Terms != nil && *r.Terms == (btc_rune.Terms{}) {
+		r.Terms = nil
+	}
+	return r
+}
+
+func (t gormTx) CountRunes(version btc_rune.Protocol) (uint64, error) {
+	var count int64
+	err := t.db.Model(&btc_rune.Rune{}).Where("version = ?", version).Count(&count).Error
+	return uint64(count), err
+}
+
+func (t gormTx) CreateRune(r *btc_rune.Rune) error {
+	return t.db.Transaction(func(db *gorm.DB) error {
+		err := db.Create(r).Error
+		if err != nil {
+			return err
+		}
+		return db.Create(btc_rune.NewRuneStats(r.ID)).Error
+	})
+}
+
+func (t gormTx) DeleteRune(id btc_rune.RuneID) error {
+	return t.db.Transaction(func(db *gorm.DB) error {
+		err := db.Where("id = ?", id).Delete(&btc_rune.Rune{}).Error
+		if err != nil {
+			return err
+		}
+		return db.Where("block = ? AND tx = ?", id.Block, id.Tx).Delete(&btc_rune.RuneStats{}).Error
+	})
+}
+
+func (t gormTx) AddMints(id btc_rune.RuneID, delta int64) error {
+	return t.db.Model(&btc_rune.Rune{}).Where("id = ?", id).Update("mints", gorm.Expr("mints + ?", delta)).Error
+}
+
+func (t gormTx) RuneStats(id btc_rune.RuneID) (*btc_rune.RuneStats, error) {
+	var stats []*btc_rune.RuneStats
+	err := t.db.Where("block = ? AND tx = ?", id.Block, id.Tx).Limit(1).Find(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(stats) == 0 {
+		return nil, storage.ErrNotFound
+	}
+	return stats[0], nil
+}
+
+func (t gormTx) SaveRuneStats(s *btc_rune.RuneStats) error {
+	// Explicit keys, gorm leaves a zero tx out of the primary key condition
+	res := t.db.Model(&btc_rune.RuneStats{}).Where("block = ? AND tx = ?", s.Block, s.Tx).Select("*").Updates(s)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (t gormTx) Runes(q storage.RuneQuery) ([]*storage.RuneEntry, error) {
+	dir := "ASC"
+	if q.Desc {
+		dir = "DESC"
+	}
+
+	db := t.db.Model(&btc_rune.RuneStats{})
+	if q.Prefix != "" {
+		db = db.Joins("JOIN runes ON runes.id = rune_stats.block || ':' || rune_stats.tx").
+			Where("runes.symbol LIKE ?", q.Prefix+"%")
+	}
+	switch q.Sort {
+	case storage.SortHolders:
+		db = db.Order("rune_stats.holders " + dir).Order("rune_stats.block, rune_stats.tx")
+	case storage.SortTransfers:
+		db = db.Order("rune_stats.transfers " + dir).Order("rune_stats.block, rune_stats.tx")
+	default:
+		db = db.Order("rune_stats.block " + dir).Order("rune_stats.tx " + dir)
+	}
+
+	if q.Limit > 0 {
+		db = db.Limit(q.Limit)
+	}
+
+	var stats []*btc_rune.RuneStats
+	err := db.Offset(q.Offset).Find(&stats).Error
+	if err != nil || len(stats) == 0 {
+		return nil, err
+	}
+
+	ids := make([]interface{}, len(stats))
+	for i, s := range stats {
+		ids[i] = s.RuneID()
+	}
+	var runes []*btc_rune.Rune
+	err = t.db.Where("id IN ?", ids).Find(&runes).Error
+	if err != nil {
+		return nil, err
+	}
+	byID := map[btc_rune.RuneID]*btc_rune.Rune{}
+	for _, r := range runes {
+		byID[r.ID] = fixTerms(r)
+	}
+
+	entries := make([]*storage.RuneEntry, 0, len(stats))
+	for _, s := range stats {
+		if r, ok := byID[s.RuneID()]; ok {
+			entries = append(entries, &storage.RuneEntry{Rune: r, Stats: s})
+		}
+	}
+	return entries, nil
+}
+
+func (t gormTx) Holding(address string, id btc_rune.RuneID) (btc_rune.Uint128, error) {
+	var holdings []*btc_rune.Holding
+	err := t.db.Where("address = ? AND rune = ?", address, id).Limit(1).Find(&holdings).Error
+	if err != nil || len(holdings) == 0 {
+		return btc_rune.Uint128{}, err
+	}
+	return holdings[0].Amount, nil
+}
+
+func (t gormTx) SetHolding(h *btc_rune.Holding) error {
+	if h.Amount.IsZero() {
+		return t.db.Where("address = ? AND rune = ?", h.Address, h.Rune).Delete(&btc_rune.Holding{}).Error
+	}
+	return t.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(h).Error
+}
+
+func (t gormTx) Holders(id btc_rune.RuneID, offset, limit int) ([]*btc_rune.Holding, error) {
+	// SQLite keeps amounts as decimal strings, which order by length first
+	order := "length(amount) DESC, amount DESC, address"
+	if t.db.Dialector.Name() == "postgres" {
+		order = "amount DESC, address"
+	}
+
+	var holdings []*btc_rune.Holding
+	err := t.db.Where("rune = ?", id).Order(order).Offset(offset).Limit(limit).Find(&holdings).Error
+	return holdings, err
+}
+
+func (t gormTx) Balances(outpoints []wire.OutPoint) ([]*btc_rune.RuneBalance, error) {
+	var balances []*btc_rune.RuneBalance
+	for start := 0; start < len(outpoints); start += outpointQueryChunk {
+		end := start + outpointQueryChunk
+		if end > len(outpoints) {
+			end = len(outpoints)
+		}
+
+		keys := make([][]interface{}, 0, end-start)
+		for _, op := range outpoints[start:end] {
+			keys = append(keys, []interface{}{op.Hash.String(), op.Index})
+		}
+
+		var chunk []*btc_rune.RuneBalance
+		err := t.db.Where("(tx_id, vout) IN ?", keys).Find(&chunk).Error
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, chunk...)
+	}
+	return balances, nil
+}
+
+func (t gormTx) AddressBalances(address string) ([]*btc_rune.RuneBalance, error) {
+	var balances []*btc_rune.RuneBalance
+	err := t.db.Where("address = ?", address).Find(&balances).Error
+	return balances, err
+}
+
+func (t gormTx) CreateBalances(balances []*btc_rune.RuneBalance) error {
+	if len(balances) == 0 {
+		return nil
+	}
+	return t.db.CreateInBatches(balances, outpointQueryChunk).Error
+}
+
+// DeleteBalances matches keys explicitly, gorm skips zero primary key fields such as vout 0
+func (t gormTx) DeleteBalances(balances []*btc_rune.RuneBalance) error {
+	for start := 0; start < len(balances); start += outpointQueryChunk {
+		end := start + outpointQueryChunk
+		if end > len(balances) {
+			end = len(balances)
+		}
+
+		keys := make([][]interface{}, 0, end-start)
+		for _, b := range balances[start:end] {
+			keys = append(keys, []interface{}{b.TxID, b.Vout, b.Rune})
+		}
+
+		err := t.db.Where("(tx_id, vout, rune) IN ?", keys).Delete(&btc_rune.RuneBalance{}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t gormTx) CreateEvent(e *btc_rune.Event) error {
+	return t.db.Create(e).Error
+}
+
+func (t gormTx) Events(after uint64, eventType btc_rune.EventType, limit int) ([]*btc_rune.Event, error) {
+	q := t.db.Where("id > ?", after)
+	if eventType != "" {
+		q = q.Where("type = ?", eventType)
+	}
+
+	var events []*btc_rune.Event
+	err := q.Order("id").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+func (t gormTx) LastEvent() (*btc_rune.Event, error) {
+	var events []*btc_rune.Event
+	err := t.db.Order("id desc").Limit(1).Find(&events).Error
+	if err != nil || len(events) == 0 {
+		return nil, err
+	}
+	return events[0], nil
+}
+
+func (t gormTx) BlockEvents(height int64, hash string) ([]*btc_rune.Event, error) {
+	var connected []*btc_rune.Event
+	err := t.db.Where("type = ? AND height = ? AND block_hash = ?", btc_rune.EventBlockConnected, height, hash).
+		Order("id desc").Limit(1).Find(&connected).Error
+	if err != nil || len(connected) == 0 {
+		return nil, err
+	}
+
+	var candidates []*btc_rune.Event
+	err = t.db.Where("id < ? AND height = ? AND block_hash = ? AND type IN ?", connected[0].ID, height, hash,
+		[]btc_rune.EventType{btc_rune.EventIssuance, btc_rune.EventTransfer, btc_rune.EventBurn}).
+		Order("id desc").Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+	return blockRun(connected[0].ID, candidates), nil
+}
+
+// blockRun keeps the events of candidates, newest first, that directly precede the block event id, in ID order.
+// A block's events are written in one transaction so their IDs are consecutive, an earlier connect of the same block is not.
+func blockRun(id uint64, candidates []*btc_rune.Event) []*btc_rune.Event {
+	n := 0
+	for n < len(candidates) && candidates[n].ID == id-uint64(n)-1 {
+		n++
+	}
+	events := make([]*btc_rune.Event, n)
+	for i := range events {
+		events[i] = candidates[n-1-i]
+	}
+	return events
+}
+
+func (t gormTx) Tip() (*btc_rune.Block, error) {
+	return t.firstBlock(t.db.Order("height desc"))
+}
+
+func (t gormTx) Block(height int64) (*btc_rune.Block, error) {
+	return t.firstBlock(t.db.Where("height = ?", height))
+}
+
+func (t gormTx) firstBlock(q *gorm.DB) (*btc_rune.Block, error) {
+	var blocks []*btc_rune.Block
+	err := q.Limit(1).Find(&blocks).Error
+	if err != nil || len(blocks) == 0 {
+		return nil, err
+	}
+	return blocks[0], nil
+}
+
+func (t gormTx) CreateBlock(b *btc_rune.Block) error {
+	return t.db.Create(b).Error
+}
+
+func (t gormTx) DeleteBlock(height int64) error {
+	return t.db.Where("height = ?", height).Delete(&btc_rune.Block{}).Error
+}
+
+func (t gormTx) CreateUndo(records []*btc_rune.UndoRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return t.db.CreateInBatches(records, outpointQueryChunk).Error
+}
+
+func (t gormTx) Undo(height int64) ([]*btc_rune.UndoRecord, error) {
+	var records []*btc_rune.UndoRecord
+	err := t.db.Where("height = ?", height).Order("id").Find(&records).Error
+	return records, err
+}
+
+func (t gormTx) DeleteUndo(height int64) error {
+	return t.db.Where("height = ?", height).Delete(&btc_rune.UndoRecord{}).Error
+}
+
+func (t gormTx) PruneUndo(height int64) error {
+	return t.db.Where("height <= ?", height).Delete(&btc_rune.UndoRecord{}).Error
+}
+
+func (t gormTx) CreateWebhook(w *btc_rune.Webhook) error {
+	return t.db.Create(w).Error
+}
+
+func (t gormTx) Webhook(id uint64) (*btc_rune.Webhook, error) {
+	var hooks []*btc_rune.Webhook
+	err := t.db.Where("id = ?", id).Limit(1).Find(&hooks).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(hooks) == 0 {
+		return nil, storage.ErrNotFound
+	}
+	return hooks[0], nil
+}
+
+func (t gormTx) Webhooks() ([]*btc_rune.Webhook, error) {
+	var hooks []*btc_rune.Webhook
+	err := t.db.Order("id").Find(&hooks).Error
+	return hooks, err
+}
+
+func (t gormTx) SaveWebhook(w *btc_rune.Webhook) error {
+	res := t.db.Model(w).Select("*").Updates(w)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (t gormTx) DeleteWebhook(id uint64) error {
+	err := t.db.Where("webhook = ?", id).Delete(&btc_rune.Delivery{}).Error
+	if err != nil {
+		return err
+	}
+	return t.db.Where("id = ?", id).Delete(&btc_rune.Webhook{}).Error
+}
+
+func (t gormTx) CreateDeliveries(deliveries []*btc_rune.Delivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return t.db.CreateInBatches(deliveries, outpointQueryChunk).Error
+}
+
+func (t gormTx) SaveDelivery(d *btc_rune.Delivery) error {
+	res := t.db.Model(d).Select("*").Updates(d)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+func (t gormTx) DueDeliveries(now time.Time, limit int) ([]*btc_rune.Delivery, error) {
+	var deliveries []*btc_rune.Delivery
+	err := t.db.Where("status = ? AND next_attempt <= ?", btc_rune.DeliveryPending, now.UTC()).
+		Order("next_attempt, id").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (t gormTx) Deliveries(webhook uint64, status btc_rune.DeliveryStatus, after uint64, limit int) ([]*btc_rune.Delivery, error) {
+	q := t.db.Where("webhook = ? AND id > ?", webhook, after)
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+
+	var deliveries []*btc_rune.Delivery
+	err := q.Order("id").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
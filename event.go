@@ -0,0 +1,102 @@
+package btc_rune
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// EventType names what happened to the index
+type EventType string
+
+const (
+	EventBlockConnected    EventType = "block_connected"
+	EventBlockDisconnected EventType = "block_disconnected"
+	EventReorg             EventType = "reorg"
+	// EventReverted compensates a rune event of a disconnected block, see RevertedData
+	EventReverted EventType = "reverted"
+
+	// Rune events are stored with the block of their transaction, before its EventBlockConnected
+	EventIssuance EventType = "issuance"
+	EventTransfer EventType = "transfer"
+	EventBurn     EventType = "burn"
+
+	// Mempool events are only streamed, never stored, so they carry no ID or height
+	EventMempoolAdd    EventType = "mempool_add"
+	EventMempoolRemove EventType = "mempool_remove"
+)
+
+// Valid reports whether t is a known event type
+func (t EventType) Valid() bool {
+	switch t {
+	case EventBlockConnected, EventBlockDisconnected, EventReorg, EventReverted,
+		EventIssuance, EventTransfer, EventBurn, EventMempoolAdd, EventMempoolRemove:
+		return true
+	}
+	return false
+}
+
+// TxEvent reports whether t is a rune event, describing what a transaction did to a rune
+func (t EventType) TxEvent() bool {
+	return t == EventIssuance || t == EventTransfer || t == EventBurn
+}
+
+// Event is a change to the index, persisted in the order it was committed
+type Event struct {
+	ID        uint64    `json:"id" gorm:"primaryKey"`
+	Type      EventType `json:"type" gorm:"index"`
+	Height    int64     `json:"height" gorm:"index"`
+	BlockHash string    `json:"blockHash"`
+	// Data carries the type specific details, see ReorgData, TxEventData and RevertedData
+	Data      json.RawMessage `json:"data,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// ReorgData details an EventReorg, Height is the fork point
+type ReorgData struct {
+	OldTip    string `json:"oldTip"`
+	OldHeight int64  `json:"oldHeight"`
+	Depth     int64  `json:"depth"`
+	ForkHash  string `json:"forkHash"`
+}
+
+// RevertedData details an EventReverted, Event is the rune event the disconnect undid
+type RevertedData struct {
+	Event *Event `json:"event"`
+}
+
+// TxEventData details what a transaction did to one rune in an EventIssuance, EventTransfer or EventBurn.
+// Amount is the premine issued, the total credited to outputs or the amount burned.
+type TxEventData struct {
+	TxID   string  `json:"txid"`
+	Rune   RuneID  `json:"rune"`
+	Symbol string  `json:"symbol,omitempty"`
+	Amount Uint128 `json:"amount"`
+	// Mint is set on the transfer of a rune the transaction minted
+	Mint bool `json:"mint,omitempty"`
+	// From lists the addresses of the spent outputs holding the rune
+	From []string `json:"from,omitempty"`
+	// To lists the outputs credited with the rune
+	To []*Movement `json:"to,omitempty"`
+}
+
+// Movement is an amount of a rune credited to an output
+type Movement struct {
+	Vout    uint32  `json:"vout"`
+	Address string  `json:"address,omitempty"`
+	Amount  Uint128 `json:"amount"`
+}
+
+// Involves reports whether address sent or received the rune
+func (d *TxEventData) Involves(address string) bool {
+	for _, from := range d.From {
+		if from == address {
+			return true
+		}
+	}
+	for _, to := range d.To {
+		if to.Address == address {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package btc_rune
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Flaws is the set of malformations found while decoding a rune script.
+// Any flaw makes the transaction a cenotaph and burns its input runes.
+type Flaws uint32
+
+const (
+	FlawTruncatedVarint Flaws = 1 << iota
+	FlawVarintOverflow
+	FlawNonPushOpcode
+	FlawTooManyPushes
+	FlawTrailingBytes
+	FlawInvalidIssuance
+	FlawTruncatedField
+	FlawTrailingIntegers
+	FlawUnrecognizedEvenTag
+	FlawUnrecognizedFlag
+	FlawEdictRuneID
+	FlawOutputOutOfRange
+)
+
+var flawNames = []string{
+	"truncated_varint",
+	"varint_overflow",
+	"non_push_opcode",
+	"too_many_pushes",
+	"trailing_bytes",
+	"invalid_issuance",
+	"truncated_field",
+	"trailing_integers",
+	"unrecognized_even_tag",
+	"unrecognized_flag",
+	"edict_rune_id",
+	"output_out_of_range",
+}
+
+// Has reports whether every flaw in flaw is set
+func (f Flaws) Has(flaw Flaws) bool {
+	return f&flaw == flaw
+}
+
+// List returns the names of the set flaws
+func (f Flaws) List() []string {
+	var names []string
+	for i, name := range flawNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (f Flaws) String() string {
+	return strings.Join(f.List(), ",")
+}
+
+func (f Flaws) MarshalJSON() ([]byte, error) {
+	list := f.List()
+	if list == nil {
+		list = []string{}
+	}
+	return json.Marshal(list)
+}
+
+func (f *Flaws) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+
+	*f = 0
+	for _, name := range names {
+		for i, n := range flawNames {
+			if n == name {
+				*f |= 1 << i
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package ledger
+
+import "github.com/alphabatem/btc_rune"
+
+// Balances is the amount held of each rune
+type Balances map[btc_rune.RuneID]btc_rune.Uint128
+
+// Add credits amount of id
+func (b Balances) Add(id btc_rune.RuneID, amount btc_rune.Uint128) error {
+	if amount.IsZero() {
+		return nil
+	}
+
+	total, err := b[id].Add(amount)
+	if err != nil {
+		return err
+	}
+	b[id] = total
+	return nil
+}
+
+// Sub debits amount of id, removing the entry once empty
+func (b Balances) Sub(id btc_rune.RuneID, amount btc_rune.Uint128) error {
+	total, err := b[id].Sub(amount)
+	if err != nil {
+		return err
+	}
+
+	if total.IsZero() {
+		delete(b, id)
+		return nil
+	}
+	b[id] = total
+	return nil
+}
+
+// Merge credits every balance of other
+func (b Balances) Merge(other Balances) error {
+	for id, amount := range other {
+		if err := b.Add(id, amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clone returns a copy of b
+func (b Balances) Clone() Balances {
+	out := make(Balances, len(b))
+	for id, amount := range b {
+		out[id] = amount
+	}
+	return out
+}
@@ -0,0 +1,236 @@
+package ledger
+
+import (
+	"sort"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Batch collects the balance changes and undo journal of the block being applied,
+// so they are written together once every transaction of the block is allocated.
+// Outputs both created and spent within the block settle in memory and are never written.
+type Batch struct {
+	Journal
+
+	spent   []*btc_rune.RuneBalance
+	credits map[wire.OutPoint][]*btc_rune.RuneBalance
+	// outputs keeps credits in the order the block made them
+	outputs []wire.OutPoint
+
+	activity map[btc_rune.RuneID]*Activity
+}
+
+// Activity is what the block did to one rune
+type Activity struct {
+	Rune btc_rune.RuneID
+	// Credited and Spent total the stored balances the block creates and deletes
+	Credited  btc_rune.Uint128
+	Spent     btc_rune.Uint128
+	Burned    btc_rune.Uint128
+	Transfers uint64
+}
+
+// HoldingChange is how the block moved what one address holds of a rune
+type HoldingChange struct {
+	Address  string
+	Rune     btc_rune.RuneID
+	Credited btc_rune.Uint128
+	Debited  btc_rune.Uint128
+}
+
+// NewBatch starts the batch of the block at height
+func NewBatch(height int64) *Batch {
+	return &Batch{
+		Journal:  Journal{Height: height},
+		credits:  map[wire.OutPoint][]*btc_rune.RuneBalance{},
+		activity: map[btc_rune.RuneID]*Activity{},
+	}
+}
+
+// Take removes and returns the balances the block credited to outpoints earlier on,
+// rest are the outpoints whose balances, if any, are stored
+func (b *Batch) Take(outpoints []wire.OutPoint) (taken []*btc_rune.RuneBalance, rest []wire.OutPoint) {
+	for _, op := range outpoints {
+		balances, ok := b.credits[op]
+		if !ok {
+			rest = append(rest, op)
+			continue
+		}
+		taken = append(taken, balances...)
+		delete(b.credits, op)
+	}
+	return taken, rest
+}
+
+// Spend records stored balances spent by the block
+func (b *Batch) Spend(balances []*btc_rune.RuneBalance) {
+	b.spent = append(b.spent, balances...)
+}
+
+// Credit records balances the block created on op
+func (b *Batch) Credit(op wire.OutPoint, balances []*btc_rune.RuneBalance) {
+	if len(balances) == 0 {
+		return
+	}
+	if _, ok := b.credits[op]; !ok {
+		b.outputs = append(b.outputs, op)
+	}
+	b.credits[op] = append(b.credits[op], balances...)
+}
+
+// Spent returns the stored balances to delete
+func (b *Batch) Spent() []*btc_rune.RuneBalance {
+	return b.spent
+}
+
+// Credits returns the balances to create, those spent later in the block left out
+func (b *Batch) Credits() []*btc_rune.RuneBalance {
+	var credits []*btc_rune.RuneBalance
+	// An outpoint taken and credited again is listed twice
+	seen := map[wire.OutPoint]bool{}
+	for _, op := range b.outputs {
+		if seen[op] {
+			continue
+		}
+		seen[op] = true
+		credits = append(credits, b.credits[op]...)
+	}
+	return credits
+}
+
+// Undo returns the undo journal of the batch: the etchings and mints recorded so far,
+// followed by the restoration of every spent balance and the deletion of every credit
+func (b *Batch) Undo() ([]*btc_rune.UndoRecord, error) {
+	journal := Journal{Height: b.Height, Records: append([]*btc_rune.UndoRecord(nil), b.Records...)}
+	for _, spent := range b.spent {
+		err := journal.Spent(spent)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, credit := range b.Credits() {
+		err := journal.Credited(credit)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return journal.Records, nil
+}
+
+// Touch marks id as active in the block even if no balance of it moves, as for an etching
+func (b *Batch) Touch(id btc_rune.RuneID) {
+	b.touch(id)
+}
+
+// Transferred counts a transaction spending id
+func (b *Batch) Transferred(id btc_rune.RuneID) {
+	b.touch(id).Transfers++
+}
+
+// Burn records amount of id destroyed by the block
+func (b *Batch) Burn(id btc_rune.RuneID, amount btc_rune.Uint128) (err error) {
+	a := b.touch(id)
+	a.Burned, err = a.Burned.Add(amount)
+	return err
+}
+
+func (b *Batch) touch(id btc_rune.RuneID) *Activity {
+	a, ok := b.activity[id]
+	if !ok {
+		a = &Activity{Rune: id}
+		b.activity[id] = a
+	}
+	return a
+}
+
+// Activity returns what the block did to every rune it touched, in id order
+func (b *Batch) Activity() ([]*Activity, error) {
+	activity := map[btc_rune.RuneID]*Activity{}
+	for id, a := range b.activity {
+		copied := *a
+		activity[id] = &copied
+	}
+	get := func(id btc_rune.RuneID) *Activity {
+		a, ok := activity[id]
+		if !ok {
+			a = &Activity{Rune: id}
+			activity[id] = a
+		}
+		return a
+	}
+
+	var err error
+	for _, spent := range b.spent {
+		a := get(spent.Rune)
+		if a.Spent, err = a.Spent.Add(spent.Amount); err != nil {
+			return nil, err
+		}
+	}
+	for _, credit := range b.Credits() {
+		a := get(credit.Rune)
+		if a.Credited, err = a.Credited.Add(credit.Amount); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]*Activity, 0, len(activity))
+	for _, a := range activity {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Rune.Less(out[j].Rune)
+	})
+	return out, nil
+}
+
+// Holdings returns the net change the block makes to what each address holds, by address and rune.
+// Outputs without an address are held by no one.
+func (b *Batch) Holdings() ([]*HoldingChange, error) {
+	type key struct {
+		address string
+		id      btc_rune.RuneID
+	}
+	changes := map[key]*HoldingChange{}
+	get := func(balance *btc_rune.RuneBalance) *HoldingChange {
+		k := key{balance.Address, balance.Rune}
+		c, ok := changes[k]
+		if !ok {
+			c = &HoldingChange{Address: balance.Address, Rune: balance.Rune}
+			changes[k] = c
+		}
+		return c
+	}
+
+	var err error
+	for _, spent := range b.spent {
+		if spent.Address == "" {
+			continue
+		}
+		c := get(spent)
+		if c.Debited, err = c.Debited.Add(spent.Amount); err != nil {
+			return nil, err
+		}
+	}
+	for _, credit := range b.Credits() {
+		if credit.Address == "" {
+			continue
+		}
+		c := get(credit)
+		if c.Credited, err = c.Credited.Add(credit.Amount); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]*HoldingChange, 0, len(changes))
+	for _, c := range changes {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Address != out[j].Address {
+			return out[i].Address < out[j].Address
+		}
+		return out[i].Rune.Less(out[j].Rune)
+	})
+	return out, nil
+}
@@ -0,0 +1,118 @@
+package ledger
+
+import (
+	"testing"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func balance(op wire.OutPoint, id btc_rune.RuneID, amount uint64) *btc_rune.RuneBalance {
+	return &btc_rune.RuneBalance{TxID: op.Hash.String(), Vout: op.Index, Rune: id, Amount: u(amount)}
+}
+
+func TestBatchSettlesInBlockSpends(t *testing.T) {
+	stored := wire.OutPoint{Hash: chainhash.Hash{1}, Index: 0}
+	first := wire.OutPoint{Hash: chainhash.Hash{2}, Index: 0}
+	second := wire.OutPoint{Hash: chainhash.Hash{3}, Index: 1}
+
+	b := NewBatch(840000)
+	if err := b.Minted(runeA); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first transaction spends a stored output and credits first
+	taken, rest := b.Take([]wire.OutPoint{stored})
+	if len(taken) != 0 || len(rest) != 1 || rest[0] != stored {
+		t.Fatalf("Expected stored outpoint to be looked up - Got %v %v", taken, rest)
+	}
+	b.Spend([]*btc_rune.RuneBalance{balance(stored, runeA, 10)})
+	b.Credit(first, []*btc_rune.RuneBalance{balance(first, runeA, 10)})
+
+	// The second spends first within the block and credits second
+	taken, rest = b.Take([]wire.OutPoint{first})
+	if len(taken) != 1 || taken[0].Amount != u(10) || len(rest) != 0 {
+		t.Fatalf("Expected in block credit - Got %v %v", taken, rest)
+	}
+	b.Credit(second, []*btc_rune.RuneBalance{balance(second, runeA, 4), balance(second, runeB, 1)})
+
+	if len(b.Spent()) != 1 || b.Spent()[0].TxID != stored.Hash.String() {
+		t.Fatalf("Expected only the stored balance spent - Got %v", b.Spent())
+	}
+	credits := b.Credits()
+	if len(credits) != 2 || credits[0].TxID != second.Hash.String() || credits[1].TxID != second.Hash.String() {
+		t.Fatalf("Expected only the second output credited - Got %v", credits)
+	}
+
+	undo, err := b.Undo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := []btc_rune.UndoOp{btc_rune.UndoUnmint, btc_rune.UndoRestoreBalance, btc_rune.UndoDeleteBalance, btc_rune.UndoDeleteBalance}
+	if len(undo) != len(ops) {
+		t.Fatalf("Expected %d undo records - Got %d", len(ops), len(undo))
+	}
+	for i, op := range ops {
+		if undo[i].Op != op || undo[i].Height != 840000 {
+			t.Fatalf("Expected undo %d to be op %d - Got %+v", i, op, undo[i])
+		}
+	}
+	if len(b.Records) != 1 {
+		t.Fatalf("Expected Undo to leave the journal untouched - Got %d records", len(b.Records))
+	}
+}
+
+func TestBatchActivityAndHoldings(t *testing.T) {
+	stored := wire.OutPoint{Hash: chainhash.Hash{1}, Index: 0}
+	first := wire.OutPoint{Hash: chainhash.Hash{2}, Index: 0}
+	second := wire.OutPoint{Hash: chainhash.Hash{3}, Index: 0}
+	held := func(op wire.OutPoint, id btc_rune.RuneID, amount uint64, address string) *btc_rune.RuneBalance {
+		b := balance(op, id, amount)
+		b.Address = address
+		return b
+	}
+
+	b := NewBatch(840000)
+	b.Touch(runeB)
+	b.Transferred(runeA)
+	b.Spend([]*btc_rune.RuneBalance{held(stored, runeA, 10, "alice")})
+	b.Credit(first, []*btc_rune.RuneBalance{held(first, runeA, 6, "bob")})
+	if err := b.Burn(runeA, u(4)); err != nil {
+		t.Fatal(err)
+	}
+
+	// bob passes on what the block gave him, only carol ends up holding it
+	b.Take([]wire.OutPoint{first})
+	b.Transferred(runeA)
+	b.Credit(second, []*btc_rune.RuneBalance{held(second, runeA, 6, "carol")})
+
+	activity, err := b.Activity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activity) != 2 || activity[0].Rune != runeA || activity[1].Rune != runeB {
+		t.Fatalf("Expected activity of both runes in id order - Got %+v", activity)
+	}
+	a := activity[0]
+	if a.Credited != u(6) || a.Spent != u(10) || a.Burned != u(4) || a.Transfers != 2 {
+		t.Fatalf("Expected settled activity - Got %+v", a)
+	}
+	if !activity[1].Credited.IsZero() || activity[1].Transfers != 0 {
+		t.Fatalf("Expected touched rune without movement - Got %+v", activity[1])
+	}
+
+	holdings, err := b.Holdings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(holdings) != 2 {
+		t.Fatalf("Expected alice and carol only - Got %+v", holdings)
+	}
+	if holdings[0].Address != "alice" || holdings[0].Debited != u(10) || !holdings[0].Credited.IsZero() {
+		t.Fatalf("Expected alice debited - Got %+v", holdings[0])
+	}
+	if holdings[1].Address != "carol" || holdings[1].Credited != u(6) {
+		t.Fatalf("Expected carol credited - Got %+v", holdings[1])
+	}
+}
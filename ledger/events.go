@@ -0,0 +1,112 @@
+package ledger
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/alphabatem/btc_rune"
+)
+
+// Events describes what one transaction did to each rune it moved: the issuance of the rune it etched,
+// a transfer of every other rune credited to its outputs and a burn of every rune it destroyed.
+// Events are ordered issuance, transfers then burns, each by rune id, and carry no height or block yet.
+func Events(txID string, etched *btc_rune.Rune, minted *btc_rune.RuneID, spent, credited []*btc_rune.RuneBalance, burned Balances) ([]*btc_rune.Event, error) {
+	from := map[btc_rune.RuneID][]string{}
+	for _, b := range spent {
+		from[b.Rune] = appendAddress(from[b.Rune], b.Address)
+	}
+
+	to := map[btc_rune.RuneID][]*btc_rune.Movement{}
+	totals := Balances{}
+	for _, b := range credited {
+		to[b.Rune] = append(to[b.Rune], &btc_rune.Movement{Vout: b.Vout, Address: b.Address, Amount: b.Amount})
+		err := totals.Add(b.Rune, b.Amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, movements := range to {
+		sort.Slice(movements, func(i, j int) bool {
+			return movements[i].Vout < movements[j].Vout
+		})
+	}
+
+	var events []*btc_rune.Event
+	add := func(eventType btc_rune.EventType, d *btc_rune.TxEventData) error {
+		data, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		events = append(events, &btc_rune.Event{Type: eventType, Data: data})
+		return nil
+	}
+
+	if etched != nil {
+		err := add(btc_rune.EventIssuance, &btc_rune.TxEventData{
+			TxID:   txID,
+			Rune:   etched.ID,
+			Symbol: etched.Symbol,
+			Amount: etched.Premine,
+			To:     to[etched.ID],
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range sortedIDs(totals) {
+		if etched != nil && id == etched.ID {
+			continue
+		}
+		err := add(btc_rune.EventTransfer, &btc_rune.TxEventData{
+			TxID:   txID,
+			Rune:   id,
+			Amount: totals[id],
+			Mint:   minted != nil && *minted == id,
+			From:   from[id],
+			To:     to[id],
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, id := range sortedIDs(burned) {
+		if burned[id].IsZero() {
+			continue
+		}
+		err := add(btc_rune.EventBurn, &btc_rune.TxEventData{
+			TxID:   txID,
+			Rune:   id,
+			Amount: burned[id],
+			From:   from[id],
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+func appendAddress(addresses []string, address string) []string {
+	if address == "" {
+		return addresses
+	}
+	for _, a := range addresses {
+		if a == address {
+			return addresses
+		}
+	}
+	return append(addresses, address)
+}
+
+func sortedIDs(b Balances) []btc_rune.RuneID {
+	ids := make([]btc_rune.RuneID, 0, len(b))
+	for id := range b {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].Less(ids[j])
+	})
+	return ids
+}
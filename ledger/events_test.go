@@ -0,0 +1,73 @@
+package ledger
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/alphabatem/btc_rune"
+)
+
+func TestEvents(t *testing.T) {
+	etched := &btc_rune.Rune{ID: position, Symbol: "ETCHED", Premine: u(100)}
+	held := func(id btc_rune.RuneID, vout uint32, amount uint64, address string) *btc_rune.RuneBalance {
+		return &btc_rune.RuneBalance{TxID: "tx", Vout: vout, Rune: id, Amount: u(amount), Address: address}
+	}
+
+	spent := []*btc_rune.RuneBalance{held(runeB, 0, 5, "alice"), held(runeB, 1, 5, "alice"), held(runeA, 0, 3, "bob")}
+	credited := []*btc_rune.RuneBalance{
+		held(runeB, 2, 4, "carol"),
+		held(position, 0, 100, "alice"),
+		held(runeB, 1, 6, "dave"),
+		held(runeA, 1, 1, "carol"),
+	}
+
+	events, err := Events("tx", etched, &runeA, spent, credited, Balances{runeA: u(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		eventType btc_rune.EventType
+		id        btc_rune.RuneID
+		amount    uint64
+	}{
+		{btc_rune.EventIssuance, position, 100},
+		{btc_rune.EventTransfer, runeA, 1},
+		{btc_rune.EventTransfer, runeB, 10},
+		{btc_rune.EventBurn, runeA, 3},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Expected %d events - Got %d", len(want), len(events))
+	}
+
+	data := make([]*btc_rune.TxEventData, len(events))
+	for i, e := range events {
+		data[i] = &btc_rune.TxEventData{}
+		if err = json.Unmarshal(e.Data, data[i]); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type != want[i].eventType || data[i].Rune != want[i].id || data[i].Amount != u(want[i].amount) || data[i].TxID != "tx" {
+			t.Fatalf("Expected event %d to be %v - Got %s %+v", i, want[i], e.Type, data[i])
+		}
+	}
+
+	if data[0].Symbol != "ETCHED" || len(data[0].To) != 1 || data[0].To[0].Address != "alice" {
+		t.Fatalf("Expected issuance to the premine output - Got %+v", data[0])
+	}
+	if !data[1].Mint || data[2].Mint {
+		t.Fatalf("Expected only the minted rune's transfer marked - Got %v %v", data[1].Mint, data[2].Mint)
+	}
+	transfer := data[2]
+	if len(transfer.From) != 1 || transfer.From[0] != "alice" {
+		t.Fatalf("Expected spending addresses listed once - Got %v", transfer.From)
+	}
+	if len(transfer.To) != 2 || transfer.To[0].Vout != 1 || transfer.To[1].Vout != 2 {
+		t.Fatalf("Expected movements by vout - Got %+v", transfer.To)
+	}
+	if !transfer.Involves("dave") || !transfer.Involves("alice") || transfer.Involves("bob") {
+		t.Fatalf("Expected transfer to involve its senders and receivers")
+	}
+	if len(data[3].From) != 1 || data[3].From[0] != "bob" {
+		t.Fatalf("Expected burn from bob - Got %v", data[3].From)
+	}
+}
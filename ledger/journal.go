@@ -0,0 +1,53 @@
+package ledger
+
+import (
+	"encoding/json"
+
+	"github.com/alphabatem/btc_rune"
+)
+
+// Journal collects the undo records of the block being applied
+type Journal struct {
+	Height  int64
+	Records []*btc_rune.UndoRecord
+}
+
+// Spent records that b was deleted by the block
+func (j *Journal) Spent(b *btc_rune.RuneBalance) error {
+	return j.add(btc_rune.UndoRestoreBalance, b)
+}
+
+// Credited records that b was created by the block
+func (j *Journal) Credited(b *btc_rune.RuneBalance) error {
+	return j.add(btc_rune.UndoDeleteBalance, b)
+}
+
+// Etched records that the block registered id
+func (j *Journal) Etched(id btc_rune.RuneID) error {
+	return j.add(btc_rune.UndoDeleteRune, id)
+}
+
+// Minted records that the block counted a mint of id
+func (j *Journal) Minted(id btc_rune.RuneID) error {
+	return j.add(btc_rune.UndoUnmint, id)
+}
+
+// StatsChanged records the stats of a rune before the block changed them
+func (j *Journal) StatsChanged(previous *btc_rune.RuneStats) error {
+	return j.add(btc_rune.UndoRestoreStats, previous)
+}
+
+// HoldingChanged records what an address held before the block changed it
+func (j *Journal) HoldingChanged(previous *btc_rune.Holding) error {
+	return j.add(btc_rune.UndoRestoreHolding, previous)
+}
+
+func (j *Journal) add(op btc_rune.UndoOp, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	j.Records = append(j.Records, &btc_rune.UndoRecord{Height: j.Height, Op: op, Data: data})
+	return nil
+}
@@ -0,0 +1,213 @@
+// Package ledger computes how a transaction moves the runes held by its inputs.
+//
+// It is pure allocation logic, persistence of the resulting balances is left to the caller.
+package ledger
+
+import (
+	"github.com/alphabatem/btc_rune"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Resolver maps the ids used by assignments to registered runes
+type Resolver interface {
+	// LegacyRune returns the id of the legacy rune with the given number
+	LegacyRune(number uint64) (btc_rune.RuneID, bool)
+}
+
+// Allocation is the effect of one transaction on the ledger
+type Allocation struct {
+	// Etched is the id of the rune issued by the transaction
+	Etched *btc_rune.RuneID
+	// Outputs holds the balances credited to each output index
+	Outputs map[uint32]Balances
+	// Burned holds the balances destroyed by the transaction
+	Burned Balances
+}
+
+// credit adds amount of id to output, runes sent to OP_RETURN outputs are burned
+func (a *Allocation) credit(msg *wire.MsgTx, output uint32, id btc_rune.RuneID, amount btc_rune.Uint128) error {
+	if isOpReturn(msg.TxOut[output].PkScript) {
+		return a.Burned.Add(id, amount)
+	}
+
+	if a.Outputs[output] == nil {
+		a.Outputs[output] = Balances{}
+	}
+	return a.Outputs[output].Add(id, amount)
+}
+
+// Allocate moves the runes held by the inputs of msg according to its rune script.
+//
+// position is where msg sits in the chain (block height and transaction index)
+// and becomes the id of any rune it issues. tx is nil when msg carries no rune
+// script, its input runes then move to the first non OP_RETURN output.
+func Allocate(position btc_rune.RuneID, msg *wire.MsgTx, tx *btc_rune.Transaction, inputs Balances, resolver Resolver) (*Allocation, error) {
+	a := &Allocation{
+		Outputs: map[uint32]Balances{},
+		Burned:  Balances{},
+	}
+	unallocated := inputs.Clone()
+
+	if tx != nil && tx.Issuance != nil {
+		a.Etched = &position
+	}
+
+	if tx != nil && tx.Cenotaph {
+		return a, a.Burned.Merge(unallocated)
+	}
+
+	if tx != nil {
+		if err := allocateTransfers(a, position, msg, tx, unallocated, resolver); err != nil {
+			return nil, err
+		}
+	}
+
+	// Legacy issuers create supply by assigning it, what they did not assign never existed
+	if tx != nil && tx.Issuance != nil && tx.Version == btc_rune.ProtocolLegacy {
+		delete(unallocated, position)
+	}
+
+	if len(unallocated) == 0 {
+		return a, nil
+	}
+
+	output, ok := defaultOutput(msg, tx)
+	if !ok {
+		return a, a.Burned.Merge(unallocated)
+	}
+	for id, amount := range unallocated {
+		if err := a.credit(msg, output, id, amount); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func allocateTransfers(a *Allocation, position btc_rune.RuneID, msg *wire.MsgTx, tx *btc_rune.Transaction, unallocated Balances, resolver Resolver) error {
+	if tx.Issuance != nil {
+		supply := tx.Issuance.Premine
+		if tx.Version == btc_rune.ProtocolLegacy {
+			supply = btc_rune.MaxUint128
+		}
+		if err := unallocated.Add(position, supply); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range tx.Transfers {
+		id, ok := Resolve(t, a.Etched, tx.Version, resolver)
+		if !ok {
+			continue
+		}
+
+		balance := unallocated[id]
+		if balance.IsZero() {
+			continue
+		}
+
+		if tx.Version == btc_rune.ProtocolRunestone && t.Output == uint64(len(msg.TxOut)) {
+			if err := split(a, msg, id, t.Amount, unallocated); err != nil {
+				return err
+			}
+			continue
+		}
+
+		amount := t.Amount.Min(balance)
+		if tx.Version == btc_rune.ProtocolRunestone && t.Amount.IsZero() {
+			amount = balance
+		}
+		if amount.IsZero() {
+			continue
+		}
+
+		if err := unallocated.Sub(id, amount); err != nil {
+			return err
+		}
+		if err := a.credit(msg, uint32(t.Output), id, amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// split divides a runestone edict between every non OP_RETURN output.
+// A zero amount splits the whole balance evenly with the remainder going to the first outputs.
+func split(a *Allocation, msg *wire.MsgTx, id btc_rune.RuneID, amount btc_rune.Uint128, unallocated Balances) error {
+	var outputs []uint32
+	for i, out := range msg.TxOut {
+		if !isOpReturn(out.PkScript) {
+			outputs = append(outputs, uint32(i))
+		}
+	}
+	if len(outputs) == 0 {
+		return nil
+	}
+
+	balance := unallocated[id]
+	var remainder uint64
+	if amount.IsZero() {
+		amount, remainder = balance.Div64(uint64(len(outputs)))
+	}
+
+	for i, output := range outputs {
+		share := amount
+		if uint64(i) < remainder {
+			share, _ = share.Add(btc_rune.NewUint128(1))
+		}
+		share = share.Min(unallocated[id])
+		if share.IsZero() {
+			break
+		}
+
+		if err := unallocated.Sub(id, share); err != nil {
+			return err
+		}
+		if err := a.credit(msg, output, id, share); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolve returns the rune an assignment refers to. Id 0 is the rune issued by the transaction.
+func Resolve(t *btc_rune.Assignment, etched *btc_rune.RuneID, version btc_rune.Protocol, resolver Resolver) (btc_rune.RuneID, bool) {
+	if version == btc_rune.ProtocolRunestone {
+		if t.Rune == nil {
+			return btc_rune.RuneID{}, false
+		}
+		if *t.Rune == (btc_rune.RuneID{}) {
+			if etched == nil {
+				return btc_rune.RuneID{}, false
+			}
+			return *etched, true
+		}
+		return *t.Rune, true
+	}
+
+	if t.ID == 0 {
+		if etched == nil {
+			return btc_rune.RuneID{}, false
+		}
+		return *etched, true
+	}
+	return resolver.LegacyRune(t.ID)
+}
+
+// defaultOutput is where unallocated runes go, the runestone pointer or the first non OP_RETURN output
+func defaultOutput(msg *wire.MsgTx, tx *btc_rune.Transaction) (uint32, bool) {
+	if tx != nil && tx.Pointer != nil && *tx.Pointer < uint64(len(msg.TxOut)) {
+		return uint32(*tx.Pointer), true
+	}
+
+	for i, out := range msg.TxOut {
+		if !isOpReturn(out.PkScript) {
+			return uint32(i), true
+		}
+	}
+	return 0, false
+}
+
+func isOpReturn(script []byte) bool {
+	return len(script) > 0 && script[0] == txscript.OP_RETURN
+}
@@ -0,0 +1,265 @@
+package ledger
+
+import (
+	"testing"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+var (
+	position = btc_rune.RuneID{Block: 840000, Tx: 3}
+	runeA    = btc_rune.RuneID{Block: 1, Tx: 1}
+	runeB    = btc_rune.RuneID{Block: 2, Tx: 7}
+)
+
+type resolver map[uint64]btc_rune.RuneID
+
+func (r resolver) LegacyRune(number uint64) (btc_rune.RuneID, bool) {
+	id, ok := r[number]
+	return id, ok
+}
+
+func u(v uint64) btc_rune.Uint128 {
+	return btc_rune.NewUint128(v)
+}
+
+// newMsg builds a transaction with the given outputs, true marks an OP_RETURN
+func newMsg(opReturns ...bool) *wire.MsgTx {
+	msg := wire.NewMsgTx(wire.TxVersion)
+	for _, opReturn := range opReturns {
+		script := []byte{txscript.OP_TRUE}
+		if opReturn {
+			script = []byte{txscript.OP_RETURN}
+		}
+		msg.AddTxOut(wire.NewTxOut(546, script))
+	}
+	return msg
+}
+
+func expectBalance(t *testing.T, b Balances, id btc_rune.RuneID, expected uint64) {
+	t.Helper()
+	if b[id] != u(expected) {
+		t.Fatalf("%s: Expected %d - Got %s", id, expected, b[id])
+	}
+}
+
+func TestAllocateNoScriptMovesToDefault(t *testing.T) {
+	a, err := Allocate(position, newMsg(true, false, false), nil, Balances{runeA: u(10)}, resolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectBalance(t, a.Outputs[1], runeA, 10)
+	if len(a.Outputs) != 1 || len(a.Burned) != 0 {
+		t.Fatalf("Unexpected allocation %+v", a)
+	}
+}
+
+func TestAllocateNoOutputsBurns(t *testing.T) {
+	a, err := Allocate(position, newMsg(true), nil, Balances{runeA: u(10)}, resolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectBalance(t, a.Burned, runeA, 10)
+}
+
+func TestAllocateCenotaphBurnsInputs(t *testing.T) {
+	tx := &btc_rune.Transaction{
+		Version:  btc_rune.ProtocolRunestone,
+		Cenotaph: true,
+		Issuance: &btc_rune.Rune{Symbol: "AAAAAAAAAAAAA"},
+	}
+
+	a, err := Allocate(position, newMsg(true, false), tx, Balances{runeA: u(10), runeB: u(5)}, resolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectBalance(t, a.Burned, runeA, 10)
+	expectBalance(t, a.Burned, runeB, 5)
+	if len(a.Outputs) != 0 || a.Etched == nil || *a.Etched != position {
+		t.Fatalf("Unexpected allocation %+v", a)
+	}
+}
+
+func TestAllocateLegacyTransfer(t *testing.T) {
+	tx := &btc_rune.Transaction{
+		Version: btc_rune.ProtocolLegacy,
+		Transfers: btc_rune.Transfers{
+			{ID: 4, Output: 1, Amount: u(3)},
+			{ID: 4, Output: 2, Amount: u(100)},
+			{ID: 9, Output: 2, Amount: u(1)},
+		},
+	}
+
+	a, err := Allocate(position, newMsg(true, false, false, false), tx, Balances{runeA: u(10), runeB: u(5)}, resolver{4: runeA})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectBalance(t, a.Outputs[1], runeA, 3)
+	expectBalance(t, a.Outputs[2], runeA, 7)
+	expectBalance(t, a.Outputs[1], runeB, 5)
+	expectBalance(t, a.Outputs[2], runeB, 0)
+}
+
+func TestAllocateLegacyIssuance(t *testing.T) {
+	tx := &btc_rune.Transaction{
+		Version:  btc_rune.ProtocolLegacy,
+		Issuance: &btc_rune.Rune{Symbol: "PEPE", Decimals: 18},
+		Transfers: btc_rune.Transfers{
+			{ID: 0, Output: 1, Amount: btc_rune.Uint128{Hi: 1}},
+			{ID: 0, Output: 2, Amount: u(5)},
+		},
+	}
+
+	a, err := Allocate(position, newMsg(true, false, false), tx, nil, resolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Etched == nil || *a.Etched != position {
+		t.Fatalf("Expected etched %s - Got %v", position, a.Etched)
+	}
+	if a.Outputs[1][position] != (btc_rune.Uint128{Hi: 1}) {
+		t.Fatalf("Expected 2^64 - Got %s", a.Outputs[1][position])
+	}
+	expectBalance(t, a.Outputs[2], position, 5)
+	if len(a.Burned) != 0 {
+		t.Fatalf("Unassigned legacy supply must not exist - Got burned %+v", a.Burned)
+	}
+}
+
+func TestAllocateRunestoneEtchingPremine(t *testing.T) {
+	pointer := uint64(2)
+	tx := &btc_rune.Transaction{
+		Version:  btc_rune.ProtocolRunestone,
+		Issuance: &btc_rune.Rune{Symbol: "AAAAAAAAAAAAA", Premine: u(1000)},
+		Transfers: btc_rune.Transfers{
+			{Rune: &btc_rune.RuneID{}, Output: 1, Amount: u(400)},
+		},
+		Pointer: &pointer,
+	}
+
+	a, err := Allocate(position, newMsg(true, false, false), tx, nil, resolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectBalance(t, a.Outputs[1], position, 400)
+	expectBalance(t, a.Outputs[2], position, 600)
+}
+
+func TestAllocateRunestoneZeroAmountTakesAll(t *testing.T) {
+	tx := &btc_rune.Transaction{
+		Version:   btc_rune.ProtocolRunestone,
+		Transfers: btc_rune.Transfers{{Rune: &runeA, Output: 2}},
+	}
+
+	a, err := Allocate(position, newMsg(true, false, false), tx, Balances{runeA: u(10)}, resolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectBalance(t, a.Outputs[2], runeA, 10)
+}
+
+func TestAllocateRunestoneSplit(t *testing.T) {
+	tx := &btc_rune.Transaction{
+		Version:   btc_rune.ProtocolRunestone,
+		Transfers: btc_rune.Transfers{{Rune: &runeA, Output: 4}},
+	}
+
+	a, err := Allocate(position, newMsg(false, true, false, false), tx, Balances{runeA: u(11)}, resolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectBalance(t, a.Outputs[0], runeA, 4)
+	expectBalance(t, a.Outputs[2], runeA, 4)
+	expectBalance(t, a.Outputs[3], runeA, 3)
+
+	tx.Transfers[0].Amount = u(5)
+	a, err = Allocate(position, newMsg(false, true, false, false), tx, Balances{runeA: u(11)}, resolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectBalance(t, a.Outputs[0], runeA, 5)
+	expectBalance(t, a.Outputs[2], runeA, 5)
+	expectBalance(t, a.Outputs[3], runeA, 1)
+}
+
+func TestAllocateToOpReturnBurns(t *testing.T) {
+	tx := &btc_rune.Transaction{
+		Version:   btc_rune.ProtocolRunestone,
+		Transfers: btc_rune.Transfers{{Rune: &runeA, Output: 0, Amount: u(4)}},
+	}
+
+	a, err := Allocate(position, newMsg(true, false), tx, Balances{runeA: u(10)}, resolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectBalance(t, a.Burned, runeA, 4)
+	expectBalance(t, a.Outputs[1], runeA, 6)
+}
+
+func TestMintable(t *testing.T) {
+	amount, cap, start, offsetEnd := u(100), u(2), uint64(850000), uint64(10)
+	r := &btc_rune.Rune{
+		ID:    position,
+		Terms: &btc_rune.Terms{Amount: &amount, Cap: &cap, HeightStart: &start, OffsetEnd: &offsetEnd},
+	}
+
+	for _, height := range []uint64{
+		840005, // Before height start
+		849999,
+		850000, // Past etching offset end 840010
+	} {
+		if _, ok := Mintable(r, height); ok {
+			t.Fatalf("%d: Expected mints to be closed", height)
+		}
+	}
+
+	offsetEnd = 20000
+	got, ok := Mintable(r, 850000)
+	if !ok || got != amount {
+		t.Fatalf("Expected mint of %s - Got %s %v", amount, got, ok)
+	}
+
+	r.Mints = 2
+	if _, ok = Mintable(r, 850000); ok {
+		t.Fatal("Expected cap to close mints")
+	}
+
+	if _, ok = Mintable(&btc_rune.Rune{ID: position}, 850000); ok {
+		t.Fatal("Expected rune without terms to be unmintable")
+	}
+}
+
+func TestJournalRoundTrip(t *testing.T) {
+	j := &Journal{Height: 840001}
+	spent := &btc_rune.RuneBalance{TxID: "aa", Vout: 1, Rune: runeA, Amount: btc_rune.MaxUint128, Address: "bc1q", Value: 546, Height: 840000}
+	if err := j.Spent(spent); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Minted(runeB); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(j.Records) != 2 || j.Records[0].Op != btc_rune.UndoRestoreBalance || j.Records[1].Op != btc_rune.UndoUnmint {
+		t.Fatalf("Unexpected records %+v", j.Records)
+	}
+
+	b, err := j.Records[0].Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *b != *spent {
+		t.Fatalf("Expected %+v - Got %+v", spent, b)
+	}
+
+	id, err := j.Records[1].RuneID()
+	if err != nil || id != runeB || j.Records[1].Height != j.Height {
+		t.Fatalf("Expected %s at %d - Got %s at %d (%v)", runeB, j.Height, id, j.Records[1].Height, err)
+	}
+}
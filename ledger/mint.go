@@ -0,0 +1,45 @@
+package ledger
+
+import (
+	"github.com/alphabatem/btc_rune"
+)
+
+// Mintable returns the amount a mint of r at height creates.
+// Mints are only open while the rune has terms, the cap is not reached
+// and height falls within both the absolute and the etching relative window.
+func Mintable(r *btc_rune.Rune, height uint64) (btc_rune.Uint128, bool) {
+	terms := r.Terms
+	if terms == nil {
+		return btc_rune.Uint128{}, false
+	}
+
+	if terms.Cap == nil || btc_rune.NewUint128(r.Mints).Cmp(*terms.Cap) >= 0 {
+		return btc_rune.Uint128{}, false
+	}
+
+	etched := r.ID.Block
+	if terms.HeightStart != nil && height < *terms.HeightStart {
+		return btc_rune.Uint128{}, false
+	}
+	if terms.OffsetStart != nil && height < saturatingAdd(etched, *terms.OffsetStart) {
+		return btc_rune.Uint128{}, false
+	}
+	if terms.HeightEnd != nil && height >= *terms.HeightEnd {
+		return btc_rune.Uint128{}, false
+	}
+	if terms.OffsetEnd != nil && height >= saturatingAdd(etched, *terms.OffsetEnd) {
+		return btc_rune.Uint128{}, false
+	}
+
+	if terms.Amount == nil {
+		return btc_rune.Uint128{}, true
+	}
+	return *terms.Amount, true
+}
+
+func saturatingAdd(a, b uint64) uint64 {
+	if a+b < a {
+		return ^uint64(0)
+	}
+	return a + b
+}
@@ -0,0 +1,301 @@
+// Package mempool keeps the pending view of rune movements in unconfirmed transactions.
+//
+// Entries are computed by the caller against the confirmed ledger, the pool only
+// indexes them by the outpoints they spend and evicts them on confirmation,
+// replacement or expiry along with any entry that spends their outputs.
+package mempool
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/ledger"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Entry is an unconfirmed transaction that moves runes.
+// Runes etched by the transaction are held under id 0:0 until it confirms.
+type Entry struct {
+	TxID        string                `json:"txid"`
+	Transaction *btc_rune.Transaction `json:"transaction,omitempty"`
+	Seen        time.Time             `json:"seen"`
+
+	// Inputs are the confirmed or pending balances the transaction spends
+	Inputs []*btc_rune.RuneBalance `json:"inputs"`
+	// Outputs are the pending balances the transaction creates
+	Outputs []*btc_rune.RuneBalance `json:"outputs"`
+	Burned  ledger.Balances         `json:"burned,omitempty"`
+
+	spends []wire.OutPoint
+}
+
+// Involves reports whether the entry spends from or pays to address
+func (e *Entry) Involves(address string) bool {
+	for _, b := range e.Inputs {
+		if b.Address == address {
+			return true
+		}
+	}
+	for _, b := range e.Outputs {
+		if b.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Delta is the unconfirmed change to the runes held by an address
+type Delta struct {
+	Incoming ledger.Balances `json:"incoming"`
+	Outgoing ledger.Balances `json:"outgoing"`
+}
+
+type Pool struct {
+	mu sync.RWMutex
+
+	entries map[string]*Entry
+	spentBy map[wire.OutPoint]string
+	// ignored are txids not to look at again while the node holds them, moving no runes or expired
+	ignored map[string]bool
+}
+
+func New() *Pool {
+	return &Pool{
+		entries: map[string]*Entry{},
+		spentBy: map[wire.OutPoint]string{},
+		ignored: map[string]bool{},
+	}
+}
+
+// Has reports whether txid is pending
+func (p *Pool) Has(txid string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	_, ok := p.entries[txid]
+	return ok
+}
+
+// Known reports whether txid is pending or ignored
+func (p *Pool) Known(txid string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	_, ok := p.entries[txid]
+	return ok || p.ignored[txid]
+}
+
+// Ignore records that txid moves no runes, it is not looked at again until the node drops it
+func (p *Pool) Ignore(txid string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ignored[txid] = true
+}
+
+// Len returns the number of pending entries
+func (p *Pool) Len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.entries)
+}
+
+// Add tracks e as spending msg's inputs. Entries spending any of the same outpoints
+// have been replaced, they are evicted and their txids returned.
+func (p *Pool) Add(e *Entry, msg *wire.MsgTx) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var evicted []string
+	for _, in := range msg.TxIn {
+		if other, ok := p.spentBy[in.PreviousOutPoint]; ok && other != e.TxID {
+			evicted = append(evicted, p.remove(other)...)
+		}
+	}
+
+	e.spends = make([]wire.OutPoint, len(msg.TxIn))
+	for i, in := range msg.TxIn {
+		e.spends[i] = in.PreviousOutPoint
+		p.spentBy[in.PreviousOutPoint] = e.TxID
+	}
+	p.entries[e.TxID] = e
+	return evicted
+}
+
+// Outputs returns the pending balances held by op
+func (p *Pool) Outputs(op wire.OutPoint) []*btc_rune.RuneBalance {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	e, ok := p.entries[op.Hash.String()]
+	if !ok {
+		return nil
+	}
+
+	var balances []*btc_rune.RuneBalance
+	for _, b := range e.Outputs {
+		if b.Vout == op.Index {
+			balances = append(balances, b)
+		}
+	}
+	return balances
+}
+
+// Remove evicts txid and everything spending its outputs, returning the evicted txids
+func (p *Pool) Remove(txid string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.ignored, txid)
+	return p.remove(txid)
+}
+
+// Confirm evicts the transactions of block, and every entry conflicting with them.
+// Descendants of confirmed entries stay pending, their inputs are now confirmed.
+func (p *Pool) Confirm(block *wire.MsgBlock) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var evicted []string
+	for _, msg := range block.Transactions {
+		txid := msg.TxHash().String()
+		delete(p.ignored, txid)
+		if e, ok := p.entries[txid]; ok {
+			p.drop(e)
+			evicted = append(evicted, txid)
+		}
+
+		for _, in := range msg.TxIn {
+			if other, ok := p.spentBy[in.PreviousOutPoint]; ok && other != txid {
+				evicted = append(evicted, p.remove(other)...)
+			}
+		}
+	}
+	return evicted
+}
+
+// Expire evicts every entry first seen before cutoff.
+// Evicted txids are ignored from then on, so a node still holding them does not add them back.
+func (p *Pool) Expire(cutoff time.Time) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var evicted []string
+	for txid, e := range p.entries {
+		if e.Seen.Before(cutoff) {
+			evicted = append(evicted, p.remove(txid)...)
+		}
+	}
+	for _, txid := range evicted {
+		p.ignored[txid] = true
+	}
+	return evicted
+}
+
+// Retain evicts every entry whose txid is not in keep, and forgets the ignored txids not in it
+func (p *Pool) Retain(keep map[string]bool) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var evicted []string
+	for txid := range p.entries {
+		if !keep[txid] {
+			evicted = append(evicted, p.remove(txid)...)
+		}
+	}
+	for txid := range p.ignored {
+		if !keep[txid] {
+			delete(p.ignored, txid)
+		}
+	}
+	return evicted
+}
+
+// Entries returns the pending entries in the order they were seen
+func (p *Pool) Entries() []*Entry {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	entries := make([]*Entry, 0, len(p.entries))
+	for _, e := range p.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Seen.Equal(entries[j].Seen) {
+			return entries[i].TxID < entries[j].TxID
+		}
+		return entries[i].Seen.Before(entries[j].Seen)
+	})
+	return entries
+}
+
+// Deltas sums the pending inputs and outputs of every entry per address
+func (p *Pool) Deltas() (map[string]*Delta, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	deltas := map[string]*Delta{}
+	delta := func(address string) *Delta {
+		d, ok := deltas[address]
+		if !ok {
+			d = &Delta{Incoming: ledger.Balances{}, Outgoing: ledger.Balances{}}
+			deltas[address] = d
+		}
+		return d
+	}
+
+	for _, e := range p.entries {
+		for _, b := range e.Inputs {
+			if b.Address == "" {
+				continue
+			}
+			if err := delta(b.Address).Outgoing.Add(b.Rune, b.Amount); err != nil {
+				return nil, err
+			}
+		}
+		for _, b := range e.Outputs {
+			if b.Address == "" {
+				continue
+			}
+			if err := delta(b.Address).Incoming.Add(b.Rune, b.Amount); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return deltas, nil
+}
+
+// remove evicts txid and its descendants, the caller holds the lock
+func (p *Pool) remove(txid string) []string {
+	e, ok := p.entries[txid]
+	if !ok {
+		return nil
+	}
+	p.drop(e)
+
+	evicted := []string{txid}
+	hash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return evicted
+	}
+	for op, child := range p.spentBy {
+		if op.Hash == *hash {
+			evicted = append(evicted, p.remove(child)...)
+		}
+	}
+	return evicted
+}
+
+// drop forgets e without touching its descendants
+func (p *Pool) drop(e *Entry) {
+	delete(p.entries, e.TxID)
+	for _, op := range e.spends {
+		if p.spentBy[op] == e.TxID {
+			delete(p.spentBy, op)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package mempool
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+var runeA = btc_rune.RuneID{Block: 840000, Tx: 1}
+
+// newMsg spends the given outpoints to a single output, lockTime keeps otherwise equal transactions apart
+func newMsg(lockTime uint32, spends ...wire.OutPoint) *wire.MsgTx {
+	msg := wire.NewMsgTx(wire.TxVersion)
+	for i := range spends {
+		msg.AddTxIn(wire.NewTxIn(&spends[i], nil, nil))
+	}
+	msg.AddTxOut(wire.NewTxOut(546, []byte{txscript.OP_TRUE}))
+	msg.LockTime = lockTime
+	return msg
+}
+
+func newEntry(msg *wire.MsgTx, from, to string, amount uint64, seen time.Time) *Entry {
+	txid := msg.TxHash().String()
+	e := &Entry{TxID: txid, Seen: seen}
+	if from != "" {
+		e.Inputs = []*btc_rune.RuneBalance{{Rune: runeA, Amount: btc_rune.NewUint128(amount), Address: from}}
+	}
+	e.Outputs = []*btc_rune.RuneBalance{{TxID: txid, Vout: 0, Rune: runeA, Amount: btc_rune.NewUint128(amount), Address: to}}
+	return e
+}
+
+func confirmed(n uint32) wire.OutPoint {
+	return wire.OutPoint{Hash: chainhash.Hash{0x01}, Index: n}
+}
+
+func outpoint(msg *wire.MsgTx) wire.OutPoint {
+	return wire.OutPoint{Hash: msg.TxHash(), Index: 0}
+}
+
+func expectEvicted(t *testing.T, got []string, expected ...*wire.MsgTx) {
+	t.Helper()
+
+	want := make([]string, len(expected))
+	for i, msg := range expected {
+		want[i] = msg.TxHash().String()
+	}
+	sort.Strings(want)
+	sort.Strings(got)
+
+	if len(got) != len(want) {
+		t.Fatalf("Expected evicted %v - Got %v", want, got)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("Expected evicted %v - Got %v", want, got)
+		}
+	}
+}
+
+func TestPoolChainedOutputsAndDeltas(t *testing.T) {
+	p := New()
+	now := time.Now()
+
+	parent := newMsg(0, confirmed(0))
+	p.Add(newEntry(parent, "alice", "bob", 10, now), parent)
+
+	outputs := p.Outputs(outpoint(parent))
+	if len(outputs) != 1 || outputs[0].Amount != btc_rune.NewUint128(10) {
+		t.Fatalf("Expected pending output of 10 - Got %+v", outputs)
+	}
+
+	child := newMsg(0, outpoint(parent))
+	p.Add(newEntry(child, "bob", "carol", 10, now.Add(time.Second)), child)
+
+	deltas, err := p.Deltas()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deltas["alice"].Outgoing[runeA] != btc_rune.NewUint128(10) || len(deltas["alice"].Incoming) != 0 {
+		t.Fatalf("Unexpected alice delta %+v", deltas["alice"])
+	}
+	if deltas["bob"].Incoming[runeA] != btc_rune.NewUint128(10) || deltas["bob"].Outgoing[runeA] != btc_rune.NewUint128(10) {
+		t.Fatalf("Unexpected bob delta %+v", deltas["bob"])
+	}
+
+	entries := p.Entries()
+	if len(entries) != 2 || entries[0].TxID != parent.TxHash().String() {
+		t.Fatalf("Expected entries in seen order - Got %+v", entries)
+	}
+}
+
+func TestPoolReplacementEvictsDescendants(t *testing.T) {
+	p := New()
+	now := time.Now()
+
+	parent := newMsg(0, confirmed(0))
+	child := newMsg(0, outpoint(parent))
+	p.Add(newEntry(parent, "alice", "bob", 10, now), parent)
+	p.Add(newEntry(child, "bob", "carol", 10, now), child)
+
+	replacement := newMsg(1, confirmed(0))
+	evicted := p.Add(newEntry(replacement, "alice", "dave", 10, now), replacement)
+
+	expectEvicted(t, evicted, parent, child)
+	if p.Len() != 1 || !p.Has(replacement.TxHash().String()) {
+		t.Fatalf("Expected only the replacement to remain - Got %d entries", p.Len())
+	}
+}
+
+func TestPoolConfirm(t *testing.T) {
+	p := New()
+	now := time.Now()
+
+	parent := newMsg(0, confirmed(0))
+	child := newMsg(0, outpoint(parent))
+	conflict := newMsg(0, confirmed(1))
+	p.Add(newEntry(parent, "alice", "bob", 10, now), parent)
+	p.Add(newEntry(child, "bob", "carol", 10, now), child)
+	p.Add(newEntry(conflict, "erin", "frank", 5, now), conflict)
+
+	// The block confirms parent and double spends the conflicting entry
+	doubleSpend := newMsg(2, confirmed(1))
+	evicted := p.Confirm(&wire.MsgBlock{Transactions: []*wire.MsgTx{parent, doubleSpend}})
+
+	expectEvicted(t, evicted, parent, conflict)
+	if p.Len() != 1 || !p.Has(child.TxHash().String()) {
+		t.Fatalf("Expected the child of a confirmed entry to stay pending - Got %d entries", p.Len())
+	}
+}
+
+func TestPoolExpireAndRetain(t *testing.T) {
+	p := New()
+	now := time.Now()
+
+	old := newMsg(0, confirmed(0))
+	fresh := newMsg(0, confirmed(1))
+	p.Add(newEntry(old, "alice", "bob", 1, now.Add(-time.Hour)), old)
+	p.Add(newEntry(fresh, "alice", "bob", 1, now), fresh)
+
+	expectEvicted(t, p.Expire(now.Add(-time.Minute)), old)
+	expectEvicted(t, p.Retain(map[string]bool{}), fresh)
+	if p.Len() != 0 {
+		t.Fatalf("Expected empty pool - Got %d entries", p.Len())
+	}
+}
+
+func TestPoolIgnored(t *testing.T) {
+	p := New()
+	now := time.Now()
+
+	plain := newMsg(0, confirmed(0))
+	old := newMsg(0, confirmed(1))
+	p.Ignore(plain.TxHash().String())
+	p.Add(newEntry(old, "alice", "bob", 1, now.Add(-time.Hour)), old)
+	expectEvicted(t, p.Expire(now.Add(-time.Minute)), old)
+
+	// Expired entries stay known while the node holds them, so they are not added back
+	for _, msg := range []*wire.MsgTx{plain, old} {
+		if !p.Known(msg.TxHash().String()) || p.Has(msg.TxHash().String()) {
+			t.Fatalf("%s: Expected ignored", msg.TxHash())
+		}
+	}
+
+	p.Retain(map[string]bool{old.TxHash().String(): true})
+	if p.Known(plain.TxHash().String()) || !p.Known(old.TxHash().String()) {
+		t.Fatal("Expected ignored txids the node dropped forgotten")
+	}
+}
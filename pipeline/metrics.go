@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics accumulates where indexing time goes.
+// Fetch and decode are summed over all workers, so they exceed wall time when running in parallel.
+type Metrics struct {
+	mu sync.Mutex
+
+	started time.Time
+
+	fetch  time.Duration
+	decode time.Duration
+	// backpressure is time decoded blocks waited on the committer
+	backpressure time.Duration
+	// stall is time the committer waited on the workers
+	stall  time.Duration
+	commit time.Duration
+
+	blocks       uint64
+	transactions uint64
+	runestones   uint64
+	height       int64
+}
+
+// Snapshot is a point in time copy of Metrics
+type Snapshot struct {
+	Uptime       string  `json:"uptime"`
+	Height       int64   `json:"height"`
+	Blocks       uint64  `json:"blocks"`
+	Transactions uint64  `json:"transactions"`
+	Runestones   uint64  `json:"runestones"`
+	BlocksPerSec float64 `json:"blocksPerSec"`
+	// Millisecond totals
+	FetchMs        int64 `json:"fetchMs"`
+	DecodeMs       int64 `json:"decodeMs"`
+	BackpressureMs int64 `json:"backpressureMs"`
+	StallMs        int64 `json:"stallMs"`
+	CommitMs       int64 `json:"commitMs"`
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{started: time.Now(), height: -1}
+}
+
+func (m *Metrics) add(d *time.Duration, elapsed time.Duration) {
+	m.mu.Lock()
+	*d += elapsed
+	m.mu.Unlock()
+}
+
+// Stalled records the committer waiting elapsed for the next block
+func (m *Metrics) Stalled(elapsed time.Duration) {
+	m.add(&m.stall, elapsed)
+}
+
+// Committed records a block applied to the ledger in elapsed
+func (m *Metrics) Committed(b *Block, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.commit += elapsed
+	m.blocks++
+	m.transactions += uint64(len(b.Block.Transactions))
+	for _, r := range b.Runes {
+		if r != nil {
+			m.runestones++
+		}
+	}
+	m.height = b.Height
+}
+
+func (m *Metrics) Snapshot() Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	uptime := time.Since(m.started)
+	return Snapshot{
+		Uptime:         uptime.Round(time.Second).String(),
+		Height:         m.height,
+		Blocks:         m.blocks,
+		Transactions:   m.transactions,
+		Runestones:     m.runestones,
+		BlocksPerSec:   float64(m.blocks) / uptime.Seconds(),
+		FetchMs:        m.fetch.Milliseconds(),
+		DecodeMs:       m.decode.Milliseconds(),
+		BackpressureMs: m.backpressure.Milliseconds(),
+		StallMs:        m.stall.Milliseconds(),
+		CommitMs:       m.commit.Milliseconds(),
+	}
+}
@@ -0,0 +1,159 @@
+// Package pipeline fetches and decodes blocks in parallel for a single, height ordered committer.
+//
+// Workers pull heights from the chain source and decode their runestones
+// concurrently. Blocks are handed out strictly in height order, at most
+// Prefetch of them are held ahead of the committer.
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/chain"
+	"github.com/alphabatem/btc_rune/codec"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Block is a fetched block with the runestones of its transactions decoded
+type Block struct {
+	Height int64
+	Block  *wire.MsgBlock
+	// Runes holds the decoded rune script per transaction index, nil where there is none
+	Runes []*btc_rune.Transaction
+	// Err is set when the block could not be fetched or decoded, it is always the last block handed out
+	Err error
+}
+
+// Pipeline prefetches blocks from a chain source
+type Pipeline struct {
+	source   chain.Source
+	workers  int
+	prefetch int
+	metrics  *Metrics
+}
+
+// New returns a pipeline of workers fetching from source, holding at most prefetch blocks ahead of the committer
+func New(source chain.Source, workers, prefetch int, metrics *Metrics) *Pipeline {
+	if workers < 1 {
+		workers = 1
+	}
+	if prefetch < workers {
+		prefetch = workers
+	}
+	return &Pipeline{source: source, workers: workers, prefetch: prefetch, metrics: metrics}
+}
+
+// Run hands out the blocks from start to end inclusive in height order.
+// The channel is closed after end, after a block carrying an error, or once stop is closed.
+// stop must be closed once the caller is done reading so the workers exit.
+func (p *Pipeline) Run(stop <-chan struct{}, start, end int64) <-chan *Block {
+	out := make(chan *Block)
+	if start > end {
+		close(out)
+		return out
+	}
+
+	// A slot is taken per dispatched height and freed once its block is handed out
+	slots := make(chan struct{}, p.prefetch)
+	heights := make(chan int64)
+	results := make(chan *Block, p.prefetch)
+
+	go func() {
+		defer close(heights)
+		for height := start; height <= end; height++ {
+			select {
+			case slots <- struct{}{}:
+			case <-stop:
+				return
+			}
+			select {
+			case heights <- height:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for height := range heights {
+				select {
+				case results <- p.fetch(height):
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	go func() {
+		defer close(out)
+
+		pending := map[int64]*Block{}
+		next := start
+		for result := range results {
+			pending[result.Height] = result
+			for block, ok := pending[next]; ok; block, ok = pending[next] {
+				delete(pending, next)
+
+				waited := time.Now()
+				select {
+				case out <- block:
+				case <-stop:
+					return
+				}
+				p.metrics.add(&p.metrics.backpressure, time.Since(waited))
+
+				if block.Err != nil || next == end {
+					return
+				}
+				<-slots
+				next++
+			}
+		}
+	}()
+	return out
+}
+
+// fetch reads the block at height and decodes its runestones
+func (p *Pipeline) fetch(height int64) *Block {
+	b := &Block{Height: height}
+
+	started := time.Now()
+	b.Block, b.Err = p.source.BlockByHeight(height)
+	p.metrics.add(&p.metrics.fetch, time.Since(started))
+	if b.Err != nil {
+		b.Err = fmt.Errorf("fetch block %d: %w", height, b.Err)
+		return b
+	}
+
+	started = time.Now()
+	b.Runes, b.Err = Decode(b.Block)
+	p.metrics.add(&p.metrics.decode, time.Since(started))
+	if b.Err != nil {
+		b.Err = fmt.Errorf("decode block %d: %w", height, b.Err)
+	}
+	return b
+}
+
+// Decode returns the rune script of every transaction in block, nil where there is none
+func Decode(block *wire.MsgBlock) ([]*btc_rune.Transaction, error) {
+	runes := make([]*btc_rune.Transaction, len(block.Transactions))
+	for i, msg := range block.Transactions {
+		runeTx, err := codec.DecodeTx(msg)
+		if err != nil && err != codec.ErrNotRunestone {
+			return nil, err
+		}
+		runes[i] = runeTx
+	}
+	return runes, nil
+}
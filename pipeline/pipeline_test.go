@@ -0,0 +1,132 @@
+package pipeline
+
+import (
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/codec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+var errFetch = errors.New("fetch failed")
+
+// source serves generated blocks with random latency, failing at failAt
+type source struct {
+	failAt   int64
+	inFlight int64
+	peak     int64
+}
+
+func (s *source) BlockByHeight(height int64) (*wire.MsgBlock, error) {
+	n := atomic.AddInt64(&s.inFlight, 1)
+	defer atomic.AddInt64(&s.inFlight, -1)
+	for peak := atomic.LoadInt64(&s.peak); n > peak && !atomic.CompareAndSwapInt64(&s.peak, peak, n); peak = atomic.LoadInt64(&s.peak) {
+	}
+
+	time.Sleep(time.Duration(rand.Intn(2000)) * time.Microsecond)
+	if height == s.failAt {
+		return nil, errFetch
+	}
+
+	block := wire.NewMsgBlock(&wire.BlockHeader{Nonce: uint32(height)})
+	coinbase := wire.NewMsgTx(wire.TxVersion)
+	coinbase.AddTxOut(wire.NewTxOut(50, []byte{txscript.OP_TRUE}))
+	block.AddTransaction(coinbase)
+
+	if height%2 == 0 {
+		script, err := codec.Encode(&btc_rune.Transaction{Transfers: []*btc_rune.Assignment{{ID: 1, Amount: btc_rune.NewUint128(1)}}})
+		if err != nil {
+			return nil, err
+		}
+		tx := wire.NewMsgTx(wire.TxVersion)
+		tx.AddTxOut(wire.NewTxOut(0, script))
+		block.AddTransaction(tx)
+	}
+	return block, nil
+}
+
+func (s *source) BlockHash(height int64) (*chainhash.Hash, error)          { return nil, nil }
+func (s *source) BlockByHash(hash *chainhash.Hash) (*wire.MsgBlock, error) { return nil, nil }
+func (s *source) Transaction(hash *chainhash.Hash) (*wire.MsgTx, error)    { return nil, nil }
+func (s *source) Tip() (int64, *chainhash.Hash, error)                     { return 0, nil, nil }
+func (s *source) Subscribe(stop <-chan struct{}) <-chan *chainhash.Hash    { return nil }
+
+func TestPipelineOrdered(t *testing.T) {
+	s := &source{failAt: -1}
+	metrics := NewMetrics()
+	stop := make(chan struct{})
+	defer close(stop)
+
+	next := int64(100)
+	for b := range New(s, 8, 16, metrics).Run(stop, 100, 399) {
+		if b.Err != nil {
+			t.Fatal(b.Err)
+		}
+		if b.Height != next || b.Block.Header.Nonce != uint32(next) {
+			t.Fatalf("Expected block %d - Got %d", next, b.Height)
+		}
+		if len(b.Runes) != len(b.Block.Transactions) || b.Runes[0] != nil || (next%2 == 0) != (len(b.Runes) == 2 && b.Runes[1] != nil) {
+			t.Fatalf("%d: Unexpected runes %+v", next, b.Runes)
+		}
+		metrics.Committed(b, 0)
+		next++
+	}
+
+	if next != 400 {
+		t.Fatalf("Expected blocks up to 399 - Got %d", next-1)
+	}
+	if s.peak > 8 {
+		t.Fatalf("Expected at most 8 concurrent fetches - Got %d", s.peak)
+	}
+	if m := metrics.Snapshot(); m.Blocks != 300 || m.Runestones != 150 || m.Height != 399 || m.FetchMs == 0 {
+		t.Fatalf("Unexpected metrics %+v", m)
+	}
+}
+
+func TestPipelineError(t *testing.T) {
+	s := &source{failAt: 57}
+	stop := make(chan struct{})
+	defer close(stop)
+
+	var last *Block
+	for b := range New(s, 4, 8, NewMetrics()).Run(stop, 0, 200) {
+		if last != nil && last.Err != nil {
+			t.Fatal("Expected no blocks after an error")
+		}
+		last = b
+	}
+
+	if last == nil || last.Height != 57 || !errors.Is(last.Err, errFetch) {
+		t.Fatalf("Expected fetch error at 57 - Got %+v", last)
+	}
+}
+
+func TestPipelineStop(t *testing.T) {
+	stop := make(chan struct{})
+	blocks := New(&source{failAt: -1}, 4, 4, NewMetrics()).Run(stop, 0, 1000000)
+
+	<-blocks
+	close(stop)
+
+	select {
+	case <-drain(blocks):
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the pipeline to close after stop")
+	}
+}
+
+func drain(blocks <-chan *Block) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range blocks {
+		}
+		close(done)
+	}()
+	return done
+}
@@ -0,0 +1,58 @@
+package btc_rune
+
+import (
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Protocol is the wire format a rune transaction was decoded under
+type Protocol uint8
+
+const (
+	// ProtocolLegacy is the OP_RETURN 'R' layout from the original blog post
+	ProtocolLegacy Protocol = iota + 1
+	// ProtocolRunestone is the finalized OP_RETURN OP_13 layout
+	ProtocolRunestone
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolLegacy:
+		return "legacy"
+	case ProtocolRunestone:
+		return "runestone"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(p))
+	}
+}
+
+func (p Protocol) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Protocol) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "legacy":
+		*p = ProtocolLegacy
+	case "runestone":
+		*p = ProtocolRunestone
+	default:
+		return fmt.Errorf("unknown protocol %q", text)
+	}
+	return nil
+}
+
+// ActivationHeight is the first block runestones are valid in on the network of params,
+// a multiple of the subsidy halving interval
+func ActivationHeight(params *chaincfg.Params) int64 {
+	halving := int64(params.SubsidyReductionInterval)
+	switch params.Net {
+	case wire.MainNet:
+		return 4 * halving
+	case wire.TestNet3:
+		return 12 * halving
+	default:
+		return 0
+	}
+}
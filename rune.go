@@ -1,14 +1,51 @@
 package btc_rune
 
 type Rune struct {
-	Symbol   string `json:"symbol"`
+	// ID is the block height and transaction index of the issuance
+	ID RuneID `json:"id" gorm:"primaryKey"`
+	// Number is the sequential issuance number within the rune's protocol version,
+	// legacy assignments refer to runes by it
+	Number     uint64   `json:"number" gorm:"index"`
+	Version    Protocol `json:"version"`
+	IssuanceTx string   `json:"issuanceTx" gorm:"index"`
+
+	Symbol   string `json:"symbol" gorm:"index"`
 	Decimals uint64 `json:"decimals"`
+
+	// Mints counts the valid mints against the rune's terms
+	Mints uint64 `json:"mints"`
+
+	// Runestone etching fields, unset for legacy issuances
+	Spacers        uint32  `json:"spacers,omitempty"`
+	CurrencySymbol string  `json:"currencySymbol,omitempty"`
+	Premine        Uint128 `json:"premine"`
+	Terms          *Terms  `json:"terms,omitempty" gorm:"embedded;embeddedPrefix:terms_"`
+	Turbo          bool    `json:"turbo,omitempty"`
+}
+
+// Terms are the open mint terms of a runestone etching
+type Terms struct {
+	Amount      *Uint128 `json:"amount,omitempty"`
+	Cap         *Uint128 `json:"cap,omitempty"`
+	HeightStart *uint64  `json:"heightStart,omitempty"`
+	HeightEnd   *uint64  `json:"heightEnd,omitempty"`
+	OffsetStart *uint64  `json:"offsetStart,omitempty"`
+	OffsetEnd   *uint64  `json:"offsetEnd,omitempty"`
 }
 
 type Transaction struct {
 	Hash      string    `json:"hash"`
+	Version   Protocol  `json:"version"`
 	Issuance  *Rune     `json:"issuance,omitempty"`
 	Transfers Transfers `json:"transfers"`
+
+	// Cenotaph marks a malformed transaction, its input runes are burned
+	Cenotaph bool  `json:"cenotaph"`
+	Flaws    Flaws `json:"flaws,omitempty"`
+
+	// Runestone only fields
+	Mint    *RuneID `json:"mint,omitempty"`
+	Pointer *uint64 `json:"pointer,omitempty"`
 }
 
 type Transfers []*Assignment
@@ -0,0 +1,79 @@
+package btc_rune
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidRuneID = errors.New("rune id must be BLOCK:TX")
+
+// RuneID identifies a rune by the block height and transaction index of its etching
+type RuneID struct {
+	Block uint64 `json:"block"`
+	Tx    uint32 `json:"tx"`
+}
+
+func (id RuneID) String() string {
+	return fmt.Sprintf("%d:%d", id.Block, id.Tx)
+}
+
+// ParseRuneID parses the BLOCK:TX form returned by RuneID.String
+func ParseRuneID(s string) (RuneID, error) {
+	block, tx, ok := strings.Cut(s, ":")
+	if !ok {
+		return RuneID{}, ErrInvalidRuneID
+	}
+
+	b, err := strconv.ParseUint(block, 10, 64)
+	if err != nil {
+		return RuneID{}, ErrInvalidRuneID
+	}
+
+	t, err := strconv.ParseUint(tx, 10, 32)
+	if err != nil {
+		return RuneID{}, ErrInvalidRuneID
+	}
+
+	return RuneID{Block: b, Tx: uint32(t)}, nil
+}
+
+// Less orders ids by chain position
+func (id RuneID) Less(other RuneID) bool {
+	if id.Block != other.Block {
+		return id.Block < other.Block
+	}
+	return id.Tx < other.Tx
+}
+
+// Value stores id in its BLOCK:TX form
+func (id RuneID) Value() (driver.Value, error) {
+	return id.String(), nil
+}
+
+// Scan reads the BLOCK:TX form written by Value
+func (id *RuneID) Scan(src interface{}) error {
+	var err error
+	switch v := src.(type) {
+	case string:
+		*id, err = ParseRuneID(v)
+	case []byte:
+		*id, err = ParseRuneID(string(v))
+	default:
+		err = fmt.Errorf("%w: cannot scan %T", ErrInvalidRuneID, src)
+	}
+	return err
+}
+
+// MarshalText encodes id in its BLOCK:TX form
+func (id RuneID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText parses the BLOCK:TX form
+func (id *RuneID) UnmarshalText(text []byte) (err error) {
+	*id, err = ParseRuneID(string(text))
+	return err
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/alphabatem/btc_rune/db"
 	"github.com/alphabatem/btc_rune/services"
@@ -16,11 +17,21 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
+	storageSvc, err := db.NewStorageService(os.Getenv("DB_DRIVER"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	ctx, err := context.NewContext(
-		&db.SqliteService{},
+		storageSvc,
 		&services.DatabaseService{},
+		&services.RegistryService{},
 		&services.BTCService{},
 		&services.RuneService{},
+		&services.StreamService{},
+		&services.LedgerService{},
+		&services.MempoolService{},
+		&services.WebhookService{},
 		&services.ChainSyncService{},
 		&services.HttpService{},
 	)
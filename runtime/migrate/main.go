@@ -0,0 +1,58 @@
+// Command migrate moves the index schema of the configured storage backend up or down.
+//
+//	go run ./runtime/migrate            apply every pending migration
+//	go run ./runtime/migrate -to 3      migrate up or down to version 3
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/alphabatem/btc_rune/db"
+	"github.com/cloakd/common/context"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	to := flag.Int("to", -1, "schema version to migrate to, latest when negative")
+	flag.Parse()
+
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	storageSvc, err := db.NewStorageService(os.Getenv("DB_DRIVER"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, err := context.NewContext(storageSvc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = ctx.Configure(storageSvc); err != nil {
+		log.Fatal(err)
+	}
+	if err = ctx.Start(storageSvc); err != nil {
+		log.Fatal(err)
+	}
+
+	store := storageSvc.Store()
+	defer store.Close()
+
+	from, err := store.SchemaVersion()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	target := *to
+	if target < 0 {
+		target = store.LatestSchema()
+	}
+	if err = store.MigrateTo(target); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Schema version: %d -> %d (latest %d)", from, target, store.LatestSchema())
+}
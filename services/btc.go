@@ -2,8 +2,11 @@ package services
 
 import (
 	"bytes"
-	"encoding/binary"
+	"errors"
+	"fmt"
 	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/chain"
+	"github.com/alphabatem/btc_rune/codec"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/btcutil"
@@ -13,30 +16,52 @@ import (
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcwallet/wallet"
+	"github.com/cloakd/common/context"
 	"github.com/cloakd/common/services"
-	"io"
+	"log"
 	"os"
 
 	"strconv"
+	"time"
 )
 
 type BTCService struct {
 	services.DefaultService
 
 	httpClient *rpcclient.Client
+	source     chain.Source
 
-	latestBlocks []*chainhash.Hash
+	// sourceKind selects the chain source, one of rpc (the default), blocks or fixtures
+	sourceKind   string
+	sourcePath   string
+	pollInterval time.Duration
 }
 
 const BTC_SVC = "btc_svc"
 
+// recentBlockCount is how many blocks RecentBlocks returns
+const recentBlockCount = 10
+
 func (svc BTCService) Id() string {
 	return BTC_SVC
 }
 
-func (svc *BTCService) Start() (err error) {
-	svc.latestBlocks = []*chainhash.Hash{}
+func (svc *BTCService) Configure(ctx *context.Context) (err error) {
+	svc.sourceKind = os.Getenv("CHAIN_SOURCE")
+	svc.sourcePath = os.Getenv("CHAIN_SOURCE_PATH")
+
+	svc.pollInterval = 30 * time.Second
+	if v := os.Getenv("SYNC_POLL_INTERVAL"); v != "" {
+		svc.pollInterval, err = time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+	}
 
+	return svc.DefaultService.Configure(ctx)
+}
+
+func (svc *BTCService) Start() (err error) {
 	disableTls, err := strconv.ParseBool(os.Getenv("RPC_DisableTLS"))
 
 	svc.httpClient, err = rpcclient.New(&rpcclient.ConnConfig{
@@ -50,94 +75,94 @@ func (svc *BTCService) Start() (err error) {
 		return err
 	}
 
+	switch svc.sourceKind {
+	case "", "rpc":
+		svc.source = chain.NewRPC(svc.httpClient, svc.pollInterval)
+	case "blocks":
+		svc.source, err = chain.OpenBlockFiles(svc.sourcePath, &chaincfg.MainNetParams)
+	case "fixtures":
+		svc.source, err = chain.OpenFixtures(svc.sourcePath)
+	default:
+		err = fmt.Errorf("unknown CHAIN_SOURCE %q", svc.sourceKind)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Chain source: %s %s", svc.sourceKind, svc.sourcePath)
 	return nil
 }
 
+// Source returns the chain blocks and transactions are read from
+func (svc *BTCService) Source() chain.Source {
+	return svc.source
+}
+
+// Live reports whether the source is a node, which alone has a mempool and wallet
+func (svc *BTCService) Live() bool {
+	_, ok := svc.source.(*chain.RPC)
+	return ok
+}
+
+// RecentBlocks returns the hashes of the last blocks up to the tip, oldest first
 func (svc *BTCService) RecentBlocks() ([]*chainhash.Hash, error) {
-	blockCount, err := svc.httpClient.GetBlockCount()
+	tip, _, err := svc.source.Tip()
 	if err != nil {
 		return nil, err
 	}
 
-	limit := 10
-	if len(svc.latestBlocks) > limit {
-		svc.latestBlocks = svc.latestBlocks[:limit-1]
+	start := tip - recentBlockCount + 1
+	if start < 0 {
+		start = 0
 	}
 
-	if len(svc.latestBlocks) > 1 {
-		hash, err := svc.httpClient.GetBestBlockHash()
-		if err != nil {
-			return nil, err
-		}
-
-		if !svc.latestBlocks[len(svc.latestBlocks)-1].IsEqual(hash) {
-			svc.latestBlocks = append(svc.latestBlocks, hash)
+	var hashes []*chainhash.Hash
+	for height := start; height <= tip; height++ {
+		hash, err := svc.source.BlockHash(height)
+		if errors.Is(err, chain.ErrBlockNotFound) {
+			// Reorganised away since the tip was read
+			continue
 		}
-
-		return svc.latestBlocks, nil
-	}
-
-	//Seed from scratch
-	start := blockCount - int64(limit)
-	for i := start; i <= blockCount; i++ {
-		hash, err := svc.httpClient.GetBlockHash(i)
 		if err != nil {
-			continue
+			return nil, err
 		}
-		svc.latestBlocks = append(svc.latestBlocks, hash)
+		hashes = append(hashes, hash)
 	}
-
-	return svc.latestBlocks, nil
+	return hashes, nil
 }
 
 func (svc *BTCService) Block(blockHash *chainhash.Hash) (*wire.MsgBlock, error) {
-	return svc.httpClient.GetBlock(blockHash)
+	return svc.source.BlockByHash(blockHash)
 }
 
-func (svc *BTCService) Transaction(txHash *chainhash.Hash) (*btcutil.Tx, error) {
-	return svc.httpClient.GetRawTransaction(txHash)
+func (svc *BTCService) Transaction(txHash *chainhash.Hash) (*wire.MsgTx, error) {
+	return svc.source.Transaction(txHash)
 }
 
-func (svc *BTCService) CreateIssuanceTransaction(symbol string, decimals uint64) (*wire.MsgTx, error) {
-	// Create a new transaction
-	tx := wire.NewMsgTx(wire.TxVersion)
-
-	// Create the TxIn (what you are spending)
-	outpoint := wire.NewOutPoint(&chainhash.Hash{}, 1)
-	txin := wire.NewTxIn(outpoint, nil, nil)
-	tx.AddTxIn(txin)
-
-	// Prepare Rune protocol issuance data
-	var runeData bytes.Buffer
-	runeData.WriteByte('R')
-
-	// Encode SYMBOL and DECIMALS as Varints
-	symbolEncoded := []byte(symbol)
-	for _, s := range symbolEncoded {
-		runeData.Write(svc.toVarInt(uint64(s - 'A')))
-	}
-	runeData.Write(svc.toVarInt(decimals))
-
-	// Build OP_RETURN script
-	opReturnScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).AddData(runeData.Bytes()).Script()
-	if err != nil {
-		return nil, err
+// Mempool returns the txids in the node mempool
+func (svc *BTCService) Mempool() ([]*chainhash.Hash, error) {
+	if !svc.Live() {
+		return nil, fmt.Errorf("%w: mempool of %s source", chain.ErrUnsupported, svc.sourceKind)
 	}
+	return svc.httpClient.GetRawMempool()
+}
 
-	// Create the OP_RETURN TxOut
-	opReturnTxOut := wire.NewTxOut(0, opReturnScript)
-	tx.AddTxOut(opReturnTxOut)
-
-	// Create the TxOut (where you are sending to)
-	addr, _ := btcutil.DecodeAddress("recipientAddressHere", &chaincfg.MainNetParams)
-	script, _ := txscript.PayToAddrScript(addr)
-	txout := wire.NewTxOut(50000, script)
-	tx.AddTxOut(txout)
-
-	return tx, nil
+func (svc *BTCService) CreateIssuanceTransaction(symbol string, decimals uint64) (*wire.MsgTx, error) {
+	return svc.createRuneTransaction(&btc_rune.Transaction{
+		Issuance: &btc_rune.Rune{
+			Symbol:   symbol,
+			Decimals: decimals,
+		},
+	})
 }
 
 func (svc *BTCService) CreateTransferTransaction(assignments []*btc_rune.Assignment) (*wire.MsgTx, error) {
+	return svc.createRuneTransaction(&btc_rune.Transaction{
+		Transfers: assignments,
+	})
+}
+
+func (svc *BTCService) createRuneTransaction(runeTx *btc_rune.Transaction) (*wire.MsgTx, error) {
 	// Create a new transaction
 	tx := wire.NewMsgTx(wire.TxVersion)
 
@@ -146,19 +171,8 @@ func (svc *BTCService) CreateTransferTransaction(assignments []*btc_rune.Assignm
 	txin := wire.NewTxIn(outpoint, nil, nil)
 	tx.AddTxIn(txin)
 
-	// Prepare Rune protocol transfer data
-	var runeData bytes.Buffer
-	runeData.WriteByte('R')
-
-	// Encode assignments as Varints
-	for _, a := range assignments {
-		runeData.Write(svc.toVarInt(a.ID))
-		runeData.Write(svc.toVarInt(a.Output))
-		runeData.Write(svc.toVarInt(a.Amount))
-	}
-
 	// Build OP_RETURN script
-	opReturnScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).AddData(runeData.Bytes()).Script()
+	opReturnScript, err := codec.Encode(runeTx)
 	if err != nil {
 		return nil, err
 	}
@@ -211,64 +225,3 @@ func Send(tx *wire.MsgTx) error {
 
 	return nil
 }
-
-// Helper function to encode integers into Variants
-func (svc *BTCService) toVarInt(value uint64) []byte {
-	buf := make([]byte, binary.MaxVarintLen64)
-	n := binary.PutUvarint(buf, value)
-	return buf[:n]
-}
-
-func (svc *BTCService) DecodeVarByte(buf *bytes.Buffer) []byte {
-	prefix, err := buf.ReadByte()
-	if err == io.EOF {
-		return []byte{}
-	}
-
-	if prefix < 1 {
-		next, err := buf.ReadByte()
-		if err == io.EOF {
-			return []byte{prefix}
-		}
-
-		return []byte{next}
-	}
-
-	//log.Println("PREFIX", prefix, buf.Len())
-
-	if buf.Len() == 0 {
-		return []byte{prefix}
-	}
-
-	if prefix == 254 {
-		prefix = 6
-	}
-
-	if prefix == 255 {
-		prefix = 8
-	}
-
-	by := make([]byte, prefix)
-	_, _ = buf.Read(by)
-	return by
-}
-
-func (svc *BTCService) DecodeVarInt(buf *bytes.Buffer) uint64 {
-	by := svc.DecodeVarByte(buf)
-	return svc.ByteToInt(by)
-}
-
-func (svc *BTCService) ByteToInt(by []byte) uint64 {
-	switch {
-	case len(by) == 0:
-		return 0
-	case len(by) == 1:
-		return uint64(by[0])
-	case len(by) <= 2:
-		return uint64(binary.LittleEndian.Uint16(by))
-	case len(by) <= 4:
-		return uint64(binary.LittleEndian.Uint32(by))
-	default:
-		return binary.LittleEndian.Uint64(by)
-	}
-}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"errors"
+	"github.com/alphabatem/btc_rune/chain"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"testing"
+)
+
+// heightSource serves a chain whose block hashes are their heights
+type heightSource struct {
+	chain.Source
+
+	tip int64
+	err error
+}
+
+func (s *heightSource) Tip() (int64, *chainhash.Hash, error) {
+	hash, err := s.BlockHash(s.tip)
+	return s.tip, hash, err
+}
+
+func (s *heightSource) BlockHash(height int64) (*chainhash.Hash, error) {
+	if height > s.tip {
+		return nil, chain.ErrBlockNotFound
+	}
+	if s.err != nil && height == s.tip-1 {
+		return nil, s.err
+	}
+	return &chainhash.Hash{byte(height)}, nil
+}
+
+func TestBTCService_RecentBlocks(t *testing.T) {
+	for tip, want := range map[int64]int{100: recentBlockCount, 3: 4, 0: 1} {
+		svc := &BTCService{source: &heightSource{tip: tip}}
+		hashes, err := svc.RecentBlocks()
+		if err != nil || len(hashes) != want {
+			t.Fatalf("tip %d: Expected %d hashes - Got %d %v", tip, want, len(hashes), err)
+		}
+		if hashes[len(hashes)-1][0] != byte(tip) {
+			t.Fatalf("tip %d: Expected the tip last - Got %v", tip, hashes[len(hashes)-1])
+		}
+	}
+
+	svc := &BTCService{source: &heightSource{tip: 100, err: chain.ErrUnavailable}}
+	if _, err := svc.RecentBlocks(); !errors.Is(err, chain.ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable - Got %v", err)
+	}
+}
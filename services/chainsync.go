@@ -2,9 +2,21 @@ package services
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/chain"
+	"github.com/alphabatem/btc_rune/pipeline"
+	"github.com/alphabatem/btc_rune/webhook"
+	"github.com/alphabatem/btc_rune/zmq"
+	"github.com/cloakd/common/context"
 	"github.com/cloakd/common/services"
+	"runtime"
+	"strconv"
+	"time"
 
 	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/btcsuite/btcd/wire"
@@ -16,61 +28,137 @@ import (
 type ChainSyncService struct {
 	services.DefaultService
 
-	btc  *BTCService
-	rune *RuneService
+	btc     *BTCService
+	rune    *RuneService
+	ledger  *LedgerService
+	mempool *MempoolService
 
-	wsClient   *rpcclient.Client
-	httpClient *rpcclient.Client
+	source   chain.Source
+	wsClient *rpcclient.Client
 
 	blockHashes chan *chainhash.Hash
+	rawBlocks   chan *wire.MsgBlock
+
+	// rawTxs holds rawtx notifications until their mempool sequence event, cleared on every block
+	rawTxs map[chainhash.Hash]*wire.MsgTx
+	zmqURL string
+
+	// startHeight is where indexing begins when nothing is indexed yet, the rune activation height
+	// unless configured, startAtTip starts at the node tip
+	startHeight   int64
+	notifications bool
+
+	// workers fetch and decode blocks in parallel, up to prefetch blocks ahead of the ledger
+	workers  int
+	prefetch int
+	metrics  *pipeline.Metrics
 }
 
 const CHAIN_SYNC_SVC = "chain_sync_svc"
 
+// Progress is logged every backfillLogInterval blocks
+const backfillLogInterval = 1000
+
+// zmqTimeout bounds reads within a notification and the wait between reconnects
+const zmqTimeout = 5 * time.Second
+
+// A failed catch up is retried after catchUpRetry, doubled per failure up to catchUpMaxRetry
+const (
+	catchUpRetry    = time.Second
+	catchUpMaxRetry = time.Minute
+)
+
+// startAtTip is the start height of an index that holds no history, INDEX_START_HEIGHT=tip
+const startAtTip = -1
+
+// maxRawTxs caps the rawtx notifications waiting for their sequence event
+const maxRawTxs = 10000
+
 func (svc ChainSyncService) Id() string {
 	return CHAIN_SYNC_SVC
 }
 
+func (svc *ChainSyncService) Configure(ctx *context.Context) (err error) {
+	svc.startHeight = btc_rune.ActivationHeight(&chaincfg.MainNetParams)
+	switch v := os.Getenv("INDEX_START_HEIGHT"); v {
+	case "":
+	case "tip":
+		svc.startHeight = startAtTip
+	default:
+		svc.startHeight, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		if svc.startHeight < 0 {
+			return fmt.Errorf("negative INDEX_START_HEIGHT %d", svc.startHeight)
+		}
+	}
+
+	svc.workers = runtime.NumCPU()
+	if v := os.Getenv("SYNC_WORKERS"); v != "" {
+		svc.workers, err = strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	svc.prefetch = 4 * svc.workers
+	if v := os.Getenv("SYNC_PREFETCH"); v != "" {
+		svc.prefetch, err = strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	svc.zmqURL = os.Getenv("ZMQ_URL")
+
+	if v := os.Getenv("RPC_NOTIFICATIONS"); v != "" {
+		svc.notifications, err = strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return svc.DefaultService.Configure(ctx)
+}
+
 func (svc *ChainSyncService) Start() (err error) {
 	svc.btc = svc.Service(BTC_SVC).(*BTCService)
 	svc.rune = svc.Service(RUNE_SVC).(*RuneService)
+	svc.ledger = svc.Service(LEDGER_SVC).(*LedgerService)
+	svc.mempool = svc.Service(MEMPOOL_SVC).(*MempoolService)
 
 	svc.blockHashes = make(chan *chainhash.Hash, 10)
+	svc.rawBlocks = make(chan *wire.MsgBlock, 10)
+	svc.rawTxs = map[chainhash.Hash]*wire.MsgTx{}
 
 	logger := btclog.NewBackend(os.Stdout).Logger("MAIN")
-	logger.SetLevel(btclog.LevelDebug)
+	logger.SetLevel(btclog.LevelInfo)
 	rpcclient.UseLogger(logger)
 
-	svc.httpClient, err = rpcclient.New(&rpcclient.ConnConfig{
-		User:         "93c9cf43bb7263123fef469f13e695d23fca3c23",
-		Pass:         "93c9cf43bb7263123fef469f13e695d23fca3c23",
-		Host:         os.Getenv("RPC_URL"),
-		DisableTLS:   true,
-		HTTPPostMode: true,
-	}, nil)
-	if err != nil {
-		return err
+	svc.source = svc.btc.Source()
+	svc.metrics = pipeline.NewMetrics()
+	svc.verifyTip()
+
+	if svc.notifications {
+		err = svc.startWS()
+		if err != nil {
+			return err
+		}
 	}
 
-	//th, _ := chainhash.NewHashFromStr("1aa98283f61cea9125aea58441067baca2533e2bbf8218b5e4f9ef7b8c0d8c30")
-	//th, _ := chainhash.NewHashFromStr("2aefe2887654b3e4e7addd8f7c6496c26110833342830c19babda8d3875072ea")
-	//tx, err := svc.httpClient.GetRawTransaction(th)
-	//if err != nil {
-	//	return err
-	//}
-	//
-	//for _, out := range tx.MsgTx().TxOut {
-	//	_ = svc.onRuneTransaction(*th, out.PkScript)
-	//}
+	if svc.zmqURL != "" {
+		go svc.listenZMQ()
+	}
 
-	//return svc.startWS()
+	go svc.listen()
 	return nil
 }
 
 func (svc *ChainSyncService) startWS() (err error) {
 	connCfg := &rpcclient.ConnConfig{
-		User:         "93c9cf43bb7263123fef469f13e695d23fca3c23",
-		Pass:         "93c9cf43bb7263123fef469f13e695d23fca3c23",
+		User:         os.Getenv("RPC_USER"),
+		Pass:         os.Getenv("RPC_PASS"),
 		Host:         os.Getenv("RPC_URL"),
 		HTTPPostMode: false,
 		DisableTLS:   true,
@@ -104,17 +192,18 @@ func (svc *ChainSyncService) startWS() (err error) {
 		return err
 	}
 
-	go svc.listen() //TODO Remove?
 	return nil
 }
 
 func (svc *ChainSyncService) onBlockConnected(height int32, header *wire.BlockHeader, txs []*btcutil.Tx) {
 	log.Printf("Block Connected: %v - %s - Len: %v", height, header.BlockHash(), len(txs))
+	hash := header.BlockHash()
+	_ = svc.handleNewBlock(&hash)
 }
 
 func (svc *ChainSyncService) onTxAccepted(hash *chainhash.Hash, amount btcutil.Amount) {
 	log.Printf("New TXN: %s", hash)
-	err := svc.handleNewBlock(hash)
+	err := svc.mempool.AddHash(hash)
 	if err != nil {
 		log.Printf("onTxAccepted Err: %s", err)
 	}
@@ -124,53 +213,256 @@ func (svc *ChainSyncService) onRelevantTxAccepted(transaction []byte) {
 	log.Printf("Txn: %v", transaction)
 }
 
+// verifyTip compares the indexed tip with the chain source before syncing resumes from it.
+// A tip the source's best chain no longer has is rolled back by the first catch up.
+func (svc *ChainSyncService) verifyTip() {
+	tip, err := svc.ledger.Tip()
+	if err != nil || tip == nil {
+		return
+	}
+
+	hash, err := svc.source.BlockHash(tip.Height)
+	if err != nil {
+		log.Printf("Indexed tip %s at %d not verified against the chain source: %s", tip.Hash, tip.Height, err)
+		return
+	}
+	if hash.String() != tip.Hash {
+		log.Printf("Indexed tip %s at %d is off the best chain, which has %s - it will be rolled back by catch up", tip.Hash, tip.Height, hash)
+	}
+}
+
+// listen backfills up to the source tip, then follows it on every notification or new tip.
+// A failed catch up is retried with backoff, static sources notify nothing else.
 func (svc *ChainSyncService) listen() {
+	// Static sources never notify, a nil channel blocks forever
+	tips := svc.source.Subscribe(nil)
+
+	err := svc.catchUp()
+	failures := 0
 	for {
+		var retry <-chan time.Time
+		if err != nil {
+			failures++
+			wait := webhook.Backoff(catchUpRetry, catchUpMaxRetry, failures)
+			log.Printf("catchUp Err %s - retrying in %s", err, wait)
+			retry = time.After(wait)
+		} else {
+			failures = 0
+		}
+
 		select {
+		case <-retry:
+			err = svc.catchUp()
+		case block := <-svc.rawBlocks:
+			err = svc.applyRaw(block)
 		case block := <-svc.blockHashes:
-			err := svc.handleNewBlock(block)
-			if err != nil {
-				log.Println("handleBlockErr", err)
-			}
+			log.Println("New Block", block)
+			err = svc.catchUp()
+		case <-tips:
+			err = svc.catchUp()
+		}
+	}
+}
+
+// applyRaw applies a block received in full when it extends the indexed tip,
+// anything else is resolved through the chain source by catching up
+func (svc *ChainSyncService) applyRaw(block *wire.MsgBlock) error {
+	tip, err := svc.ledger.Tip()
+	if err != nil {
+		return err
+	}
+
+	if tip == nil || block.Header.PrevBlock.String() != tip.Hash {
+		return svc.catchUp()
+	}
+
+	err = svc.ledger.ApplyBlock(tip.Height+1, block)
+	if err != nil {
+		return err
+	}
+	svc.mempool.Confirm(block)
+	return nil
+}
+
+// listenZMQ follows the bitcoind ZMQ publisher, resubscribing whenever the subscription ends
+func (svc *ChainSyncService) listenZMQ() {
+	for {
+		sub, err := zmq.Subscribe(svc.zmqURL, zmqTimeout)
+		if err == nil {
+			log.Println("Connected ZMQ", svc.zmqURL)
+			err = sub.Run(svc)
+			sub.Close()
+		}
+
+		log.Println("ZMQ Err", err)
+		time.Sleep(zmqTimeout)
+	}
+}
+
+// OnBlock queues a rawblock notification for the sync loop
+func (svc *ChainSyncService) OnBlock(block *wire.MsgBlock) {
+	svc.rawBlocks <- block
+}
+
+// OnTx holds a rawtx notification until its mempool sequence event
+func (svc *ChainSyncService) OnTx(tx *wire.MsgTx) {
+	if len(svc.rawTxs) >= maxRawTxs {
+		svc.rawTxs = map[chainhash.Hash]*wire.MsgTx{}
+	}
+	svc.rawTxs[tx.TxHash()] = tx
+}
 
+// OnSequence tracks mempool additions and removals, and wakes the sync loop on disconnects
+func (svc *ChainSyncService) OnSequence(s *zmq.Sequence) {
+	var err error
+	switch s.Label {
+	case zmq.TxAdded:
+		tx, ok := svc.rawTxs[s.Hash]
+		delete(svc.rawTxs, s.Hash)
+		if ok {
+			err = svc.mempool.Add(tx)
+		} else {
+			err = svc.mempool.AddHash(&s.Hash)
 		}
+	case zmq.TxRemoved:
+		svc.mempool.Remove(s.Hash.String())
+	case zmq.BlockConnected:
+		// rawtx is also published for the transactions of connected blocks
+		svc.rawTxs = map[chainhash.Hash]*wire.MsgTx{}
+	case zmq.BlockDisconnected:
+		err = svc.handleNewBlock(&s.Hash)
+	}
+	if err != nil {
+		log.Printf("OnSequence %c %s Err: %s", s.Label, s.Hash, err)
 	}
 }
 
+// OnGap re-fetches from the chain source whatever the dropped notifications of topic carried
+func (svc *ChainSyncService) OnGap(topic string) {
+	log.Println("ZMQ Gap", topic)
+	switch topic {
+	case zmq.TopicRawBlock:
+		_ = svc.handleNewBlock(nil)
+	case zmq.TopicSequence:
+		_ = svc.handleNewBlock(nil)
+		svc.mempool.Resync()
+	case zmq.TopicRawTx:
+		svc.mempool.Resync()
+	}
+}
+
+// handleNewBlock wakes the sync loop, dropped when a wake up is already queued
 func (svc *ChainSyncService) handleNewBlock(blockHash *chainhash.Hash) error {
-	log.Println("New Block", blockHash)
-	block, err := svc.httpClient.GetBlock(blockHash)
+	select {
+	case svc.blockHashes <- blockHash:
+	default:
+	}
+	return nil
+}
+
+// catchUp applies the source's best chain from the indexed tip, or the start height
+// when nothing is indexed, up to the source tip. Blocks the source no longer has are rolled back first.
+func (svc *ChainSyncService) catchUp() error {
+	target, _, err := svc.source.Tip()
 	if err != nil {
 		return err
 	}
 
-	for _, tx := range block.Transactions {
-		isRuneTx, runeData := svc.rune.isRuneTransaction(tx)
-		if !isRuneTx {
-			continue
-		}
+	tip, err := svc.ledger.Tip()
+	if err != nil {
+		return err
+	}
 
-		err = svc.onRuneTransaction(tx.TxHash(), runeData)
+	start := svc.startHeight
+	if start == startAtTip {
+		start = target
+	}
+
+	if tip != nil {
+		fork, err := svc.forkPoint(tip)
 		if err != nil {
 			return err
 		}
+
+		if fork < tip.Height {
+			err = svc.ledger.Rollback(fork)
+			if err != nil {
+				return err
+			}
+		}
+		start = fork + 1
 	}
-	return nil
-}
 
-func (svc *ChainSyncService) onRuneTransaction(txID chainhash.Hash, data []byte) error {
-	tx := svc.rune.DecodeTransaction(&txID, data)
-	if tx == nil {
+	if start > target {
 		return nil
 	}
 
-	if tx.Issuance != nil {
-		log.Printf("ISSUANCE: %+v\n", tx.Issuance)
+	if target-start >= backfillLogInterval {
+		log.Printf("BACKFILL: %d blocks from %d to %d", target-start+1, start, target)
 	}
+	return svc.applyRange(start, target)
+}
 
-	for _, a := range tx.Transfers {
-		log.Printf("XFER: %v - %v - %v", a.ID, a.Amount, a.Output)
+// forkPoint returns the highest indexed height the source's best chain still agrees with
+func (svc *ChainSyncService) forkPoint(tip *btc_rune.Block) (int64, error) {
+	for height := tip.Height; height > tip.Height-UndoDepth; height-- {
+		indexed, err := svc.ledger.BlockAt(height)
+		if err != nil {
+			return 0, err
+		}
+		if indexed == nil {
+			break
+		}
+
+		hash, err := svc.source.BlockHash(height)
+		if errors.Is(err, chain.ErrBlockNotFound) {
+			continue // Above the tip of a shorter best chain
+		}
+		if err != nil {
+			return 0, err
+		}
+		if hash.String() == indexed.Hash {
+			return height, nil
+		}
 	}
+	return 0, fmt.Errorf("%w: no common ancestor with the chain source below %d", ErrUndoUnavailable, tip.Height)
+}
 
-	return nil
+// Metrics returns where indexing time went since start
+func (svc *ChainSyncService) Metrics() pipeline.Snapshot {
+	return svc.metrics.Snapshot()
+}
+
+// applyRange applies the source's best chain blocks from start to end inclusive.
+// Blocks are fetched and decoded in parallel and applied in height order.
+func (svc *ChainSyncService) applyRange(start, end int64) error {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	blocks := pipeline.New(svc.source, svc.workers, svc.prefetch, svc.metrics).Run(stop, start, end)
+	for {
+		waited := time.Now()
+		b, ok := <-blocks
+		if !ok {
+			return nil
+		}
+		svc.metrics.Stalled(time.Since(waited))
+		if b.Err != nil {
+			return b.Err
+		}
+
+		applied := time.Now()
+		err := svc.ledger.ApplyDecoded(b.Height, b.Block, b.Runes)
+		if err != nil {
+			return err
+		}
+		svc.metrics.Committed(b, time.Since(applied))
+		svc.mempool.Confirm(b.Block)
+
+		if (b.Height-start+1)%backfillLogInterval == 0 {
+			m := svc.metrics.Snapshot()
+			log.Printf("BACKFILL: applied %d of %d up to %d - %.1f blocks/s, fetch %dms decode %dms stall %dms commit %dms",
+				b.Height-start+1, end-start+1, b.Height, m.BlocksPerSec, m.FetchMs, m.DecodeMs, m.StallMs, m.CommitMs)
+		}
+	}
 }
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"fmt"
+	"github.com/alphabatem/btc_rune/chain"
+	"github.com/alphabatem/btc_rune/db"
+	"github.com/alphabatem/btc_rune/mempool"
+	"github.com/alphabatem/btc_rune/pipeline"
+	"github.com/alphabatem/btc_rune/stream"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// blockSource serves a best chain held in memory, starting at height 0
+type blockSource struct {
+	chain.Source
+
+	blocks []*wire.MsgBlock
+}
+
+func (s *blockSource) BlockByHeight(height int64) (*wire.MsgBlock, error) {
+	if height < 0 || height >= int64(len(s.blocks)) {
+		return nil, fmt.Errorf("%w: height %d", chain.ErrBlockNotFound, height)
+	}
+	return s.blocks[height], nil
+}
+
+func (s *blockSource) BlockHash(height int64) (*chainhash.Hash, error) {
+	block, err := s.BlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	hash := block.BlockHash()
+	return &hash, nil
+}
+
+func (s *blockSource) Tip() (int64, *chainhash.Hash, error) {
+	height := int64(len(s.blocks)) - 1
+	hash, err := s.BlockHash(height)
+	return height, hash, err
+}
+
+// extend returns blocks with n blocks mined on top, branch tells competing chains apart
+func extend(blocks []*wire.MsgBlock, n int, branch byte) []*wire.MsgBlock {
+	out := append([]*wire.MsgBlock(nil), blocks...)
+	for i := 0; i < n; i++ {
+		var prev chainhash.Hash
+		if len(out) > 0 {
+			prev = out[len(out)-1].BlockHash()
+		}
+
+		coinbase := wire.NewMsgTx(wire.TxVersion)
+		coinbase.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+			SignatureScript:  []byte{byte(len(out)), branch},
+		})
+		coinbase.AddTxOut(wire.NewTxOut(50, []byte{txscript.OP_TRUE}))
+
+		// With a single transaction the merkle root is its hash
+		block := wire.NewMsgBlock(&wire.BlockHeader{PrevBlock: prev, MerkleRoot: coinbase.TxHash(), Timestamp: time.Unix(int64(len(out)), 0)})
+		block.AddTransaction(coinbase)
+		out = append(out, block)
+	}
+	return out
+}
+
+func TestChainSyncService_ReorgOntoShorterChain(t *testing.T) {
+	store, err := db.NewBoltStore(filepath.Join(t.TempDir(), "rune.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	streamSvc := &StreamService{hub: stream.NewHub(64)}
+	source := &blockSource{blocks: extend(nil, 4, 'a')}
+	svc := &ChainSyncService{
+		source:   source,
+		ledger:   &LedgerService{store: store, stream: streamSvc, params: &chaincfg.MainNetParams},
+		mempool:  &MempoolService{pool: mempool.New(), stream: streamSvc},
+		workers:  1,
+		prefetch: 1,
+		metrics:  pipeline.NewMetrics(),
+	}
+	if err = svc.catchUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new best chain forks after height 1 and is one block shorter than the index
+	source.blocks = extend(source.blocks[:2], 1, 'b')
+	if err = svc.catchUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	tip, err := svc.ledger.Tip()
+	want := source.blocks[2].BlockHash().String()
+	if err != nil || tip == nil || tip.Height != 2 || tip.Hash != want {
+		t.Fatalf("Expected tip %s at 2 - Got %+v %v", want, tip, err)
+	}
+}
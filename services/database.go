@@ -1,15 +1,17 @@
 package services
 
 import (
-	"github.com/alphabatem/btc_rune"
+	"log"
+
 	"github.com/alphabatem/btc_rune/db"
+	"github.com/alphabatem/btc_rune/storage"
 	"github.com/cloakd/common/services"
 )
 
 type DatabaseService struct {
 	services.DefaultService
 
-	dbSvc *db.SqliteService
+	store storage.Store
 }
 
 const DATABASE_SVC = "database_svc"
@@ -19,13 +21,19 @@ func (svc DatabaseService) Id() string {
 }
 
 func (svc *DatabaseService) Start() error {
-	svc.dbSvc = svc.Service(db.SQLITE_SVC).(*db.SqliteService)
+	svc.store = svc.Service(db.STORAGE_SVC).(db.StorageService).Store()
 
-	err := svc.dbSvc.Db().AutoMigrate(&btc_rune.Rune{})
+	// Migrate fails with storage.ErrSchemaTooNew rather than run against a schema it does not know
+	err := svc.store.Migrate()
 	if err != nil {
 		return err
 	}
 
+	version, err := svc.store.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	log.Printf("Schema version: %d", version)
 	return nil
 }
 
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/chain"
+	"github.com/alphabatem/btc_rune/codec"
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/alphabatem/btc_rune/stream"
+)
+
+// ErrorCode classifies an API error, clients branch on it rather than on the message
+type ErrorCode string
+
+const (
+	CodeInvalidInput ErrorCode = "INVALID_INPUT"
+	CodeUnauthorized ErrorCode = "UNAUTHORIZED"
+	CodeNotFound     ErrorCode = "NOT_FOUND"
+	// CodeNotRuneTx is a transaction that exists but carries no rune script
+	CodeNotRuneTx ErrorCode = "NOT_RUNE_TRANSACTION"
+	// CodeUpstreamUnavailable is a node that cannot be reached or a chain source that cannot serve the request
+	CodeUpstreamUnavailable ErrorCode = "UPSTREAM_UNAVAILABLE"
+	// CodeIndexBehind is a request for blocks the index has not reached yet, retrying later succeeds
+	CodeIndexBehind ErrorCode = "INDEX_BEHIND"
+	// CodeStreamBehind ends a stream whose subscriber fell behind, it resumes from its last token
+	CodeStreamBehind ErrorCode = "STREAM_BEHIND"
+	CodeInternal     ErrorCode = "INTERNAL"
+)
+
+// errorStatus is the HTTP status of each code
+var errorStatus = map[ErrorCode]int{
+	CodeInvalidInput:        400,
+	CodeUnauthorized:        401,
+	CodeNotFound:            404,
+	CodeNotRuneTx:           422,
+	CodeUpstreamUnavailable: 503,
+	CodeIndexBehind:         503,
+	CodeStreamBehind:        503,
+	CodeInternal:            500,
+}
+
+// errorClasses maps the errors the services return onto codes, the first match wins
+var errorClasses = []struct {
+	code ErrorCode
+	errs []error
+}{
+	{CodeNotFound, []error{storage.ErrNotFound, chain.ErrBlockNotFound, chain.ErrTxNotFound}},
+	{CodeNotRuneTx, []error{codec.ErrNotRunestone}},
+	{CodeUpstreamUnavailable, []error{chain.ErrUnavailable, chain.ErrUnsupported}},
+	{CodeIndexBehind, []error{ErrIndexBehind}},
+	{CodeStreamBehind, []error{ErrStreamBehind}},
+	{CodeUnauthorized, []error{ErrUnauthorized}},
+	{CodeInvalidInput, []error{
+		btc_rune.ErrInvalidRuneID, btc_rune.ErrInvalidOutpoint, ErrInvalidAddress, ErrInvalidHash,
+		codec.ErrInvalidSymbol, codec.ErrNegativeSymbol,
+		stream.ErrInvalidToken, ErrStaleToken, ErrInvalidWebhook,
+	}},
+}
+
+// ErrIndexBehind is returned when a request needs blocks above the indexed tip
+var ErrIndexBehind = errors.New("index has not reached the block")
+
+// APIError is the body of every error response, Details is null unless the code documents them
+type APIError struct {
+	Code    ErrorCode   `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details"`
+
+	err error
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.err
+}
+
+// Status returns the HTTP status of the error
+func (e *APIError) Status() int {
+	if status, ok := errorStatus[e.Code]; ok {
+		return status
+	}
+	return 500
+}
+
+// invalidInput marks err, typically from parsing a request, as invalid input
+func invalidInput(err error) *APIError {
+	return &APIError{Code: CodeInvalidInput, Message: err.Error(), err: err}
+}
+
+// toAPIError classifies err by the errors it wraps.
+// Unclassified errors are internal, their message is logged rather than returned.
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	for _, class := range errorClasses {
+		for _, target := range class.errs {
+			if errors.Is(err, target) {
+				return &APIError{Code: class.code, Message: err.Error(), err: err}
+			}
+		}
+	}
+	return &APIError{Code: CodeInternal, Message: "internal error", err: err}
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/chain"
+	"github.com/alphabatem/btc_rune/codec"
+	"github.com/alphabatem/btc_rune/storage"
+	"testing"
+)
+
+func TestToAPIError(t *testing.T) {
+	for name, c := range map[string]struct {
+		err     error
+		code    ErrorCode
+		status  int
+		message string
+	}{
+		"invalid rune id": {fmt.Errorf("%s: %w", "x", btc_rune.ErrInvalidRuneID), CodeInvalidInput, 400, "x: rune id must be BLOCK:TX"},
+		"invalid hash":    {fmt.Errorf("%w: %q", ErrInvalidHash, "zz"), CodeInvalidInput, 400, `invalid hash: "zz"`},
+		"parse":           {invalidInput(errors.New("invalid limit")), CodeInvalidInput, 400, "invalid limit"},
+		"unauthorized":    {ErrUnauthorized, CodeUnauthorized, 401, "unauthorized"},
+		"rune not found":  {storage.ErrNotFound, CodeNotFound, 404, "not found"},
+		"tx not found":    {fmt.Errorf("%w: abc", chain.ErrTxNotFound), CodeNotFound, 404, "transaction not found: abc"},
+		"not rune tx":     {codec.ErrNotRunestone, CodeNotRuneTx, 422, "not a rune script"},
+		"node down":       {fmt.Errorf("%w: connection refused", chain.ErrUnavailable), CodeUpstreamUnavailable, 503, "chain source unavailable: connection refused"},
+		"no mempool":      {fmt.Errorf("%w: mempool", chain.ErrUnsupported), CodeUpstreamUnavailable, 503, "not supported by chain source: mempool"},
+		"index behind":    {fmt.Errorf("%w: rune 900000:1", ErrIndexBehind), CodeIndexBehind, 503, "index has not reached the block: rune 900000:1"},
+		"internal":        {errors.New("disk I/O error"), CodeInternal, 500, "internal error"},
+	} {
+		apiErr := toAPIError(c.err)
+		if apiErr.Code != c.code || apiErr.Status() != c.status || apiErr.Message != c.message {
+			t.Fatalf("%s: Expected %s %d %q - Got %s %d %q", name, c.code, c.status, c.message, apiErr.Code, apiErr.Status(), apiErr.Message)
+		}
+		if !errors.Is(apiErr, c.err) {
+			t.Fatalf("%s: Expected the error wrapped", name)
+		}
+	}
+
+	data, err := json.Marshal(toAPIError(storage.ErrNotFound))
+	if err != nil || string(data) != `{"code":"NOT_FOUND","message":"not found","details":null}` {
+		t.Fatalf("Expected the error envelope - Got %s %v", data, err)
+	}
+}
@@ -1,16 +1,25 @@
 package services
 
 import (
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/mempool"
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/alphabatem/btc_rune/stream"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/websocket"
 	"github.com/cloakd/common/context"
 	"github.com/cloakd/common/services"
 	"github.com/gin-contrib/cors"
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -22,8 +31,13 @@ type HttpService struct {
 
 	startTime time.Time
 
-	runeSvc *RuneService
-	btcSvc  *BTCService
+	runeSvc    *RuneService
+	btcSvc     *BTCService
+	ledgerSvc  *LedgerService
+	mempoolSvc *MempoolService
+	syncSvc    *ChainSyncService
+	streamSvc  *StreamService
+	webhookSvc *WebhookService
 }
 
 var ErrUnauthorized = errors.New("unauthorized")
@@ -48,9 +62,22 @@ func (svc *HttpService) Configure(ctx *context.Context) (err error) {
 func (svc *HttpService) Start() error {
 	svc.runeSvc = svc.Service(RUNE_SVC).(*RuneService)
 	svc.btcSvc = svc.Service(BTC_SVC).(*BTCService)
+	svc.ledgerSvc = svc.Service(LEDGER_SVC).(*LedgerService)
+	svc.mempoolSvc = svc.Service(MEMPOOL_SVC).(*MempoolService)
+	svc.syncSvc = svc.Service(CHAIN_SYNC_SVC).(*ChainSyncService)
+	svc.streamSvc = svc.Service(STREAM_SVC).(*StreamService)
+	svc.webhookSvc = svc.Service(WEBHOOK_SVC).(*WebhookService)
+
+	return svc.router().Run(fmt.Sprintf(":%v", svc.Port))
+}
+
+// router registers the API routes
+func (svc *HttpService) router() *gin.Engine {
 	r := gin.Default()
 
-	r.Use(gin.Recovery())
+	r.Use(gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		svc.abort(c, fmt.Errorf("panic: %v", recovered))
+	}))
 
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
@@ -63,18 +90,80 @@ func (svc *HttpService) Start() error {
 
 	btcG := r.Group("/btc")
 	btcG.GET("/blocks", svc.btcBlocks)
+	btcG.GET("/sync", svc.btcSync)
 
 	runeG := r.Group("/rune")
 	runeG.GET("/mempool", svc.runeMempool)
 	runeG.GET("/blocks/:id", svc.runeBlock)
 	runeG.GET("/tx/:id", svc.runeTransaction)
 	runeG.GET("/address/:id", svc.runeBalance)
+	runeG.GET("/address/:id/outputs", svc.runeAddressOutputs)
+	runeG.GET("/outputs/:outpoint", svc.runeOutput)
+	runeG.POST("/outputs", svc.runeOutputs)
+	runeG.GET("/runes", svc.runeList)
+	runeG.GET("/runes/:id", svc.runeDetail)
+	runeG.GET("/runes/:id/holders", svc.runeHolders)
+	runeG.GET("/events", svc.runeEvents)
+	runeG.GET("/stream", svc.runeStream)
+	runeG.GET("/ws", svc.runeWS)
+
+	// Webhooks make the indexer post to any URL, so they are only managed behind a key
+	if svc.webhookSvc.APIKey() != "" {
+		hookG := r.Group("/webhooks", svc.webhookAuth)
+		hookG.POST("", svc.webhookCreate)
+		hookG.GET("", svc.webhookList)
+		hookG.GET("/:id", svc.webhookDetail)
+		hookG.DELETE("/:id", svc.webhookDelete)
+		hookG.GET("/:id/deliveries", svc.webhookDeliveries)
+	} else {
+		log.Println("WEBHOOK_API_KEY not set, the /webhooks routes are disabled")
+	}
 
 	r.NoRoute(func(c *gin.Context) {
-		c.JSON(404, gin.H{"code": "PAGE_NOT_FOUND", "message": "Page not found"})
+		svc.abort(c, &APIError{Code: CodeNotFound, Message: "page not found"})
 	})
 
-	return r.Run(fmt.Sprintf(":%v", svc.Port))
+	return r
+}
+
+// abort ends the request with the error envelope of err
+func (svc *HttpService) abort(c *gin.Context, err error) {
+	apiErr := svc.apiError(c, err)
+	c.AbortWithStatusJSON(apiErr.Status(), apiErr)
+}
+
+// apiError classifies err for the response to c. Internal errors are logged rather than returned,
+// index behind errors detail the indexed tip.
+func (svc *HttpService) apiError(c *gin.Context, err error) *APIError {
+	apiErr := toAPIError(err)
+	switch apiErr.Code {
+	case CodeInternal:
+		log.Printf("HTTP %s %s: %s", c.Request.Method, c.Request.URL.Path, err)
+	case CodeIndexBehind:
+		tip, err := svc.ledgerSvc.Tip()
+		if err == nil && tip != nil && apiErr.Details == nil {
+			apiErr.Details = gin.H{"height": tip.Height, "blockHash": tip.Hash}
+		}
+	}
+	return apiErr
+}
+
+// afterParam reads the "after" query, the ID a page starts after
+func afterParam(c *gin.Context) (uint64, error) {
+	after, err := strconv.ParseUint(c.DefaultQuery("after", "0"), 10, 64)
+	if err != nil {
+		return 0, invalidInput(fmt.Errorf("invalid after %q", c.Query("after")))
+	}
+	return after, nil
+}
+
+// webhookID reads the ":id" of a webhook route
+func webhookID(c *gin.Context) (uint64, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, invalidInput(fmt.Errorf("invalid webhook id %q", c.Param("id")))
+	}
+	return id, nil
 }
 
 type Pong struct {
@@ -93,7 +182,7 @@ func (svc *HttpService) ping(c *gin.Context) {
 func (svc *HttpService) btcBlocks(c *gin.Context) {
 	resp, err := svc.btcSvc.RecentBlocks()
 	if err != nil {
-		c.AbortWithStatusJSON(400, err)
+		svc.abort(c, err)
 		return
 	}
 
@@ -105,6 +194,11 @@ func (svc *HttpService) btcBlocks(c *gin.Context) {
 	c.JSON(200, hashes)
 }
 
+// btcSync reports indexing throughput and where its time goes
+func (svc *HttpService) btcSync(c *gin.Context) {
+	c.JSON(200, svc.syncSvc.Metrics())
+}
+
 type BlockHeader struct {
 	Version          int32     `json:"version"`
 	PrevBlock        string    `json:"prevBlock"`
@@ -131,7 +225,7 @@ type Block struct {
 func (svc *HttpService) runeBlock(c *gin.Context) {
 	block, transactions, err := svc.runeSvc.BlockTransactions(c.Param("id"))
 	if err != nil {
-		c.AbortWithStatusJSON(400, err)
+		svc.abort(c, err)
 		return
 	}
 
@@ -154,7 +248,7 @@ type Txn struct {
 func (svc *HttpService) runeTransaction(c *gin.Context) {
 	tx, resp, err := svc.runeSvc.Transaction(c.Param("id"))
 	if err != nil {
-		c.AbortWithStatusJSON(400, err)
+		svc.abort(c, err)
 		return
 	}
 	c.JSON(200, &Txn{
@@ -163,15 +257,465 @@ func (svc *HttpService) runeTransaction(c *gin.Context) {
 	})
 }
 
+// runeBalance lists the confirmed rune balances of an address and the outputs holding them
 func (svc *HttpService) runeBalance(c *gin.Context) {
 	resp, err := svc.runeSvc.Balance(c.Param("id"))
 	if err != nil {
-		c.AbortWithStatusJSON(400, err)
+		svc.abort(c, err)
+		return
+	}
+	c.JSON(200, resp)
+}
+
+// runeAddressOutputs lists the outputs of an address that hold runes
+func (svc *HttpService) runeAddressOutputs(c *gin.Context) {
+	resp, err := svc.runeSvc.AddressOutputs(c.Param("id"))
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+	c.JSON(200, resp)
+}
+
+// runeOutput reports the runes held by a single TXID:VOUT outpoint
+func (svc *HttpService) runeOutput(c *gin.Context) {
+	op, err := btc_rune.ParseOutpoint(c.Param("outpoint"))
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+
+	resp, err := svc.runeSvc.Outputs([]wire.OutPoint{op})
+	if err != nil {
+		svc.abort(c, err)
 		return
 	}
 	c.JSON(200, resp)
 }
 
+// Max outpoints per bulk outputs request
+const outputsLimit = 1000
+
+type OutputsRequest struct {
+	Outpoints []string `json:"outpoints"`
+}
+
+// runeOutputs reports the runes held by each TXID:VOUT outpoint in the request body
+func (svc *HttpService) runeOutputs(c *gin.Context) {
+	var req OutputsRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		svc.abort(c, invalidInput(err))
+		return
+	}
+	if len(req.Outpoints) > outputsLimit {
+		svc.abort(c, invalidInput(fmt.Errorf("at most %d outpoints per request", outputsLimit)))
+		return
+	}
+
+	outpoints := make([]wire.OutPoint, len(req.Outpoints))
+	for i, s := range req.Outpoints {
+		outpoints[i], err = btc_rune.ParseOutpoint(s)
+		if err != nil {
+			svc.abort(c, fmt.Errorf("%w: %s", err, s))
+			return
+		}
+	}
+
+	resp, err := svc.runeSvc.Outputs(outpoints)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+	c.JSON(200, resp)
+}
+
+// Max runes or holders per directory page
+const directoryLimit = 100
+
+// page reads the "offset" and "limit" query of a directory page, limit defaults to and is capped at directoryLimit
+func page(c *gin.Context) (offset, limit int, err error) {
+	offset, err = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		return 0, 0, invalidInput(fmt.Errorf("invalid offset %q", c.Query("offset")))
+	}
+	limit, err = strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(directoryLimit)))
+	if err != nil || limit <= 0 {
+		return 0, 0, invalidInput(fmt.Errorf("invalid limit %q", c.Query("limit")))
+	}
+	if limit > directoryLimit {
+		limit = directoryLimit
+	}
+	return offset, limit, nil
+}
+
+// runeList pages through the registered runes.
+// "sort" is height, holders or transfers, "order" asc or desc (default), "prefix" filters by symbol.
+func (svc *HttpService) runeList(c *gin.Context) {
+	offset, limit, err := page(c)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+
+	q := storage.RuneQuery{
+		Prefix: strings.ToUpper(c.Query("prefix")),
+		Sort:   storage.RuneSort(c.DefaultQuery("sort", string(storage.SortHeight))),
+		Offset: offset,
+		Limit:  limit,
+	}
+	switch q.Sort {
+	case storage.SortHeight, storage.SortHolders, storage.SortTransfers:
+	default:
+		svc.abort(c, invalidInput(fmt.Errorf("invalid sort %q", q.Sort)))
+		return
+	}
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		svc.abort(c, invalidInput(fmt.Errorf("invalid order %q", c.Query("order"))))
+		return
+	}
+
+	resp, err := svc.runeSvc.Runes(q)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+	c.JSON(200, resp)
+}
+
+// runeDetail describes a rune by BLOCK:TX id or symbol
+func (svc *HttpService) runeDetail(c *gin.Context) {
+	resp, err := svc.runeSvc.Rune(c.Param("id"))
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+	c.JSON(200, resp)
+}
+
+// runeHolders lists the addresses holding a rune, largest first
+func (svc *HttpService) runeHolders(c *gin.Context) {
+	offset, limit, err := page(c)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+
+	resp, err := svc.runeSvc.Holders(c.Param("id"), offset, limit)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+	c.JSON(200, resp)
+}
+
+type Mempool struct {
+	Transactions []*mempool.Entry          `json:"transactions"`
+	Addresses    map[string]*mempool.Delta `json:"addresses"`
+}
+
+// runeMempool lists pending rune transactions and the unconfirmed deltas per address.
+// The "address" query narrows both to a single address.
 func (svc *HttpService) runeMempool(c *gin.Context) {
-	c.JSON(200, Pong{"pong"})
+	deltas, err := svc.mempoolSvc.Deltas()
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+
+	entries := svc.mempoolSvc.Entries()
+	if address := c.Query("address"); address != "" {
+		filtered := entries[:0]
+		for _, e := range entries {
+			if e.Involves(address) {
+				filtered = append(filtered, e)
+			}
+		}
+		entries = filtered
+
+		delta, ok := deltas[address]
+		deltas = map[string]*mempool.Delta{}
+		if ok {
+			deltas[address] = delta
+		}
+	}
+
+	c.JSON(200, &Mempool{
+		Transactions: entries,
+		Addresses:    deltas,
+	})
+}
+
+// Max events returned per request
+const eventsLimit = 100
+
+// runeEvents lists index events after the "after" event id, optionally filtered by "type"
+func (svc *HttpService) runeEvents(c *gin.Context) {
+	after, err := afterParam(c)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+	eventType := btc_rune.EventType(c.Query("type"))
+	if eventType != "" && !eventType.Valid() {
+		svc.abort(c, invalidInput(fmt.Errorf("unknown event type %q", eventType)))
+		return
+	}
+
+	resp, err := svc.ledgerSvc.Events(after, eventType, eventsLimit)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+	c.JSON(200, resp)
+}
+
+// wsWriteTimeout bounds every write to a websocket subscriber
+const wsWriteTimeout = 10 * time.Second
+
+// The CORS config already allows every origin
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// streamRequest reads the filter and resume token of a stream request.
+// "rune", "address" and "type" may repeat or hold comma separated lists, "from" is the token to resume after.
+func (svc *HttpService) streamRequest(c *gin.Context, from string) (*stream.Token, *stream.Filter, error) {
+	var runes []btc_rune.RuneID
+	for _, s := range queryList(c, "rune") {
+		id, err := btc_rune.ParseRuneID(s)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", s, err)
+		}
+		runes = append(runes, id)
+	}
+	types, err := eventTypes(queryList(c, "type"))
+	if err != nil {
+		return nil, nil, err
+	}
+	filter := stream.NewFilter(runes, queryList(c, "address"), types)
+
+	if v := c.Query("from"); v != "" {
+		from = v
+	}
+	if from == "" {
+		return nil, filter, nil
+	}
+
+	token, err := stream.ParseToken(from)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = svc.streamSvc.CheckToken(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &token, filter, nil
+}
+
+func eventTypes(values []string) ([]btc_rune.EventType, error) {
+	types := make([]btc_rune.EventType, 0, len(values))
+	for _, v := range values {
+		t := btc_rune.EventType(v)
+		if !t.Valid() {
+			return nil, invalidInput(fmt.Errorf("unknown event type %q", v))
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+func queryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, v := range c.QueryArray(key) {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+// runeStream streams index and mempool events as server sent events.
+// Each stored event's SSE id is its resume token, so reconnecting with Last-Event-ID resumes without gaps.
+func (svc *HttpService) runeStream(c *gin.Context) {
+	from, filter, err := svc.streamRequest(c, c.GetHeader("Last-Event-ID"))
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(200)
+	c.Writer.Flush()
+
+	err = svc.streamSvc.Follow(c.Request.Context().Done(), from, filter, func(m *Message) error {
+		var err error
+		if m == nil {
+			_, err = c.Writer.WriteString(": keep-alive\n\n")
+		} else {
+			err = sse.Encode(c.Writer, sse.Event{Id: m.Token, Event: string(m.Type), Data: m})
+		}
+		if err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil {
+		_ = sse.Encode(c.Writer, sse.Event{Event: "error", Data: svc.apiError(c, err)})
+		c.Writer.Flush()
+	}
+}
+
+// runeWS streams index and mempool events over a websocket, one JSON message per event.
+// Messages sent by the client are ignored, closing the socket ends the stream.
+func (svc *HttpService) runeWS(c *gin.Context) {
+	from, filter, err := svc.streamRequest(c, "")
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade has already replied
+		return
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	err = svc.streamSvc.Follow(done, from, filter, func(m *Message) error {
+		deadline := time.Now().Add(wsWriteTimeout)
+		if m == nil {
+			return conn.WriteControl(websocket.PingMessage, nil, deadline)
+		}
+		err := conn.SetWriteDeadline(deadline)
+		if err != nil {
+			return err
+		}
+		return conn.WriteJSON(m)
+	})
+
+	code, reason := websocket.CloseNormalClosure, ""
+	if err != nil {
+		code, reason = websocket.CloseGoingAway, err.Error()
+	}
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteTimeout))
+}
+
+// webhookAuth requires "Authorization: Bearer <WEBHOOK_API_KEY>"
+func (svc *HttpService) webhookAuth(c *gin.Context) {
+	key := svc.webhookSvc.APIKey()
+	given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || key == "" || subtle.ConstantTimeCompare([]byte(given), []byte(key)) != 1 {
+		svc.abort(c, ErrUnauthorized)
+	}
+}
+
+// webhookCreate registers a webhook, the response is the only time its secret is returned
+func (svc *HttpService) webhookCreate(c *gin.Context) {
+	var req WebhookRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		svc.abort(c, invalidInput(err))
+		return
+	}
+
+	resp, err := svc.webhookSvc.Register(&req)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+	c.JSON(201, resp)
+}
+
+func (svc *HttpService) webhookList(c *gin.Context) {
+	resp, err := svc.webhookSvc.Webhooks()
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+	c.JSON(200, resp)
+}
+
+func (svc *HttpService) webhookDetail(c *gin.Context) {
+	id, err := webhookID(c)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+
+	resp, err := svc.webhookSvc.Webhook(id)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+	c.JSON(200, resp)
+}
+
+func (svc *HttpService) webhookDelete(c *gin.Context) {
+	id, err := webhookID(c)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+
+	err = svc.webhookSvc.Delete(id)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+	c.Data(200, "application/json", []byte(DeleteResponseOK))
+}
+
+// webhookDeliveries pages through a webhook's deliveries after the "after" delivery id, optionally filtered by "status"
+func (svc *HttpService) webhookDeliveries(c *gin.Context) {
+	id, err := webhookID(c)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+	after, err := afterParam(c)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+	_, limit, err := page(c)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+
+	status := btc_rune.DeliveryStatus(c.Query("status"))
+	switch status {
+	case "", btc_rune.DeliveryPending, btc_rune.DeliveryDelivered, btc_rune.DeliveryFailed:
+	default:
+		svc.abort(c, invalidInput(fmt.Errorf("unknown delivery status %q", status)))
+		return
+	}
+
+	resp, err := svc.webhookSvc.Deliveries(id, status, after, limit)
+	if err != nil {
+		svc.abort(c, err)
+		return
+	}
+	c.JSON(200, resp)
 }
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"github.com/alphabatem/btc_rune/db"
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestHttpErrorEnvelope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	store, err := db.NewBoltStore(filepath.Join(t.TempDir(), "rune.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	svc := &HttpService{
+		runeSvc:    &RuneService{registry: &RegistryService{store: store}},
+		ledgerSvc:  &LedgerService{store: store},
+		webhookSvc: &WebhookService{},
+	}
+	r := svc.router()
+
+	for path, c := range map[string]struct {
+		status int
+		body   string
+	}{
+		"/rune/runes/840000:1": {404, `{"code":"NOT_FOUND","message":"not found","details":null}`},
+		"/rune/outputs/abc:x":  {400, `{"code":"INVALID_INPUT","message":"outpoint must be TXID:VOUT","details":null}`},
+		"/rune/no/such/route":  {404, `{"code":"NOT_FOUND","message":"page not found","details":null}`},
+		"/webhooks":            {404, `{"code":"NOT_FOUND","message":"page not found","details":null}`},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != c.status || w.Body.String() != c.body {
+			t.Fatalf("%s: Expected %d %s - Got %d %s", path, c.status, c.body, w.Code, w.Body)
+		}
+	}
+
+	// With a key the webhook routes are served, to its bearer only
+	svc.webhookSvc.apiKey = "key"
+	w := httptest.NewRecorder()
+	svc.router().ServeHTTP(w, httptest.NewRequest("GET", "/webhooks", nil))
+	if w.Code != 401 || w.Body.String() != `{"code":"UNAUTHORIZED","message":"unauthorized","details":null}` {
+		t.Fatalf("Expected 401 - Got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,572 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/db"
+	"github.com/alphabatem/btc_rune/ledger"
+	"github.com/alphabatem/btc_rune/pipeline"
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/cloakd/common/services"
+	"log"
+)
+
+type LedgerService struct {
+	services.DefaultService
+
+	store  storage.Store
+	stream *StreamService
+
+	params *chaincfg.Params
+}
+
+const LEDGER_SVC = "ledger_svc"
+
+// UndoDepth is how many blocks below the tip keep their undo journal
+const UndoDepth = 288
+
+// ErrNotConnected is returned for a block that does not extend the indexed tip
+var ErrNotConnected = errors.New("block does not extend the indexed tip")
+
+// ErrUndoUnavailable is returned when a rollback reaches past the undo journal
+var ErrUndoUnavailable = errors.New("undo journal unavailable")
+
+// ErrCorruptIndex is returned at startup when the indexed tip disagrees with the rest of the index
+var ErrCorruptIndex = errors.New("corrupt index")
+
+func (svc LedgerService) Id() string {
+	return LEDGER_SVC
+}
+
+func (svc *LedgerService) Start() error {
+	svc.store = svc.Service(db.STORAGE_SVC).(db.StorageService).Store()
+	svc.stream = svc.Service(STREAM_SVC).(*StreamService)
+	svc.params = &chaincfg.MainNetParams
+
+	return svc.Verify()
+}
+
+// Verify checks the indexed tip, the cursor syncing resumes from. It must extend the block
+// stored below it, and no undo journal may exist above it as a partially written block would leave.
+func (svc *LedgerService) Verify() error {
+	tip, err := svc.store.Tip()
+	if err != nil || tip == nil {
+		return err
+	}
+
+	parent, err := svc.store.Block(tip.Height - 1)
+	if err != nil {
+		return err
+	}
+	if parent != nil && parent.Hash != tip.PrevHash {
+		return fmt.Errorf("%w: tip %s at %d has parent %s, stored block below is %s", ErrCorruptIndex, tip.Hash, tip.Height, tip.PrevHash, parent.Hash)
+	}
+
+	records, err := svc.store.Undo(tip.Height + 1)
+	if err != nil {
+		return err
+	}
+	if len(records) > 0 {
+		return fmt.Errorf("%w: %d undo records above tip %s at %d", ErrCorruptIndex, len(records), tip.Hash, tip.Height)
+	}
+
+	log.Printf("Indexed tip: %s at %d", tip.Hash, tip.Height)
+	return nil
+}
+
+// ApplyBlock debits the outpoints spent in block and credits its outputs
+// per the decoded rune scripts, all in a single database transaction.
+// block must extend the indexed tip, its undo journal is stored alongside.
+// The balances are written as one batch once the whole block is allocated.
+func (svc *LedgerService) ApplyBlock(height int64, block *wire.MsgBlock) error {
+	runes, err := pipeline.Decode(block)
+	if err != nil {
+		return err
+	}
+	return svc.ApplyDecoded(height, block, runes)
+}
+
+// ApplyDecoded is ApplyBlock for a block whose rune scripts are already decoded, one per transaction
+func (svc *LedgerService) ApplyDecoded(height int64, block *wire.MsgBlock, runes []*btc_rune.Transaction) error {
+	if len(runes) != len(block.Transactions) {
+		return fmt.Errorf("%d rune scripts decoded for %d transactions", len(runes), len(block.Transactions))
+	}
+
+	var events []*btc_rune.Event
+	err := svc.store.Atomic(func(dbTx storage.Tx) error {
+		events = nil
+		tip, err := dbTx.Tip()
+		if err != nil {
+			return err
+		}
+
+		hash := block.BlockHash().String()
+		prevHash := block.Header.PrevBlock.String()
+		if tip != nil && (height != tip.Height+1 || prevHash != tip.Hash) {
+			return fmt.Errorf("%w: block %s at %d has parent %s, tip is %s at %d", ErrNotConnected, hash, height, prevHash, tip.Hash, tip.Height)
+		}
+
+		batch := ledger.NewBatch(height)
+		for i, msg := range block.Transactions {
+			position := btc_rune.RuneID{Block: uint64(height), Tx: uint32(i)}
+			txEvents, err := svc.applyTransaction(dbTx, batch, height, position, msg, runes[i])
+			if err != nil {
+				return err
+			}
+			events = append(events, txEvents...)
+		}
+		for _, e := range events {
+			e.Height = height
+			e.BlockHash = hash
+		}
+		events = append(events, &btc_rune.Event{Type: btc_rune.EventBlockConnected, Height: height, BlockHash: hash})
+
+		stats, holdings, err := svc.blockStats(dbTx, batch, height)
+		if err != nil {
+			return err
+		}
+
+		undo, err := batch.Undo()
+		if err != nil {
+			return err
+		}
+
+		// Reorgs deeper than UndoDepth are not expected, their journal is dropped
+		return storage.WriteBlock(dbTx, &storage.BlockWrite{
+			Block:     &btc_rune.Block{Height: height, Hash: hash, PrevHash: prevHash},
+			Spent:     batch.Spent(),
+			Credits:   batch.Credits(),
+			Stats:     stats,
+			Holdings:  holdings,
+			Undo:      undo,
+			Events:    events,
+			UndoDepth: UndoDepth,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	svc.stream.Publish(events...)
+	return nil
+}
+
+// blockStats returns the rune stats and holdings as they are after the block in batch,
+// journaling their previous values so a rollback restores them
+func (svc *LedgerService) blockStats(dbTx storage.Tx, batch *ledger.Batch, height int64) ([]*btc_rune.RuneStats, []*btc_rune.Holding, error) {
+	changes, err := batch.Holdings()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// holders is the change in the number of addresses holding each rune
+	holders := map[btc_rune.RuneID]int64{}
+	holdings := make([]*btc_rune.Holding, 0, len(changes))
+	for _, c := range changes {
+		previous, err := dbTx.Holding(c.Address, c.Rune)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = batch.HoldingChanged(&btc_rune.Holding{Address: c.Address, Rune: c.Rune, Amount: previous})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		amount, err := previous.Add(c.Credited)
+		if err == nil {
+			amount, err = amount.Sub(c.Debited)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("holding of %s by %s: %w", c.Rune, c.Address, err)
+		}
+
+		switch {
+		case previous.IsZero() && !amount.IsZero():
+			holders[c.Rune]++
+		case !previous.IsZero() && amount.IsZero():
+			holders[c.Rune]--
+		}
+		holdings = append(holdings, &btc_rune.Holding{Address: c.Address, Rune: c.Rune, Amount: amount})
+	}
+
+	activity, err := batch.Activity()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stats := make([]*btc_rune.RuneStats, 0, len(activity))
+	for _, a := range activity {
+		s, err := dbTx.RuneStats(a.Rune)
+		if err != nil {
+			return nil, nil, fmt.Errorf("stats of %s: %w", a.Rune, err)
+		}
+		previous := *s
+		err = batch.StatsChanged(&previous)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		s.Supply, err = s.Supply.Add(a.Credited)
+		if err == nil {
+			s.Supply, err = s.Supply.Sub(a.Spent)
+		}
+		if err == nil {
+			s.Burned, err = s.Burned.Add(a.Burned)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("stats of %s: %w", a.Rune, err)
+		}
+		s.Holders = uint64(int64(s.Holders) + holders[a.Rune])
+		s.Transfers += a.Transfers
+		s.LastHeight = height
+		stats = append(stats, s)
+	}
+	return stats, holdings, nil
+}
+
+// Tip returns the highest applied block, nil when nothing is indexed yet
+func (svc *LedgerService) Tip() (*btc_rune.Block, error) {
+	return svc.store.Tip()
+}
+
+// AddressBalances returns the rune balances held by outputs paying address
+// and the tip they are valid at, both read in one transaction
+func (svc *LedgerService) AddressBalances(address string) (tip *btc_rune.Block, balances []*btc_rune.RuneBalance, err error) {
+	err = svc.store.Atomic(func(dbTx storage.Tx) error {
+		tip, err = dbTx.Tip()
+		if err != nil {
+			return err
+		}
+		balances, err = dbTx.AddressBalances(address)
+		return err
+	})
+	return tip, balances, err
+}
+
+// OutpointBalances returns the rune balances held by outpoints and the tip they are valid at
+func (svc *LedgerService) OutpointBalances(outpoints []wire.OutPoint) (tip *btc_rune.Block, balances []*btc_rune.RuneBalance, err error) {
+	err = svc.store.Atomic(func(dbTx storage.Tx) error {
+		tip, err = dbTx.Tip()
+		if err != nil {
+			return err
+		}
+		balances, err = dbTx.Balances(outpoints)
+		return err
+	})
+	return tip, balances, err
+}
+
+// BlockAt returns the applied block at height, nil when there is none
+func (svc *LedgerService) BlockAt(height int64) (*btc_rune.Block, error) {
+	return svc.store.Block(height)
+}
+
+// Rollback disconnects every block above height using their undo journals
+// and records the reorg, all in a single database transaction
+func (svc *LedgerService) Rollback(height int64) error {
+	var events []*btc_rune.Event
+	err := svc.store.Atomic(func(dbTx storage.Tx) error {
+		events = nil
+		oldTip, err := dbTx.Tip()
+		if err != nil || oldTip == nil || oldTip.Height <= height {
+			return err
+		}
+
+		if oldTip.Height-height >= UndoDepth {
+			return fmt.Errorf("%w: rollback of %d blocks is past the undo depth", ErrUndoUnavailable, oldTip.Height-height)
+		}
+
+		fork, err := dbTx.Block(height)
+		if err != nil {
+			return err
+		}
+		if fork == nil {
+			return fmt.Errorf("%w: fork point %d not indexed", ErrUndoUnavailable, height)
+		}
+
+		for h := oldTip.Height; h > height; h-- {
+			disconnected, err := svc.disconnect(dbTx, h)
+			if err != nil {
+				return err
+			}
+			events = append(events, disconnected...)
+		}
+
+		data, err := json.Marshal(&btc_rune.ReorgData{
+			OldTip:    oldTip.Hash,
+			OldHeight: oldTip.Height,
+			Depth:     oldTip.Height - height,
+			ForkHash:  fork.Hash,
+		})
+		if err != nil {
+			return err
+		}
+
+		log.Printf("REORG: %d blocks from %s at %d back to %s at %d", oldTip.Height-height, oldTip.Hash, oldTip.Height, fork.Hash, height)
+		reorg := &btc_rune.Event{Type: btc_rune.EventReorg, Height: height, BlockHash: fork.Hash, Data: data}
+		events = append(events, reorg)
+		return dbTx.CreateEvent(reorg)
+	})
+	if err != nil {
+		return err
+	}
+
+	svc.stream.Publish(events...)
+	return nil
+}
+
+// disconnect reverts the block at height by replaying its undo journal backwards.
+// It returns a reverted event per rune event of the block, newest first, then the disconnect event.
+func (svc *LedgerService) disconnect(dbTx storage.Tx, height int64) ([]*btc_rune.Event, error) {
+	block, err := dbTx.Block(height)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("%w: block %d not indexed", ErrUndoUnavailable, height)
+	}
+
+	records, err := dbTx.Undo(height)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(records) - 1; i >= 0; i-- {
+		err = svc.undo(dbTx, records[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = dbTx.DeleteUndo(height)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dbTx.DeleteBlock(height)
+	if err != nil {
+		return nil, err
+	}
+
+	connected, err := dbTx.BlockEvents(height, block.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*btc_rune.Event, 0, len(connected)+1)
+	for i := len(connected) - 1; i >= 0; i-- {
+		data, err := json.Marshal(&btc_rune.RevertedData{Event: connected[i]})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, &btc_rune.Event{Type: btc_rune.EventReverted, Height: height, BlockHash: block.Hash, Data: data})
+	}
+	events = append(events, &btc_rune.Event{Type: btc_rune.EventBlockDisconnected, Height: height, BlockHash: block.Hash})
+
+	for _, e := range events {
+		err = dbTx.CreateEvent(e)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("DISCONNECT: %s at %d - %d undo records, %d events reverted", block.Hash, block.Height, len(records), len(connected))
+	return events, nil
+}
+
+func (svc *LedgerService) undo(dbTx storage.Tx, r *btc_rune.UndoRecord) error {
+	switch r.Op {
+	case btc_rune.UndoRestoreBalance:
+		b, err := r.Balance()
+		if err != nil {
+			return err
+		}
+		return dbTx.CreateBalances([]*btc_rune.RuneBalance{b})
+	case btc_rune.UndoDeleteBalance:
+		b, err := r.Balance()
+		if err != nil {
+			return err
+		}
+		return dbTx.DeleteBalances([]*btc_rune.RuneBalance{b})
+	case btc_rune.UndoDeleteRune:
+		id, err := r.RuneID()
+		if err != nil {
+			return err
+		}
+		return dbTx.DeleteRune(id)
+	case btc_rune.UndoUnmint:
+		id, err := r.RuneID()
+		if err != nil {
+			return err
+		}
+		return dbTx.AddMints(id, -1)
+	case btc_rune.UndoRestoreStats:
+		s, err := r.Stats()
+		if err != nil {
+			return err
+		}
+		return dbTx.SaveRuneStats(s)
+	case btc_rune.UndoRestoreHolding:
+		h, err := r.Holding()
+		if err != nil {
+			return err
+		}
+		return dbTx.SetHolding(h)
+	default:
+		return fmt.Errorf("unknown undo op %d", r.Op)
+	}
+}
+
+// Events returns up to limit events after id, optionally only of type
+func (svc *LedgerService) Events(after uint64, eventType btc_rune.EventType, limit int) ([]*btc_rune.Event, error) {
+	return svc.store.Events(after, eventType, limit)
+}
+
+func (svc *LedgerService) applyTransaction(dbTx storage.Tx, batch *ledger.Batch, height int64, position btc_rune.RuneID, msg *wire.MsgTx, runeTx *btc_rune.Transaction) (events []*btc_rune.Event, err error) {
+	var spent []*btc_rune.RuneBalance
+	if position.Tx > 0 { // Coinbase spends nothing
+		var outpoints []wire.OutPoint
+		spent, outpoints = batch.Take(inputOutpoints(msg))
+
+		stored, err := dbTx.Balances(outpoints)
+		if err != nil {
+			return nil, err
+		}
+		batch.Spend(stored)
+		spent = append(spent, stored...)
+	}
+
+	if len(spent) == 0 && runeTx == nil {
+		return nil, nil
+	}
+
+	inputs := ledger.Balances{}
+	for _, b := range spent {
+		err = inputs.Add(b.Rune, b.Amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for id := range inputs {
+		batch.Transferred(id)
+	}
+
+	registry := runeRegistry{tx: dbTx}
+
+	// Minted runes join the inputs, a cenotaph still counts the mint but burns it
+	var minted *btc_rune.RuneID
+	if runeTx != nil && runeTx.Mint != nil {
+		amount, ok, err := registry.mint(*runeTx.Mint, uint64(height))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			err = batch.Minted(*runeTx.Mint)
+			if err != nil {
+				return nil, err
+			}
+			batch.Touch(*runeTx.Mint)
+			minted = runeTx.Mint
+			err = inputs.Add(*runeTx.Mint, amount)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("MINT: %s - %s - %s", runeTx.Hash, runeTx.Mint, amount)
+		}
+	}
+
+	var etched *btc_rune.Rune
+	if runeTx != nil && runeTx.Issuance != nil {
+		var ok bool
+		etched, ok, err = registry.etch(position, runeTx)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			batch.Touch(etched.ID)
+			err = batch.Etched(etched.ID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			log.Printf("INVALID ISSUANCE (%s): %s - symbol %s taken", runeTx.Version, runeTx.Hash, runeTx.Issuance.Symbol)
+			runeTx.Issuance = nil
+		}
+	}
+
+	allocation, err := ledger.Allocate(position, msg, runeTx, inputs, registry)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := msg.TxHash()
+	txID := hash.String()
+	var credited []*btc_rune.RuneBalance
+	for vout, balances := range allocation.Outputs {
+		out := msg.TxOut[vout]
+		var credits []*btc_rune.RuneBalance
+		for id, amount := range balances {
+			credits = append(credits, &btc_rune.RuneBalance{
+				TxID:    txID,
+				Vout:    vout,
+				Rune:    id,
+				Amount:  amount,
+				Address: svc.address(out.PkScript),
+				Value:   out.Value,
+				Height:  height,
+			})
+		}
+		batch.Credit(wire.OutPoint{Hash: hash, Index: vout}, credits)
+		credited = append(credited, credits...)
+	}
+
+	if runeTx != nil && runeTx.Cenotaph {
+		log.Printf("CENOTAPH (%s): %s - %s", runeTx.Version, txID, runeTx.Flaws)
+	}
+	if etched != nil {
+		log.Printf("ISSUANCE (%s): %s - #%d %s", etched.Version, etched.ID, etched.Number, etched.Symbol)
+	}
+	for id, amount := range allocation.Burned {
+		err = batch.Burn(id, amount)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("BURN: %s - %s - %s", txID, id, amount)
+	}
+
+	return ledger.Events(txID, etched, minted, spent, credited, allocation.Burned)
+}
+
+// SpentBalances returns the confirmed rune balances held by the outpoints msg spends
+func (svc *LedgerService) SpentBalances(msg *wire.MsgTx) ([]*btc_rune.RuneBalance, error) {
+	return svc.spentBalances(svc.store, msg)
+}
+
+// spentBalances returns the rune balances held by the outpoints msg spends
+func (svc *LedgerService) spentBalances(dbTx storage.Tx, msg *wire.MsgTx) ([]*btc_rune.RuneBalance, error) {
+	return dbTx.Balances(inputOutpoints(msg))
+}
+
+// inputOutpoints returns the outpoints msg spends
+func inputOutpoints(msg *wire.MsgTx) []wire.OutPoint {
+	outpoints := make([]wire.OutPoint, len(msg.TxIn))
+	for i, in := range msg.TxIn {
+		outpoints[i] = in.PreviousOutPoint
+	}
+	return outpoints
+}
+
+// address returns the single address paid by script, if any
+func (svc *LedgerService) address(script []byte) string {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(script, svc.params)
+	if err != nil || len(addrs) != 1 {
+		return ""
+	}
+	return addrs[0].EncodeAddress()
+}
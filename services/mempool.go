@@ -0,0 +1,319 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/codec"
+	"github.com/alphabatem/btc_rune/ledger"
+	"github.com/alphabatem/btc_rune/mempool"
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/alphabatem/btc_rune/stream"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/cloakd/common/context"
+	"github.com/cloakd/common/services"
+	"log"
+	"os"
+	"time"
+)
+
+// MempoolService tracks the rune movements of unconfirmed transactions
+type MempoolService struct {
+	services.DefaultService
+
+	btc      *BTCService
+	rune     *RuneService
+	ledger   *LedgerService
+	registry *RegistryService
+	stream   *StreamService
+
+	pool *mempool.Pool
+
+	// pollInterval is how often the node mempool is reconciled, 0 disables polling
+	pollInterval time.Duration
+	expiry       time.Duration
+	// resync queues one reconcile on top of polling, requests while one is queued are dropped
+	resync chan struct{}
+}
+
+const MEMPOOL_SVC = "mempool_svc"
+
+func (svc MempoolService) Id() string {
+	return MEMPOOL_SVC
+}
+
+func (svc *MempoolService) Configure(ctx *context.Context) (err error) {
+	svc.pollInterval = time.Minute
+	if v := os.Getenv("MEMPOOL_POLL_INTERVAL"); v != "" {
+		svc.pollInterval, err = time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Matches bitcoind's default -mempoolexpiry
+	svc.expiry = 336 * time.Hour
+	if v := os.Getenv("MEMPOOL_EXPIRY"); v != "" {
+		svc.expiry, err = time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return svc.DefaultService.Configure(ctx)
+}
+
+func (svc *MempoolService) Start() error {
+	svc.btc = svc.Service(BTC_SVC).(*BTCService)
+	svc.rune = svc.Service(RUNE_SVC).(*RuneService)
+	svc.ledger = svc.Service(LEDGER_SVC).(*LedgerService)
+	svc.registry = svc.Service(REGISTRY_SVC).(*RegistryService)
+	svc.stream = svc.Service(STREAM_SVC).(*StreamService)
+
+	svc.pool = mempool.New()
+	svc.resync = make(chan struct{}, 1)
+
+	// Only a node has a mempool to reconcile with
+	if svc.btc.Live() {
+		go svc.listen()
+	}
+	return nil
+}
+
+// Entries returns the pending rune transactions in the order they were seen
+func (svc *MempoolService) Entries() []*mempool.Entry {
+	return svc.pool.Entries()
+}
+
+// Deltas returns the unconfirmed rune movements per address
+func (svc *MempoolService) Deltas() (map[string]*mempool.Delta, error) {
+	return svc.pool.Deltas()
+}
+
+// AddHash fetches an accepted transaction from the chain source and tracks it.
+// Transactions already looked at are not fetched again.
+func (svc *MempoolService) AddHash(hash *chainhash.Hash) error {
+	if svc.pool.Known(hash.String()) {
+		return nil
+	}
+
+	tx, err := svc.btc.Transaction(hash)
+	if err != nil {
+		return err
+	}
+	return svc.Add(tx)
+}
+
+// Add tracks msg when it carries a rune script or spends rune balances, others are ignored while pending
+func (svc *MempoolService) Add(msg *wire.MsgTx) error {
+	txid := msg.TxHash().String()
+	if svc.pool.Known(txid) {
+		return nil
+	}
+
+	runeTx, err := svc.rune.DecodeTransaction(msg)
+	if err != nil && err != codec.ErrNotRunestone {
+		return err
+	}
+
+	spent, err := svc.ledger.SpentBalances(msg)
+	if err != nil {
+		return err
+	}
+	for _, in := range msg.TxIn {
+		for _, b := range svc.pool.Outputs(in.PreviousOutPoint) {
+			// Runes etched by an unconfirmed parent have no id yet
+			if b.Rune != (btc_rune.RuneID{}) {
+				spent = append(spent, b)
+			}
+		}
+	}
+
+	if len(spent) == 0 && runeTx == nil {
+		svc.pool.Ignore(txid)
+		return nil
+	}
+
+	inputs := ledger.Balances{}
+	for _, b := range spent {
+		err = inputs.Add(b.Rune, b.Amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	registry := svc.registry.registry()
+	if runeTx != nil && runeTx.Mint != nil {
+		amount, ok, err := svc.mintable(registry, *runeTx.Mint)
+		if err != nil {
+			return err
+		}
+		if ok {
+			err = inputs.Add(*runeTx.Mint, amount)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// The etching position is unknown until confirmation, 0:0 stands in for it
+	allocation, err := ledger.Allocate(btc_rune.RuneID{}, msg, runeTx, inputs, registry)
+	if err != nil {
+		return err
+	}
+
+	entry := &mempool.Entry{
+		TxID:        txid,
+		Transaction: runeTx,
+		Seen:        time.Now(),
+		Inputs:      spent,
+		Burned:      allocation.Burned,
+	}
+	for vout, balances := range allocation.Outputs {
+		out := msg.TxOut[vout]
+		for id, amount := range balances {
+			entry.Outputs = append(entry.Outputs, &btc_rune.RuneBalance{
+				TxID:    txid,
+				Vout:    vout,
+				Rune:    id,
+				Amount:  amount,
+				Address: svc.ledger.address(out.PkScript),
+				Value:   out.Value,
+			})
+		}
+	}
+
+	if runeTx != nil {
+		// A rune the index has not reached stays unresolved, as it is while the index catches up
+		err = svc.registry.Resolve(runeTx)
+		if err != nil && !errors.Is(err, ErrIndexBehind) {
+			return err
+		}
+	}
+
+	replaced := svc.pool.Add(entry, msg)
+	for _, r := range replaced {
+		log.Printf("MEMPOOL REPLACED: %s by %s", r, txid)
+	}
+	svc.removed(replaced)
+	return svc.added(entry)
+}
+
+// Remove evicts txid and everything spending its outputs
+func (svc *MempoolService) Remove(txid string) {
+	evicted := svc.pool.Remove(txid)
+	if len(evicted) > 0 {
+		log.Printf("MEMPOOL REMOVED: %s - %d entries", txid, len(evicted))
+	}
+	svc.removed(evicted)
+}
+
+// Confirm evicts the transactions of an applied block and everything conflicting with them
+func (svc *MempoolService) Confirm(block *wire.MsgBlock) {
+	evicted := svc.pool.Confirm(block)
+	if len(evicted) > 0 {
+		log.Printf("MEMPOOL: %d entries evicted by block %s", len(evicted), block.BlockHash())
+	}
+	svc.removed(evicted)
+}
+
+// Sync reconciles the pool with the node mempool and expires stale entries
+func (svc *MempoolService) Sync() error {
+	hashes, err := svc.btc.Mempool()
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		keep[hash.String()] = true
+	}
+	svc.removed(svc.pool.Retain(keep))
+	svc.removed(svc.pool.Expire(time.Now().Add(-svc.expiry)))
+
+	for _, hash := range hashes {
+		err = svc.AddHash(hash)
+		if err != nil {
+			log.Printf("Mempool AddHash %s Err: %s", hash, err)
+		}
+	}
+	return nil
+}
+
+// added streams the addition of entry
+func (svc *MempoolService) added(entry *mempool.Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	svc.stream.Publish(&btc_rune.Event{Type: btc_rune.EventMempoolAdd, Data: data, CreatedAt: time.Now()})
+	return nil
+}
+
+// removed streams the removal of the entries of txids
+func (svc *MempoolService) removed(txids []string) {
+	events := make([]*btc_rune.Event, 0, len(txids))
+	for _, txid := range txids {
+		data, err := stream.MempoolRemoveData(txid)
+		if err != nil {
+			log.Printf("Mempool removal %s Err: %s", txid, err)
+			continue
+		}
+		events = append(events, &btc_rune.Event{Type: btc_rune.EventMempoolRemove, Data: data, CreatedAt: time.Now()})
+	}
+	svc.stream.Publish(events...)
+}
+
+// listen reconciles with the node mempool every poll interval and on every resync, one pass at a time
+func (svc *MempoolService) listen() {
+	var poll <-chan time.Time
+	if svc.pollInterval > 0 {
+		ticker := time.NewTicker(svc.pollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+		svc.sync()
+	}
+
+	for {
+		select {
+		case <-poll:
+		case <-svc.resync:
+		}
+		svc.sync()
+	}
+}
+
+// Resync reconciles with the node mempool as soon as the running pass, if any, is done
+func (svc *MempoolService) Resync() {
+	select {
+	case svc.resync <- struct{}{}:
+	default:
+	}
+}
+
+func (svc *MempoolService) sync() {
+	err := svc.Sync()
+	if err != nil {
+		log.Println("Mempool Sync Err", err)
+	}
+}
+
+// mintable returns what a mint of id would create in the next block, without counting it
+func (svc *MempoolService) mintable(registry runeRegistry, id btc_rune.RuneID) (btc_rune.Uint128, bool, error) {
+	minted, err := registry.tx.Rune(id)
+	if err == storage.ErrNotFound {
+		return btc_rune.Uint128{}, false, nil
+	}
+	if err != nil {
+		return btc_rune.Uint128{}, false, err
+	}
+
+	tip, err := svc.ledger.Tip()
+	if err != nil || tip == nil {
+		return btc_rune.Uint128{}, false, err
+	}
+
+	amount, ok := ledger.Mintable(minted, uint64(tip.Height+1))
+	return amount, ok, nil
+}
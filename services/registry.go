@@ -0,0 +1,211 @@
+package services
+
+import (
+	"fmt"
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/codec"
+	"github.com/alphabatem/btc_rune/db"
+	"github.com/alphabatem/btc_rune/ledger"
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/cloakd/common/services"
+	"log"
+)
+
+// RegistryService answers which rune an id or symbol refers to
+type RegistryService struct {
+	services.DefaultService
+
+	store storage.Store
+}
+
+const REGISTRY_SVC = "registry_svc"
+
+func (svc RegistryService) Id() string {
+	return REGISTRY_SVC
+}
+
+func (svc *RegistryService) Start() error {
+	svc.store = svc.Service(db.STORAGE_SVC).(db.StorageService).Store()
+
+	return nil
+}
+
+// Rune returns the rune issued at id
+func (svc *RegistryService) Rune(id btc_rune.RuneID) (*btc_rune.Rune, error) {
+	return svc.store.Rune(id)
+}
+
+// Symbol returns the rune registered under symbol
+func (svc *RegistryService) Symbol(symbol string) (*btc_rune.Rune, error) {
+	return svc.store.RuneBySymbol(symbol)
+}
+
+// Runes returns a page of the registered runes with their stats, and the tip they are valid at
+func (svc *RegistryService) Runes(q storage.RuneQuery) (tip *btc_rune.Block, entries []*storage.RuneEntry, err error) {
+	err = svc.store.Atomic(func(tx storage.Tx) error {
+		tip, err = tx.Tip()
+		if err != nil {
+			return err
+		}
+		entries, err = tx.Runes(q)
+		return err
+	})
+	return tip, entries, err
+}
+
+// Entry returns r and its stats, and the tip they are valid at
+func (svc *RegistryService) Entry(r *btc_rune.Rune) (tip *btc_rune.Block, entry *storage.RuneEntry, err error) {
+	err = svc.store.Atomic(func(tx storage.Tx) error {
+		tip, err = tx.Tip()
+		if err != nil {
+			return err
+		}
+		stats, err := tx.RuneStats(r.ID)
+		if err != nil {
+			return err
+		}
+		entry = &storage.RuneEntry{Rune: r, Stats: stats}
+		return nil
+	})
+	return tip, entry, err
+}
+
+// Holders returns a page of the addresses holding id, largest first, and the tip they are valid at
+func (svc *RegistryService) Holders(id btc_rune.RuneID, offset, limit int) (tip *btc_rune.Block, holdings []*btc_rune.Holding, err error) {
+	err = svc.store.Atomic(func(tx storage.Tx) error {
+		tip, err = tx.Tip()
+		if err != nil {
+			return err
+		}
+		holdings, err = tx.Holders(id, offset, limit)
+		return err
+	})
+	return tip, holdings, err
+}
+
+// Resolve attaches the registered rune ids and symbols to the issuance and transfers of tx.
+// When tx refers to a rune above the indexed tip it resolves the rest, then returns ErrIndexBehind.
+func (svc *RegistryService) Resolve(tx *btc_rune.Transaction) error {
+	r := svc.registry()
+
+	var etchedID *btc_rune.RuneID
+	etched, err := r.tx.RuneByIssuance(tx.Hash)
+	if err != nil && err != storage.ErrNotFound {
+		return err
+	}
+	if etched != nil {
+		etchedID = &etched.ID
+		tx.Issuance = etched
+	}
+
+	var behind error
+	for _, t := range tx.Transfers {
+		id, ok := ledger.Resolve(t, etchedID, tx.Version, r)
+		if !ok {
+			continue
+		}
+
+		registered, err := r.tx.Rune(id)
+		if err == storage.ErrNotFound {
+			if behind == nil {
+				behind = r.checkIndexed(id)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		t.Rune = &registered.ID
+		t.Symbol = registered.Symbol
+	}
+	return behind
+}
+
+func (svc *RegistryService) registry() runeRegistry {
+	return runeRegistry{tx: svc.store}
+}
+
+// runeRegistry applies the registration rules through a single handle,
+// the ledger binds it to the transaction of the block being applied
+type runeRegistry struct {
+	tx storage.Tx
+}
+
+// checkIndexed returns ErrIndexBehind when the unregistered rune id is above the indexed tip, so may not be etched yet
+func (r runeRegistry) checkIndexed(id btc_rune.RuneID) error {
+	tip, err := r.tx.Tip()
+	if err != nil {
+		return err
+	}
+	if tip == nil || int64(id.Block) > tip.Height {
+		return fmt.Errorf("%w: rune %s", ErrIndexBehind, id)
+	}
+	return nil
+}
+
+// LegacyRune returns the id of the legacy rune issued with number
+func (r runeRegistry) LegacyRune(number uint64) (btc_rune.RuneID, bool) {
+	registered, err := r.tx.RuneByNumber(btc_rune.ProtocolLegacy, number)
+	if err != nil {
+		if err != storage.ErrNotFound {
+			log.Printf("LegacyRune %d Err: %s", number, err)
+		}
+		return btc_rune.RuneID{}, false
+	}
+	return registered.ID, true
+}
+
+// etch registers the issuance of tx at position.
+// Unnamed issuances receive their reserved name, an issuance reusing a registered name is invalid.
+func (r runeRegistry) etch(position btc_rune.RuneID, tx *btc_rune.Transaction) (*btc_rune.Rune, bool, error) {
+	issued := *tx.Issuance
+	issued.ID = position
+	issued.Version = tx.Version
+	issued.IssuanceTx = tx.Hash
+	if issued.Symbol == "" {
+		issued.Symbol = codec.ReservedSymbol(position)
+	}
+
+	_, err := r.tx.RuneBySymbol(issued.Symbol)
+	if err == nil {
+		return nil, false, nil
+	}
+	if err != storage.ErrNotFound {
+		return nil, false, err
+	}
+
+	count, err := r.tx.CountRunes(tx.Version)
+	if err != nil {
+		return nil, false, err
+	}
+	issued.Number = count + 1
+
+	err = r.tx.CreateRune(&issued)
+	if err != nil {
+		return nil, false, err
+	}
+	return &issued, true, nil
+}
+
+// mint counts a mint of id at height against its terms and returns the amount it creates
+func (r runeRegistry) mint(id btc_rune.RuneID, height uint64) (btc_rune.Uint128, bool, error) {
+	minted, err := r.tx.Rune(id)
+	if err == storage.ErrNotFound {
+		return btc_rune.Uint128{}, false, nil
+	}
+	if err != nil {
+		return btc_rune.Uint128{}, false, err
+	}
+
+	amount, ok := ledger.Mintable(minted, height)
+	if !ok {
+		return btc_rune.Uint128{}, false, nil
+	}
+
+	err = r.tx.AddMints(id, 1)
+	if err != nil {
+		return btc_rune.Uint128{}, false, err
+	}
+	return amount, true, nil
+}
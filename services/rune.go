@@ -1,25 +1,27 @@
 package services
 
 import (
-	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/codec"
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
-	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/cloakd/common/services"
 	"log"
-	"math/big"
-	"strconv"
+	"sort"
 	"strings"
 )
 
 type RuneService struct {
 	services.DefaultService
 
-	btc *BTCService
+	btc      *BTCService
+	registry *RegistryService
+	ledger   *LedgerService
 }
 
 const RUNE_SVC = "rune_svc"
@@ -30,13 +32,15 @@ func (svc RuneService) Id() string {
 
 func (svc *RuneService) Start() error {
 	svc.btc = svc.Service(BTC_SVC).(*BTCService)
+	svc.registry = svc.Service(REGISTRY_SVC).(*RegistryService)
+	svc.ledger = svc.Service(LEDGER_SVC).(*LedgerService)
 
 	return nil
 }
 
 //TODO Complete
 
-func (svc *RuneService) Issue(signer []byte, symbol string, decimals, amount uint64) error {
+func (svc *RuneService) Issue(signer []byte, symbol string, decimals uint64, amount btc_rune.Uint128) error {
 	tx, err := svc.btc.CreateIssuanceTransaction(symbol, decimals)
 	if err != nil {
 		return err
@@ -68,200 +72,484 @@ func (svc *RuneService) Transfer(signer []byte, rune *btc_rune.Rune, assignments
 	return nil
 }
 
-func (svc *RuneService) Balance(addr string) (map[string]int64, error) {
-	balances := map[string]int64{}
+// ErrInvalidAddress is returned for an address that does not decode on mainnet
+var ErrInvalidAddress = errors.New("invalid address")
 
-	txns, err := svc.btc.httpClient.ListTransactions(addr)
+// ErrInvalidHash is returned for a block or transaction hash that is not 64 hex characters
+var ErrInvalidHash = errors.New("invalid hash")
+
+// AddressBalances is what an address holds as of the indexed tip
+type AddressBalances struct {
+	Address string `json:"address"`
+	// Height and BlockHash are the indexed tip the balances are valid at
+	Height    int64         `json:"height"`
+	BlockHash string        `json:"blockHash"`
+	Balances  []*RuneAmount `json:"balances"`
+	UTXOs     []*UTXO       `json:"utxos"`
+}
+
+// Outputs is the rune content of unspent outputs as of the indexed tip
+type Outputs struct {
+	Address string `json:"address,omitempty"`
+	// Height and BlockHash are the indexed tip the outputs are valid at
+	Height    int64   `json:"height"`
+	BlockHash string  `json:"blockHash"`
+	Outputs   []*UTXO `json:"outputs"`
+}
+
+// RuneAmount is an amount of a rune, Balance is Amount formatted with the rune's decimals
+type RuneAmount struct {
+	Rune     btc_rune.RuneID  `json:"rune"`
+	Symbol   string           `json:"symbol"`
+	Decimals uint64           `json:"decimals"`
+	Amount   btc_rune.Uint128 `json:"amount"`
+	Balance  string           `json:"balance"`
+}
+
+// UTXO is an unspent output and the runes it holds.
+// An output holding no runes, or not unspent, is reported with only its outpoint and no runes.
+type UTXO struct {
+	TxID       string        `json:"txid"`
+	Vout       uint32        `json:"vout"`
+	Address    string        `json:"address,omitempty"`
+	ScriptType string        `json:"scriptType,omitempty"`
+	Value      int64         `json:"value"`
+	Height     int64         `json:"height"`
+	Runes      []*RuneAmount `json:"runes"`
+}
+
+// Balance totals the runes held by the outputs paying addr in the confirmed index
+func (svc *RuneService) Balance(addr string) (*AddressBalances, error) {
+	address, tip, held, err := svc.addressBalances(addr)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, tx := range txns {
-		log.Println(tx.TimeReceived)
-		//h, _ := chainhash.NewHashFromStr(tx.BlockHash)
-		//ttx, err := svc.btc.Transaction(h)
-		//if err != nil {
-		//	continue
-		//}
+	resp := &AddressBalances{
+		Address:  address,
+		Balances: []*RuneAmount{},
+	}
+	if tip != nil {
+		resp.Height = tip.Height
+		resp.BlockHash = tip.Hash
+	}
+
+	runes := map[btc_rune.RuneID]*btc_rune.Rune{}
+	resp.UTXOs, err = svc.utxos(runes, held)
+	if err != nil {
+		return nil, err
+	}
 
-		//ttx.MsgTx().BtcEncode()
-		//
-		//if ok, data := svc.isRuneTransaction(ttx.MsgTx()); ok {
-		//	rtx := svc.DecodeTransaction(h, data)
-		//
-		//}
+	totals := map[btc_rune.RuneID]btc_rune.Uint128{}
+	for _, b := range held {
+		totals[b.Rune], err = totals[b.Rune].Add(b.Amount)
+		if err != nil {
+			return nil, err
+		}
+	}
 
+	for id, total := range totals {
+		amount, err := svc.runeAmount(runes, id, total)
+		if err != nil {
+			return nil, err
+		}
+		resp.Balances = append(resp.Balances, amount)
 	}
+	sort.Slice(resp.Balances, func(i, j int) bool {
+		return resp.Balances[i].Rune.Less(resp.Balances[j].Rune)
+	})
 
-	return balances, nil
+	return resp, nil
 }
 
-func (svc *RuneService) Transaction(txHash string) (*wire.MsgTx, *btc_rune.Transaction, error) {
-	hash, err := chainhash.NewHashFromStr(txHash)
+// AddressOutputs lists the unspent outputs paying addr that hold runes
+func (svc *RuneService) AddressOutputs(addr string) (*Outputs, error) {
+	address, tip, held, err := svc.addressBalances(addr)
 	if err != nil {
-		log.Println("Invalid hash")
-		return nil, nil, err
+		return nil, err
 	}
 
-	tx, err := svc.btc.Transaction(hash)
+	resp := newOutputs(tip)
+	resp.Address = address
+	resp.Outputs, err = svc.utxos(map[btc_rune.RuneID]*btc_rune.Rune{}, held)
 	if err != nil {
-		log.Println("TXN Not Found")
-		return nil, nil, err
-	}
-
-	ok, data := svc.isRuneTransaction(tx.MsgTx())
-	if !ok {
-		return nil, nil, errors.New("not a rune tx")
+		return nil, err
 	}
-
-	return tx.MsgTx(), svc.DecodeTransaction(hash, data), nil
+	return resp, nil
 }
 
-func (svc *RuneService) BlockTransactions(blockHash string) (*wire.MsgBlock, []*btc_rune.Transaction, error) {
-	hash, err := chainhash.NewHashFromStr(blockHash)
+// Outputs reports the runes held by each of outpoints, in the order requested
+func (svc *RuneService) Outputs(outpoints []wire.OutPoint) (*Outputs, error) {
+	tip, held, err := svc.ledger.OutpointBalances(outpoints)
 	if err != nil {
-		log.Println("Invalid hash")
-		return nil, nil, err
+		return nil, err
 	}
 
-	block, err := svc.btc.Block(hash)
+	utxos, err := svc.utxos(map[btc_rune.RuneID]*btc_rune.Rune{}, held)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+	byOutpoint := map[string]*UTXO{}
+	for _, u := range utxos {
+		byOutpoint[fmt.Sprintf("%s:%d", u.TxID, u.Vout)] = u
 	}
 
-	var txns []*btc_rune.Transaction
-	for _, t := range block.Transactions {
-		ok, data := svc.isRuneTransaction(t)
+	resp := newOutputs(tip)
+	for _, op := range outpoints {
+		u, ok := byOutpoint[op.String()]
 		if !ok {
-			continue
+			u = &UTXO{TxID: op.Hash.String(), Vout: op.Index, Runes: []*RuneAmount{}}
 		}
+		resp.Outputs = append(resp.Outputs, u)
+	}
+	return resp, nil
+}
 
-		txHash := t.TxHash()
-		txns = append(txns, svc.DecodeTransaction(&txHash, data))
+func newOutputs(tip *btc_rune.Block) *Outputs {
+	resp := &Outputs{Outputs: []*UTXO{}}
+	if tip != nil {
+		resp.Height = tip.Height
+		resp.BlockHash = tip.Hash
 	}
+	return resp
+}
 
-	return block, txns, nil
+// addressBalances returns the balances of addr in its canonical encoding and the tip they are valid at
+func (svc *RuneService) addressBalances(addr string) (string, *btc_rune.Block, []*btc_rune.RuneBalance, error) {
+	address, err := btcutil.DecodeAddress(addr, &chaincfg.MainNetParams)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("%w: %s", ErrInvalidAddress, err)
+	}
+
+	tip, held, err := svc.ledger.AddressBalances(address.EncodeAddress())
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return address.EncodeAddress(), tip, held, nil
 }
 
-func (svc *RuneService) isRuneTransaction(tx *wire.MsgTx) (bool, []byte) {
-	for _, txOut := range tx.TxOut {
-		pkScript := txOut.PkScript
-		if txscript.IsPayToWitnessPubKeyHash(pkScript) || txscript.IsPayToPubKeyHash(pkScript) {
-			continue
+// utxos groups balances by outpoint, ordered by confirmation height
+func (svc *RuneService) utxos(runes map[btc_rune.RuneID]*btc_rune.Rune, held []*btc_rune.RuneBalance) ([]*UTXO, error) {
+	sort.Slice(held, func(i, j int) bool {
+		if held[i].Height != held[j].Height {
+			return held[i].Height < held[j].Height
+		}
+		if held[i].TxID != held[j].TxID {
+			return held[i].TxID < held[j].TxID
+		}
+		if held[i].Vout != held[j].Vout {
+			return held[i].Vout < held[j].Vout
+		}
+		return held[i].Rune.Less(held[j].Rune)
+	})
+
+	utxos := []*UTXO{}
+	var utxo *UTXO
+	for _, b := range held {
+		if utxo == nil || utxo.TxID != b.TxID || utxo.Vout != b.Vout {
+			utxo = &UTXO{
+				TxID:       b.TxID,
+				Vout:       b.Vout,
+				Address:    b.Address,
+				ScriptType: scriptType(b.Address),
+				Value:      b.Value,
+				Height:     b.Height,
+			}
+			utxos = append(utxos, utxo)
 		}
 
-		// Check for OP_RETURN and ASCII 'R'
-		if len(pkScript) > 2 && pkScript[0] == txscript.OP_RETURN && pkScript[1] == 1 && pkScript[2] == 'R' {
-			return true, pkScript
+		amount, err := svc.runeAmount(runes, b.Rune, b.Amount)
+		if err != nil {
+			return nil, err
 		}
+		utxo.Runes = append(utxo.Runes, amount)
 	}
-	return false, nil
+	return utxos, nil
 }
 
-func (svc *RuneService) DecodeTransaction(txHash *chainhash.Hash, runeData []byte) *btc_rune.Transaction {
-	buffer := bytes.NewBuffer(runeData)
+// scriptType names the kind of output script paying address, the ledger keeps only the address
+func scriptType(address string) string {
+	if address == "" {
+		return "nonstandard"
+	}
 
-	cmd, _ := binary.ReadUvarint(buffer)
-	dataType := svc.btc.DecodeVarInt(buffer)
+	decoded, err := btcutil.DecodeAddress(address, &chaincfg.MainNetParams)
+	if err != nil {
+		return "nonstandard"
+	}
 
-	if cmd != txscript.OP_RETURN && dataType != 82 {
-		return nil //Not an OP_RETURN
+	switch decoded.(type) {
+	case *btcutil.AddressPubKeyHash:
+		return "p2pkh"
+	case *btcutil.AddressScriptHash:
+		return "p2sh"
+	case *btcutil.AddressWitnessPubKeyHash:
+		return "p2wpkh"
+	case *btcutil.AddressWitnessScriptHash:
+		return "p2wsh"
+	case *btcutil.AddressTaproot:
+		return "p2tr"
+	default:
+		return "witness_unknown"
 	}
+}
 
-	tx := btc_rune.Transaction{
-		Hash: txHash.String(),
+// runeAmount describes amount of id, runes caches the registry lookups of one request
+func (svc *RuneService) runeAmount(runes map[btc_rune.RuneID]*btc_rune.Rune, id btc_rune.RuneID, amount btc_rune.Uint128) (*RuneAmount, error) {
+	r, ok := runes[id]
+	if !ok {
+		var err error
+		r, err = svc.registry.Rune(id)
+		if err != nil {
+			return nil, fmt.Errorf("rune %s: %w", id, err)
+		}
+		runes[id] = r
 	}
 
-	i := 0
-	for buffer.Len() > 0 {
-		size, _ := binary.ReadUvarint(buffer)
-		msg := make([]byte, size)
-		_, _ = buffer.Read(msg)
+	return &RuneAmount{
+		Rune:     id,
+		Symbol:   r.Symbol,
+		Decimals: r.Decimals,
+		Amount:   amount,
+		Balance:  amount.Decimal(r.Decimals),
+	}, nil
+}
 
-		if i == 0 { //Transfer
-			tx.Transfers = svc.decodeTransfer(msg)
-		} else { //Issuance
-			tx.Issuance = svc.decodeIssuance(msg)
-		}
+// RuneInfo is a registered rune and its activity, amounts are formatted with its decimals
+type RuneInfo struct {
+	ID             btc_rune.RuneID   `json:"id"`
+	Number         uint64            `json:"number"`
+	Version        btc_rune.Protocol `json:"version"`
+	Symbol         string            `json:"symbol"`
+	Spacers        uint32            `json:"spacers,omitempty"`
+	CurrencySymbol string            `json:"currencySymbol,omitempty"`
+	IssuanceTx     string            `json:"issuanceTx"`
+	IssuanceHeight uint64            `json:"issuanceHeight"`
+	Decimals       uint64            `json:"decimals"`
+	Mints          uint64            `json:"mints"`
+	Premine        string            `json:"premine"`
+	Terms          *btc_rune.Terms   `json:"terms,omitempty"`
+	// Supply is the circulating supply, held by unspent outputs
+	Supply    string `json:"supply"`
+	Burned    string `json:"burned"`
+	Holders   uint64 `json:"holders"`
+	Transfers uint64 `json:"transfers"`
+	// LastHeight is the height of the last block that moved the rune
+	LastHeight int64 `json:"lastHeight"`
+}
 
-		i++
-	}
-	return &tx
+// RuneList is a page of the rune directory as of the indexed tip
+type RuneList struct {
+	Height    int64       `json:"height"`
+	BlockHash string      `json:"blockHash"`
+	Offset    int         `json:"offset"`
+	Limit     int         `json:"limit"`
+	Runes     []*RuneInfo `json:"runes"`
+}
+
+// RuneDetail is a rune as of the indexed tip
+type RuneDetail struct {
+	Height    int64  `json:"height"`
+	BlockHash string `json:"blockHash"`
+	*RuneInfo
+}
+
+// HolderList is a page of the addresses holding a rune, largest first
+type HolderList struct {
+	Rune      btc_rune.RuneID `json:"rune"`
+	Symbol    string          `json:"symbol"`
+	Decimals  uint64          `json:"decimals"`
+	Height    int64           `json:"height"`
+	BlockHash string          `json:"blockHash"`
+	Offset    int             `json:"offset"`
+	Limit     int             `json:"limit"`
+	Holders   []*Holder       `json:"holders"`
 }
 
-func (svc *RuneService) decodeIssuance(data []byte) *btc_rune.Rune {
-	log.Println("Issuance", data)
-	buffer := bytes.NewBuffer(data)
+// Holder is what an address holds of a rune, Balance is Amount formatted with the rune's decimals
+type Holder struct {
+	Address string           `json:"address"`
+	Amount  btc_rune.Uint128 `json:"amount"`
+	Balance string           `json:"balance"`
+}
 
-	symbol := svc.btc.DecodeVarByte(buffer)
-	//log.Println("BYT", symbol)
+// Runes lists a page of the registered runes. A symbol prefix must be A-Z.
+func (svc *RuneService) Runes(q storage.RuneQuery) (*RuneList, error) {
+	if q.Prefix != "" {
+		_, err := codec.EncodeSymbol(q.Prefix)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	decimals := svc.btc.DecodeVarInt(buffer)
-	//log.Println("decimals", decimals)
+	tip, entries, err := svc.registry.Runes(q)
+	if err != nil {
+		return nil, err
+	}
 
-	return &btc_rune.Rune{
-		Symbol:   svc.IntToBase26(symbol),
-		Decimals: decimals,
+	resp := &RuneList{
+		Offset: q.Offset,
+		Limit:  q.Limit,
+		Runes:  make([]*RuneInfo, len(entries)),
+	}
+	if tip != nil {
+		resp.Height = tip.Height
+		resp.BlockHash = tip.Hash
 	}
+	for i, e := range entries {
+		resp.Runes[i] = newRuneInfo(e)
+	}
+	return resp, nil
 }
 
-func (svc *RuneService) decodeTransfer(data []byte) []*btc_rune.Assignment {
-	buffer := bytes.NewBuffer(data)
-	var assignments []*btc_rune.Assignment
+// Rune describes the rune with the BLOCK:TX id or symbol idOrSymbol
+func (svc *RuneService) Rune(idOrSymbol string) (*RuneDetail, error) {
+	r, err := svc.lookup(idOrSymbol)
+	if err != nil {
+		return nil, err
+	}
 
-	for buffer.Len() >= 9 {
-		assignments = append(assignments, &btc_rune.Assignment{
-			ID:     svc.btc.DecodeVarInt(buffer),
-			Output: svc.btc.DecodeVarInt(buffer),
-			Amount: svc.btc.DecodeVarInt(buffer),
-		})
+	tip, entry, err := svc.registry.Entry(r)
+	if err != nil {
+		return nil, err
 	}
 
-	return assignments
+	resp := &RuneDetail{RuneInfo: newRuneInfo(entry)}
+	if tip != nil {
+		resp.Height = tip.Height
+		resp.BlockHash = tip.Hash
+	}
+	return resp, nil
 }
 
-func (svc *RuneService) hexToBase26(hexStr string) string {
-	hexNum := new(big.Int)
-	hexNum.SetString(hexStr, 16)
-	var base26StrBuilder strings.Builder
+// Holders lists a page of the addresses holding the rune with the BLOCK:TX id or symbol idOrSymbol
+func (svc *RuneService) Holders(idOrSymbol string, offset, limit int) (*HolderList, error) {
+	r, err := svc.lookup(idOrSymbol)
+	if err != nil {
+		return nil, err
+	}
+
+	tip, holdings, err := svc.registry.Holders(r.ID, offset, limit)
+	if err != nil {
+		return nil, err
+	}
 
-	base26Chars := "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	resp := &HolderList{
+		Rune:     r.ID,
+		Symbol:   r.Symbol,
+		Decimals: r.Decimals,
+		Offset:   offset,
+		Limit:    limit,
+		Holders:  make([]*Holder, len(holdings)),
+	}
+	if tip != nil {
+		resp.Height = tip.Height
+		resp.BlockHash = tip.Hash
+	}
+	for i, h := range holdings {
+		resp.Holders[i] = &Holder{
+			Address: h.Address,
+			Amount:  h.Amount,
+			Balance: h.Amount.Decimal(r.Decimals),
+		}
+	}
+	return resp, nil
+}
 
-	for hexNum.Cmp(big.NewInt(0)) > 0 {
-		remainder := new(big.Int)
-		remainder.Mod(hexNum, big.NewInt(26))
-		hexNum.Div(hexNum, big.NewInt(26))
-		base26StrBuilder.WriteByte(base26Chars[remainder.Int64()])
+// lookup resolves a BLOCK:TX rune id, or failing that a symbol
+func (svc *RuneService) lookup(idOrSymbol string) (*btc_rune.Rune, error) {
+	id, err := btc_rune.ParseRuneID(idOrSymbol)
+	if err == nil {
+		return svc.registry.Rune(id)
 	}
+	return svc.registry.Symbol(strings.ToUpper(idOrSymbol))
+}
 
-	return base26StrBuilder.String()
+func newRuneInfo(e *storage.RuneEntry) *RuneInfo {
+	r, s := e.Rune, e.Stats
+	return &RuneInfo{
+		ID:             r.ID,
+		Number:         r.Number,
+		Version:        r.Version,
+		Symbol:         r.Symbol,
+		Spacers:        r.Spacers,
+		CurrencySymbol: r.CurrencySymbol,
+		IssuanceTx:     r.IssuanceTx,
+		IssuanceHeight: r.ID.Block,
+		Decimals:       r.Decimals,
+		Mints:          r.Mints,
+		Premine:        r.Premine.Decimal(r.Decimals),
+		Terms:          r.Terms,
+		Supply:         s.Supply.Decimal(r.Decimals),
+		Burned:         s.Burned.Decimal(r.Decimals),
+		Holders:        s.Holders,
+		Transfers:      s.Transfers,
+		LastHeight:     s.LastHeight,
+	}
 }
 
-func (svc *RuneService) IntToBase26(txt []byte) string {
-	base26Chars := "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+func (svc *RuneService) Transaction(txHash string) (*wire.MsgTx, *btc_rune.Transaction, error) {
+	hash, err := parseHash(txHash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := svc.btc.Transaction(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	runeTx, err := svc.DecodeTransaction(tx)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	if len(txt) < 4 {
-		txt = append(txt, 0, 0, 0, 0)
+	err = svc.registry.Resolve(runeTx)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	sym := fmt.Sprintf("%v", binary.LittleEndian.Uint32(txt))
-	if len(sym) < 2 {
-		return ""
+	return tx, runeTx, nil
+}
+
+func (svc *RuneService) BlockTransactions(blockHash string) (*wire.MsgBlock, []*btc_rune.Transaction, error) {
+	hash, err := parseHash(blockHash)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if len(sym)%2 != 0 {
-		log.Println("IntToBase26 - Invalid Char Amount", sym, txt)
-		return ""
+	block, err := svc.btc.Block(hash)
+	if err != nil {
+		return nil, nil, err
 	}
-	log.Println("IntToBase26", sym, txt)
-	var text strings.Builder
-	for i := 0; i < len(sym); i += 2 {
-		n, _ := strconv.Atoi(sym[i : i+2])
-		if n > 26 {
-			log.Println("Skipping", n)
+
+	var txns []*btc_rune.Transaction
+	for _, t := range block.Transactions {
+		runeTx, err := svc.DecodeTransaction(t)
+		if err != nil {
 			continue
 		}
-		text.WriteByte(base26Chars[n])
+
+		err = svc.registry.Resolve(runeTx)
+		if err != nil {
+			return nil, nil, err
+		}
+		txns = append(txns, runeTx)
+	}
+
+	return block, txns, nil
+}
+
+func parseHash(s string) (*chainhash.Hash, error) {
+	hash, err := chainhash.NewHashFromStr(s)
+	if err != nil || len(s) != 2*chainhash.HashSize {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHash, s)
 	}
-	return text.String()
+	return hash, nil
+}
+
+// DecodeTransaction decodes the rune script carried by tx.
+// Malformed scripts decode to a cenotaph, the only error is codec.ErrNotRunestone.
+func (svc *RuneService) DecodeTransaction(tx *wire.MsgTx) (*btc_rune.Transaction, error) {
+	return codec.DecodeTx(tx)
 }
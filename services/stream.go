@@ -0,0 +1,180 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/stream"
+	"github.com/cloakd/common/context"
+	"github.com/cloakd/common/services"
+	"os"
+	"strconv"
+	"time"
+)
+
+// StreamService streams the index events to live subscribers as the ledger commits them,
+// along with the mempool additions and removals
+type StreamService struct {
+	services.DefaultService
+
+	ledger *LedgerService
+
+	hub *stream.Hub
+	// keepAlive is how often an idle stream is pinged
+	keepAlive time.Duration
+}
+
+const STREAM_SVC = "stream_svc"
+
+// Stored events replayed per read when a stream resumes
+const replayBatch = 500
+
+// ErrStaleToken is returned for a resume token naming an event the index does not have
+var ErrStaleToken = errors.New("resume token does not match the index")
+
+// ErrStreamBehind is returned when a subscriber falls too far behind the live events
+var ErrStreamBehind = errors.New("stream fell behind, resume from the last token")
+
+// Message is a streamed event, Token resumes the stream right after it and is unset on mempool events
+type Message struct {
+	Token string `json:"token,omitempty"`
+	*btc_rune.Event
+}
+
+func (svc StreamService) Id() string {
+	return STREAM_SVC
+}
+
+func (svc *StreamService) Configure(ctx *context.Context) (err error) {
+	buffer := 1024
+	if v := os.Getenv("STREAM_BUFFER"); v != "" {
+		buffer, err = strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+	}
+	// The ledger publishes from its Start, before this service starts
+	svc.hub = stream.NewHub(buffer)
+
+	svc.keepAlive = 30 * time.Second
+	if v := os.Getenv("STREAM_KEEPALIVE"); v != "" {
+		svc.keepAlive, err = time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return svc.DefaultService.Configure(ctx)
+}
+
+func (svc *StreamService) Start() error {
+	svc.ledger = svc.Service(LEDGER_SVC).(*LedgerService)
+
+	return nil
+}
+
+// Subscribe returns a subscription to the events published from now on
+func (svc *StreamService) Subscribe() *stream.Subscription {
+	return svc.hub.Subscribe()
+}
+
+// Publish streams events, stored events must be committed first
+func (svc *StreamService) Publish(events ...*btc_rune.Event) {
+	svc.hub.Publish(events...)
+}
+
+// Follow sends the events matching filter until done is closed or send fails.
+// With from set, checked by CheckToken, the stored events after it are replayed first without gaps or repeats.
+// send is called with nil whenever the stream has been idle for the keep alive interval.
+func (svc *StreamService) Follow(done <-chan struct{}, from *stream.Token, filter *stream.Filter, send func(*Message) error) error {
+	// Subscribe before replaying, so events committed meanwhile are received live
+	sub := svc.hub.Subscribe()
+	defer sub.Close()
+
+	var last uint64
+	if from != nil {
+		last = from.ID
+		for {
+			events, err := svc.ledger.Events(last, "", replayBatch)
+			if err != nil {
+				return err
+			}
+			for _, e := range events {
+				last = e.ID
+				err = svc.send(filter, e, send)
+				if err != nil {
+					return err
+				}
+			}
+			if len(events) < replayBatch {
+				break
+			}
+		}
+	}
+
+	ticker := time.NewTicker(svc.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+			err := send(nil)
+			if err != nil {
+				return err
+			}
+		case e, ok := <-sub.C:
+			if !ok {
+				if sub.Dropped() {
+					return ErrStreamBehind
+				}
+				return nil
+			}
+			// Replayed already
+			if e.ID != 0 && e.ID <= last {
+				continue
+			}
+			if e.ID != 0 {
+				last = e.ID
+			}
+			err := svc.send(filter, e, send)
+			if err != nil {
+				return err
+			}
+			ticker.Reset(svc.keepAlive)
+		}
+	}
+}
+
+// CheckToken verifies from names a stored event, a token from before a reindex would skip or repeat events
+func (svc *StreamService) CheckToken(from stream.Token) error {
+	if from.ID == 0 {
+		return nil
+	}
+
+	events, err := svc.ledger.Events(from.ID-1, "", 1)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 || events[0].ID != from.ID || events[0].Height != from.Height {
+		return fmt.Errorf("%w: %s", ErrStaleToken, from)
+	}
+	return nil
+}
+
+func (svc *StreamService) send(filter *stream.Filter, e *btc_rune.Event, send func(*Message) error) error {
+	ok, err := filter.Match(e)
+	if err != nil || !ok {
+		return err
+	}
+
+	return send(newMessage(e))
+}
+
+func newMessage(e *btc_rune.Event) *Message {
+	m := &Message{Event: e}
+	if token, ok := stream.EventToken(e); ok {
+		m.Token = token.String()
+	}
+	return m
+}
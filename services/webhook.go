@@ -0,0 +1,489 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/db"
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/alphabatem/btc_rune/stream"
+	"github.com/alphabatem/btc_rune/webhook"
+	"github.com/cloakd/common/context"
+	"github.com/cloakd/common/services"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WebhookService pushes the stored events to registered webhooks.
+// Events are queued in a durable outbox in the same transaction that advances the webhook's cursor,
+// then posted signed, retrying with exponential backoff, so deliveries survive restarts.
+// Delivery is at least once and unordered, receivers de-duplicate by event ID.
+type WebhookService struct {
+	services.DefaultService
+
+	store  storage.Store
+	stream *StreamService
+
+	client *http.Client
+	// workers post deliveries in parallel
+	workers     int
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	// poll is how often due retries are looked for without new events
+	poll time.Duration
+	// apiKey guards the management routes, which are not served without one
+	apiKey string
+}
+
+const WEBHOOK_SVC = "webhook_svc"
+
+// Deliveries read from the outbox per pass
+const deliveryBatch = 100
+
+// Response bytes read from a receiver, the rest is discarded
+const maxResponseBody = 64 << 10
+
+// ErrInvalidWebhook is returned for a webhook registration that cannot be delivered to
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// WebhookRequest registers a webhook, Secret is generated when empty
+type WebhookRequest struct {
+	URL       string               `json:"url"`
+	Secret    string               `json:"secret"`
+	Runes     []btc_rune.RuneID    `json:"runes"`
+	Addresses []string             `json:"addresses"`
+	Types     []btc_rune.EventType `json:"types"`
+}
+
+func (svc WebhookService) Id() string {
+	return WEBHOOK_SVC
+}
+
+func (svc *WebhookService) Configure(ctx *context.Context) (err error) {
+	timeout := 10 * time.Second
+	if v := os.Getenv("WEBHOOK_TIMEOUT"); v != "" {
+		timeout, err = time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+	}
+	svc.client = &http.Client{Timeout: timeout}
+
+	svc.workers = 4
+	if v := os.Getenv("WEBHOOK_WORKERS"); v != "" {
+		svc.workers, err = strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		if svc.workers < 1 {
+			return fmt.Errorf("WEBHOOK_WORKERS must be at least 1, got %d", svc.workers)
+		}
+	}
+
+	// With the default backoff the last attempt is about a day after the first
+	svc.maxAttempts = 30
+	if v := os.Getenv("WEBHOOK_MAX_ATTEMPTS"); v != "" {
+		svc.maxAttempts, err = strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	svc.backoff = 10 * time.Second
+	if v := os.Getenv("WEBHOOK_BACKOFF"); v != "" {
+		svc.backoff, err = time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	svc.maxBackoff = time.Hour
+	if v := os.Getenv("WEBHOOK_MAX_BACKOFF"); v != "" {
+		svc.maxBackoff, err = time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	svc.poll = 5 * time.Second
+	svc.apiKey = os.Getenv("WEBHOOK_API_KEY")
+
+	return svc.DefaultService.Configure(ctx)
+}
+
+func (svc *WebhookService) Start() error {
+	svc.store = svc.Service(db.STORAGE_SVC).(db.StorageService).Store()
+	svc.stream = svc.Service(STREAM_SVC).(*StreamService)
+
+	go svc.listen()
+	return nil
+}
+
+// APIKey returns the key guarding the management routes, empty when they are disabled
+func (svc *WebhookService) APIKey() string {
+	return svc.apiKey
+}
+
+// Register validates and stores a webhook, returning it with its secret.
+// It receives the events stored from now on.
+func (svc *WebhookService) Register(req *WebhookRequest) (w *btc_rune.Webhook, err error) {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+	for _, t := range req.Types {
+		if !t.Valid() {
+			return nil, fmt.Errorf("%w: unknown event type %q", ErrInvalidWebhook, t)
+		}
+		if t == btc_rune.EventMempoolAdd || t == btc_rune.EventMempoolRemove {
+			return nil, fmt.Errorf("%w: mempool events are only streamed", ErrInvalidWebhook)
+		}
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		secret, err = webhook.NewSecret()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	w = &btc_rune.Webhook{
+		URL:       u.String(),
+		Secret:    secret,
+		Runes:     req.Runes,
+		Addresses: req.Addresses,
+		Types:     req.Types,
+	}
+	err = svc.store.Atomic(func(dbTx storage.Tx) error {
+		last, err := dbTx.LastEvent()
+		if err != nil {
+			return err
+		}
+		if last != nil {
+			w.Since = last.ID
+			w.Cursor = last.ID
+		}
+		return dbTx.CreateWebhook(w)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("WEBHOOK: registered %d for %s after event %d", w.ID, w.URL, w.Since)
+	return w, nil
+}
+
+// Webhooks returns every webhook, without secrets
+func (svc *WebhookService) Webhooks() ([]*btc_rune.Webhook, error) {
+	hooks, err := svc.store.Webhooks()
+	for _, w := range hooks {
+		w.Secret = ""
+	}
+	return hooks, err
+}
+
+// Webhook returns the webhook id without its secret
+func (svc *WebhookService) Webhook(id uint64) (*btc_rune.Webhook, error) {
+	w, err := svc.store.Webhook(id)
+	if err != nil {
+		return nil, err
+	}
+	w.Secret = ""
+	return w, nil
+}
+
+// Delete unregisters the webhook id and drops its deliveries
+func (svc *WebhookService) Delete(id uint64) error {
+	return svc.store.Atomic(func(dbTx storage.Tx) error {
+		_, err := dbTx.Webhook(id)
+		if err != nil {
+			return err
+		}
+		return dbTx.DeleteWebhook(id)
+	})
+}
+
+// Deliveries returns up to limit deliveries of the webhook id after a delivery ID, optionally only of status
+func (svc *WebhookService) Deliveries(id uint64, status btc_rune.DeliveryStatus, after uint64, limit int) (deliveries []*btc_rune.Delivery, err error) {
+	err = svc.store.Atomic(func(dbTx storage.Tx) error {
+		_, err := dbTx.Webhook(id)
+		if err != nil {
+			return err
+		}
+		deliveries, err = dbTx.Deliveries(id, status, after, limit)
+		return err
+	})
+	return deliveries, err
+}
+
+// listen queues and posts deliveries whenever events are stored, and retries due deliveries every poll
+func (svc *WebhookService) listen() {
+	sub := svc.stream.Subscribe()
+	ticker := time.NewTicker(svc.poll)
+	defer ticker.Stop()
+
+	for {
+		err := svc.enqueue()
+		if err != nil {
+			log.Println("Webhook Enqueue Err", err)
+		}
+
+		err = svc.deliver()
+		if err != nil {
+			log.Println("Webhook Deliver Err", err)
+		}
+
+		select {
+		case _, ok := <-sub.C:
+			if !ok {
+				// Dropped while delivering, nothing is lost as the outbox reads the stored events
+				sub = svc.stream.Subscribe()
+			}
+			drain(sub)
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain discards the events already received, one pass handles them all
+func drain(sub *stream.Subscription) {
+	for {
+		select {
+		case _, ok := <-sub.C:
+			if !ok {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// enqueue queues the events stored since each webhook's cursor
+func (svc *WebhookService) enqueue() error {
+	hooks, err := svc.store.Webhooks()
+	if err != nil {
+		return err
+	}
+
+	for _, w := range hooks {
+		for {
+			n, err := svc.enqueueWebhook(w.ID)
+			if err != nil {
+				return fmt.Errorf("webhook %d: %w", w.ID, err)
+			}
+			if n < replayBatch {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// enqueueWebhook queues the matching events of a batch after the webhook's cursor and advances it, returning the events read
+func (svc *WebhookService) enqueueWebhook(id uint64) (n int, err error) {
+	err = svc.store.Atomic(func(dbTx storage.Tx) error {
+		w, err := dbTx.Webhook(id)
+		if err == storage.ErrNotFound {
+			return nil // Deleted meanwhile
+		}
+		if err != nil {
+			return err
+		}
+
+		events, err := dbTx.Events(w.Cursor, "", replayBatch)
+		if err != nil {
+			return err
+		}
+		n = len(events)
+		if n == 0 {
+			return nil
+		}
+
+		filter := stream.NewFilter(w.Runes, w.Addresses, w.Types)
+		now := time.Now().UTC()
+		var deliveries []*btc_rune.Delivery
+		for _, e := range events {
+			ok, err := matchWebhook(w, filter, e)
+			if err != nil {
+				return fmt.Errorf("event %d: %w", e.ID, err)
+			}
+			if !ok {
+				continue
+			}
+
+			payload, err := json.Marshal(newMessage(e))
+			if err != nil {
+				return err
+			}
+			deliveries = append(deliveries, &btc_rune.Delivery{
+				Webhook:     w.ID,
+				Event:       e.ID,
+				Type:        e.Type,
+				Payload:     payload,
+				Status:      btc_rune.DeliveryPending,
+				NextAttempt: now,
+				CreatedAt:   now,
+			})
+		}
+
+		err = dbTx.CreateDeliveries(deliveries)
+		if err != nil {
+			return err
+		}
+		w.Cursor = events[n-1].ID
+		return dbTx.SaveWebhook(w)
+	})
+	return n, err
+}
+
+// matchWebhook reports whether e is delivered to w.
+// A reverted event is only delivered when the event it reverts was stored after w was registered.
+func matchWebhook(w *btc_rune.Webhook, filter *stream.Filter, e *btc_rune.Event) (bool, error) {
+	if e.Type == btc_rune.EventReverted {
+		var d btc_rune.RevertedData
+		err := json.Unmarshal(e.Data, &d)
+		if err != nil {
+			return false, err
+		}
+		if d.Event != nil && d.Event.ID <= w.Since {
+			return false, nil
+		}
+	}
+	return filter.Match(e)
+}
+
+// deliver posts the due deliveries until none are left
+func (svc *WebhookService) deliver() error {
+	for {
+		due, err := svc.store.DueDeliveries(time.Now().UTC(), deliveryBatch)
+		if err != nil || len(due) == 0 {
+			return err
+		}
+
+		hooks := map[uint64]*btc_rune.Webhook{}
+		for _, d := range due {
+			if _, ok := hooks[d.Webhook]; ok {
+				continue
+			}
+			hooks[d.Webhook], err = svc.store.Webhook(d.Webhook)
+			if err != nil && err != storage.ErrNotFound {
+				return err
+			}
+		}
+
+		// Left pending, deliveries of deleted webhooks would be fetched again every pass
+		for _, d := range due {
+			if hooks[d.Webhook] == nil {
+				err = svc.abandon(d)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		jobs := make(chan *btc_rune.Delivery)
+		errs := make(chan error, len(due))
+		var wg sync.WaitGroup
+		for i := 0; i < svc.workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for d := range jobs {
+					errs <- svc.attempt(hooks[d.Webhook], d)
+				}
+			}()
+		}
+		for _, d := range due {
+			if hooks[d.Webhook] != nil {
+				jobs <- d
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(errs)
+
+		for err = range errs {
+			if err != nil {
+				return err
+			}
+		}
+		if len(due) < deliveryBatch {
+			return nil
+		}
+	}
+}
+
+// attempt posts d to w once and records the outcome, scheduling a retry on failure
+func (svc *WebhookService) attempt(w *btc_rune.Webhook, d *btc_rune.Delivery) error {
+	status, err := svc.post(w, d)
+
+	now := time.Now().UTC()
+	d.Attempts++
+	d.LastStatus = status
+	d.LastError = ""
+	switch {
+	case err == nil:
+		d.Status = btc_rune.DeliveryDelivered
+		d.DeliveredAt = &now
+	case d.Attempts >= svc.maxAttempts:
+		d.Status = btc_rune.DeliveryFailed
+		d.LastError = err.Error()
+		log.Printf("WEBHOOK: delivery %d of event %d to %d failed after %d attempts: %s", d.ID, d.Event, w.ID, d.Attempts, err)
+	default:
+		d.LastError = err.Error()
+		d.NextAttempt = now.Add(webhook.Backoff(svc.backoff, svc.maxBackoff, d.Attempts))
+	}
+
+	err = svc.store.SaveDelivery(d)
+	if err == storage.ErrNotFound {
+		return nil // The webhook was deleted meanwhile
+	}
+	return err
+}
+
+// abandon fails a delivery whose webhook no longer exists
+func (svc *WebhookService) abandon(d *btc_rune.Delivery) error {
+	d.Status = btc_rune.DeliveryFailed
+	d.LastError = "webhook deleted"
+	err := svc.store.SaveDelivery(d)
+	if err == storage.ErrNotFound {
+		return nil // Dropped with the webhook
+	}
+	return err
+}
+
+// post sends the delivery payload, returning the response status
+func (svc *WebhookService) post(w *btc_rune.Webhook, d *btc_rune.Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", strconv.FormatUint(w.ID, 10))
+	req.Header.Set("X-Webhook-Event", string(d.Type))
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(d.ID, 10))
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(w.Secret, time.Now(), d.Payload))
+
+	resp, err := svc.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
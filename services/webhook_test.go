@@ -0,0 +1,48 @@
+package services
+
+import (
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/db"
+	"github.com/alphabatem/btc_rune/storage"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWebhookService_DeliverOrphans(t *testing.T) {
+	store, err := db.NewBoltStore(filepath.Join(t.TempDir(), "rune.bolt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// A full batch of deliveries to a webhook that does not exist
+	now := time.Now().UTC().Add(-time.Minute)
+	deliveries := make([]*btc_rune.Delivery, deliveryBatch)
+	for i := range deliveries {
+		deliveries[i] = &btc_rune.Delivery{Webhook: 99, Event: uint64(i + 1), Status: btc_rune.DeliveryPending, NextAttempt: now, CreatedAt: now}
+	}
+	if err = store.Atomic(func(dbTx storage.Tx) error { return dbTx.CreateDeliveries(deliveries) }); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &WebhookService{store: store, workers: 1}
+	done := make(chan error)
+	go func() { done <- svc.deliver() }()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected deliver to return")
+	}
+
+	due, err := store.DueDeliveries(time.Now().UTC(), deliveryBatch)
+	if err != nil || len(due) != 0 {
+		t.Fatalf("Expected no due deliveries - Got %d %v", len(due), err)
+	}
+}
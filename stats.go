@@ -0,0 +1,36 @@
+package btc_rune
+
+// RuneStats is the ledger's running account of a rune, updated with every block that moves it.
+// Block and Tx are the rune's id, kept as integers so runes list in issuance order.
+type RuneStats struct {
+	Block uint64 `json:"block" gorm:"primaryKey;autoIncrement:false"`
+	Tx    uint32 `json:"tx" gorm:"primaryKey;autoIncrement:false"`
+
+	// Supply is the amount held by unspent outputs
+	Supply Uint128 `json:"supply"`
+	// Burned is the amount destroyed by OP_RETURN outputs, cenotaphs and unallocated edicts
+	Burned Uint128 `json:"burned"`
+	// Holders counts the addresses holding a balance
+	Holders uint64 `json:"holders" gorm:"index"`
+	// Transfers counts the transactions that spent the rune
+	Transfers uint64 `json:"transfers" gorm:"index"`
+	// LastHeight is the height of the last block that moved the rune
+	LastHeight int64 `json:"lastHeight"`
+}
+
+// NewRuneStats returns the empty stats of id
+func NewRuneStats(id RuneID) *RuneStats {
+	return &RuneStats{Block: id.Block, Tx: id.Tx}
+}
+
+// RuneID returns the rune the stats account for
+func (s *RuneStats) RuneID() RuneID {
+	return RuneID{Block: s.Block, Tx: s.Tx}
+}
+
+// Holding is the total amount of a rune held by the outputs paying an address
+type Holding struct {
+	Address string  `json:"address" gorm:"primaryKey"`
+	Rune    RuneID  `json:"rune" gorm:"primaryKey;index"`
+	Amount  Uint128 `json:"amount"`
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"github.com/alphabatem/btc_rune"
+)
+
+// BlockWrite is everything applying a block changes besides the rune registry: its balances,
+// rune stats and holdings, undo journal, events and the tip, the cursor syncing resumes from
+type BlockWrite struct {
+	Block    *btc_rune.Block
+	Spent    []*btc_rune.RuneBalance
+	Credits  []*btc_rune.RuneBalance
+	Stats    []*btc_rune.RuneStats
+	Holdings []*btc_rune.Holding
+	Undo     []*btc_rune.UndoRecord
+	Events   []*btc_rune.Event
+
+	// UndoDepth is how many blocks below Block keep their undo journal, older journals are pruned
+	UndoDepth int64
+}
+
+// WriteBlock writes w through tx, which should be the Atomic transaction the block was applied in
+// so the block is stored in full or not at all. The tip moves last.
+func WriteBlock(tx Tx, w *BlockWrite) error {
+	err := tx.DeleteBalances(w.Spent)
+	if err != nil {
+		return err
+	}
+
+	err = tx.CreateBalances(w.Credits)
+	if err != nil {
+		return err
+	}
+
+	for _, stats := range w.Stats {
+		err = tx.SaveRuneStats(stats)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, h := range w.Holdings {
+		err = tx.SetHolding(h)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.CreateUndo(w.Undo)
+	if err != nil {
+		return err
+	}
+
+	err = tx.PruneUndo(w.Block.Height - w.UndoDepth)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range w.Events {
+		err = tx.CreateEvent(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.CreateBlock(w.Block)
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer binary than this one
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// Steps returns the migration versions to run, in order, to move a schema from current to target.
+//
+// Migrations are numbered from 1 to latest, version 0 is an empty database.
+// Moving up runs the up step of every version after current, moving down
+// runs the down step of every version from current down to just above target.
+func Steps(current, target, latest int) ([]int, error) {
+	if current > latest {
+		return nil, fmt.Errorf("%w: database at version %d, binary knows up to %d", ErrSchemaTooNew, current, latest)
+	}
+	if target < 0 || target > latest {
+		return nil, fmt.Errorf("unknown schema version %d, latest is %d", target, latest)
+	}
+
+	var steps []int
+	for v := current + 1; v <= target; v++ {
+		steps = append(steps, v)
+	}
+	for v := current; v > target; v-- {
+		steps = append(steps, v)
+	}
+	return steps, nil
+}
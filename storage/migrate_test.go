@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSteps(t *testing.T) {
+	for _, tt := range []struct {
+		current, target int
+		want            []int
+	}{
+		{0, 3, []int{1, 2, 3}},
+		{1, 3, []int{2, 3}},
+		{3, 3, nil},
+		{3, 1, []int{3, 2}},
+		{2, 0, []int{2, 1}},
+	} {
+		got, err := Steps(tt.current, tt.target, 3)
+		if err != nil || len(got) != len(tt.want) {
+			t.Fatalf("%d -> %d: Expected %v - Got %v %v", tt.current, tt.target, tt.want, got, err)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("%d -> %d: Expected %v - Got %v", tt.current, tt.target, tt.want, got)
+			}
+		}
+	}
+
+	if _, err := Steps(4, 3, 3); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("Expected ErrSchemaTooNew - Got %v", err)
+	}
+	if _, err := Steps(0, 4, 3); err == nil {
+		t.Fatalf("Expected unknown version error")
+	}
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/alphabatem/btc_rune"
+)
+
+// RuneSort is the order of a rune listing
+type RuneSort string
+
+const (
+	// SortHeight lists runes in issuance order
+	SortHeight    RuneSort = "height"
+	SortHolders   RuneSort = "holders"
+	SortTransfers RuneSort = "transfers"
+)
+
+// RuneQuery selects a page of runes
+type RuneQuery struct {
+	// Prefix keeps the runes whose symbol starts with it
+	Prefix string
+	Sort   RuneSort
+	// Desc lists largest first, ties always go in issuance order
+	Desc   bool
+	Offset int
+	// Limit caps the page, zero lists every rune
+	Limit int
+}
+
+// RuneEntry is a registered rune and its stats
+type RuneEntry struct {
+	Rune  *btc_rune.Rune
+	Stats *btc_rune.RuneStats
+}
+
+// SortStats orders stats as q lists them, for backends that cannot sort themselves
+func SortStats(stats []*btc_rune.RuneStats, q RuneQuery) {
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if q.Desc {
+			a, b = b, a
+		}
+
+		switch q.Sort {
+		case SortHolders:
+			if a.Holders != b.Holders {
+				return a.Holders < b.Holders
+			}
+		case SortTransfers:
+			if a.Transfers != b.Transfers {
+				return a.Transfers < b.Transfers
+			}
+		default:
+			return a.RuneID().Less(b.RuneID())
+		}
+
+		// Ties go in issuance order either way
+		return stats[i].RuneID().Less(stats[j].RuneID())
+	})
+}
@@ -0,0 +1,120 @@
+// Package storage defines the typed repository the indexer and API read and write the index through.
+//
+// Backends implement Store, the services never see the database underneath.
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// ErrNotFound is returned by rune lookups that match nothing
+var ErrNotFound = errors.New("not found")
+
+// Tx reads and writes the index.
+// Outside of Store.Atomic every call stands alone.
+type Tx interface {
+	// Rune returns the rune etched at id
+	Rune(id btc_rune.RuneID) (*btc_rune.Rune, error)
+	// RuneBySymbol returns the rune registered under symbol
+	RuneBySymbol(symbol string) (*btc_rune.Rune, error)
+	// RuneByIssuance returns the rune etched by the transaction txID
+	RuneByIssuance(txID string) (*btc_rune.Rune, error)
+	// RuneByNumber returns the rune etched as the number'th of its protocol version
+	RuneByNumber(version btc_rune.Protocol, number uint64) (*btc_rune.Rune, error)
+	// CountRunes returns how many runes of version are registered
+	CountRunes(version btc_rune.Protocol) (uint64, error)
+	// CreateRune registers r along with its empty stats
+	CreateRune(r *btc_rune.Rune) error
+	// DeleteRune unregisters id and drops its stats
+	DeleteRune(id btc_rune.RuneID) error
+	// AddMints adjusts the mint count of id by delta
+	AddMints(id btc_rune.RuneID, delta int64) error
+
+	// RuneStats returns the stats of id, ErrNotFound for an unregistered rune
+	RuneStats(id btc_rune.RuneID) (*btc_rune.RuneStats, error)
+	// SaveRuneStats replaces the stats of a registered rune
+	SaveRuneStats(s *btc_rune.RuneStats) error
+	// Runes lists registered runes with their stats
+	Runes(q RuneQuery) ([]*RuneEntry, error)
+
+	// Holding returns the amount of id held by address, zero when none
+	Holding(address string, id btc_rune.RuneID) (btc_rune.Uint128, error)
+	// SetHolding replaces what h.Address holds of h.Rune, a zero amount removes the holding
+	SetHolding(h *btc_rune.Holding) error
+	// Holders returns the holdings of id largest first, ties by address
+	Holders(id btc_rune.RuneID, offset, limit int) ([]*btc_rune.Holding, error)
+
+	// Balances returns the rune balances held by outpoints
+	Balances(outpoints []wire.OutPoint) ([]*btc_rune.RuneBalance, error)
+	// AddressBalances returns every rune balance held by outputs paying address, in no particular order
+	AddressBalances(address string) ([]*btc_rune.RuneBalance, error)
+	CreateBalances(balances []*btc_rune.RuneBalance) error
+	DeleteBalances(balances []*btc_rune.RuneBalance) error
+
+	// CreateEvent appends e, assigning its ID
+	CreateEvent(e *btc_rune.Event) error
+	// Events returns up to limit events after id in ID order, optionally only of eventType
+	Events(after uint64, eventType btc_rune.EventType, limit int) ([]*btc_rune.Event, error)
+	// LastEvent returns the most recent event, nil when there is none
+	LastEvent() (*btc_rune.Event, error)
+	// BlockEvents returns the rune events stored with the block hash at height the last time it was connected,
+	// in ID order. They are the run of events right before its EventBlockConnected.
+	BlockEvents(height int64, hash string) ([]*btc_rune.Event, error)
+
+	// CreateWebhook registers w, assigning its ID
+	CreateWebhook(w *btc_rune.Webhook) error
+	// Webhook returns the webhook id, ErrNotFound when there is none
+	Webhook(id uint64) (*btc_rune.Webhook, error)
+	// Webhooks returns every webhook in ID order
+	Webhooks() ([]*btc_rune.Webhook, error)
+	// SaveWebhook replaces a registered webhook
+	SaveWebhook(w *btc_rune.Webhook) error
+	// DeleteWebhook unregisters id along with its deliveries
+	DeleteWebhook(id uint64) error
+
+	// CreateDeliveries queues deliveries, assigning their IDs in order
+	CreateDeliveries(deliveries []*btc_rune.Delivery) error
+	// SaveDelivery replaces a queued delivery
+	SaveDelivery(d *btc_rune.Delivery) error
+	// DueDeliveries returns up to limit pending deliveries due at or before now, most overdue first
+	DueDeliveries(now time.Time, limit int) ([]*btc_rune.Delivery, error)
+	// Deliveries returns up to limit deliveries of webhook after id in ID order, optionally only of status
+	Deliveries(webhook uint64, status btc_rune.DeliveryStatus, after uint64, limit int) ([]*btc_rune.Delivery, error)
+
+	// Tip returns the highest applied block, nil when nothing is indexed
+	Tip() (*btc_rune.Block, error)
+	// Block returns the applied block at height, nil when there is none
+	Block(height int64) (*btc_rune.Block, error)
+	CreateBlock(b *btc_rune.Block) error
+	DeleteBlock(height int64) error
+
+	// CreateUndo appends records, assigning their IDs in order
+	CreateUndo(records []*btc_rune.UndoRecord) error
+	// Undo returns the undo records of height in ID order
+	Undo(height int64) ([]*btc_rune.UndoRecord, error)
+	DeleteUndo(height int64) error
+	// PruneUndo drops the undo records at and below height
+	PruneUndo(height int64) error
+}
+
+// Store is a storage backend
+type Store interface {
+	Tx
+
+	// Atomic runs fn in a single transaction, committed when fn returns nil and rolled back otherwise
+	Atomic(fn func(tx Tx) error) error
+	// Migrate applies every pending migration, it fails with ErrSchemaTooNew
+	// against a database migrated by a newer binary
+	Migrate() error
+	// MigrateTo migrates the schema up or down to version, 0 drops the whole index
+	MigrateTo(version int) error
+	// SchemaVersion returns the applied schema version, 0 before the first migration
+	SchemaVersion() (int, error)
+	// LatestSchema returns the newest schema version the backend knows
+	LatestSchema() int
+	Close() error
+}
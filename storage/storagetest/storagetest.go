@@ -0,0 +1,743 @@
+// Package storagetest is the conformance suite every storage backend runs in its tests
+package storagetest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/storage"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Open returns an empty, migrated store
+type Open func(t *testing.T) storage.Store
+
+// Run checks the backend opened by open against the storage.Store contract
+func Run(t *testing.T, open Open) {
+	tests := map[string]func(*testing.T, storage.Store){
+		"Runes":       testRunes,
+		"Balances":    testBalances,
+		"Directory":   testDirectory,
+		"Holdings":    testHoldings,
+		"Events":      testEvents,
+		"BlockEvents": testBlockEvents,
+		"Webhooks":    testWebhooks,
+		"Blocks":      testBlocks,
+		"Undo":        testUndo,
+		"Atomic":      testAtomic,
+		"WriteBlock":  testWriteBlock,
+		"Migrations":  testMigrations,
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			defer s.Close()
+			test(t, s)
+		})
+	}
+}
+
+var errAbort = errors.New("abort")
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Hash returns a deterministic txid for tests
+func Hash(n byte) chainhash.Hash {
+	return chainhash.Hash{n, 0xaa, n}
+}
+
+// Balance returns a balance of amount id held by the outpoint n:vout
+func Balance(n byte, vout uint32, id btc_rune.RuneID, amount uint64) *btc_rune.RuneBalance {
+	hash := Hash(n)
+	return &btc_rune.RuneBalance{
+		TxID:    hash.String(),
+		Vout:    vout,
+		Rune:    id,
+		Amount:  btc_rune.NewUint128(amount),
+		Address: "bc1qtest",
+		Value:   546,
+		Height:  840000,
+	}
+}
+
+func testRunes(t *testing.T, s storage.Store) {
+	amount := btc_rune.MaxUint128
+	start := uint64(840000)
+	r := &btc_rune.Rune{
+		ID:         btc_rune.RuneID{Block: 840000, Tx: 7},
+		Number:     1,
+		Version:    btc_rune.ProtocolRunestone,
+		IssuanceTx: "issuance",
+		Symbol:     "UNCOMMONGOODS",
+		Decimals:   2,
+		Premine:    btc_rune.NewUint128(1000),
+		Terms:      &btc_rune.Terms{Amount: &amount, HeightStart: &start},
+	}
+	must(t, s.CreateRune(r))
+	must(t, s.CreateRune(&btc_rune.Rune{ID: btc_rune.RuneID{Block: 840001, Tx: 1}, Number: 1, Version: btc_rune.ProtocolLegacy, Symbol: "LEGACY"}))
+
+	for name, lookup := range map[string]func() (*btc_rune.Rune, error){
+		"id":       func() (*btc_rune.Rune, error) { return s.Rune(r.ID) },
+		"symbol":   func() (*btc_rune.Rune, error) { return s.RuneBySymbol("UNCOMMONGOODS") },
+		"issuance": func() (*btc_rune.Rune, error) { return s.RuneByIssuance("issuance") },
+		"number":   func() (*btc_rune.Rune, error) { return s.RuneByNumber(btc_rune.ProtocolRunestone, 1) },
+	} {
+		got, err := lookup()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if got.ID != r.ID || got.Symbol != r.Symbol || got.Premine != r.Premine || got.Terms == nil || *got.Terms.Amount != amount || *got.Terms.HeightStart != start || got.Terms.Cap != nil {
+			t.Fatalf("%s: Expected %+v - Got %+v", name, r, got)
+		}
+	}
+
+	legacy, err := s.RuneByNumber(btc_rune.ProtocolLegacy, 1)
+	must(t, err)
+	if legacy.Symbol != "LEGACY" || legacy.Terms != nil {
+		t.Fatalf("Expected legacy rune without terms - Got %+v", legacy)
+	}
+
+	count, err := s.CountRunes(btc_rune.ProtocolRunestone)
+	must(t, err)
+	if count != 1 {
+		t.Fatalf("Expected 1 runestone rune - Got %d", count)
+	}
+
+	must(t, s.AddMints(r.ID, 1))
+	must(t, s.AddMints(r.ID, 1))
+	must(t, s.AddMints(r.ID, -1))
+	got, err := s.Rune(r.ID)
+	must(t, err)
+	if got.Mints != 1 {
+		t.Fatalf("Expected 1 mint - Got %d", got.Mints)
+	}
+
+	must(t, s.DeleteRune(r.ID))
+	if _, err = s.Rune(r.ID); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound - Got %v", err)
+	}
+	if _, err = s.RuneBySymbol("UNCOMMONGOODS"); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound - Got %v", err)
+	}
+}
+
+func testDirectory(t *testing.T, s storage.Store) {
+	ids := []btc_rune.RuneID{{Block: 840000, Tx: 1}, {Block: 840000, Tx: 2}, {Block: 840001, Tx: 0}}
+	for i, symbol := range []string{"ALPHA", "ALPINE", "BRAVO"} {
+		must(t, s.CreateRune(&btc_rune.Rune{ID: ids[i], Number: uint64(i), Symbol: symbol}))
+	}
+
+	stats, err := s.RuneStats(ids[0])
+	must(t, err)
+	if stats.RuneID() != ids[0] || !stats.Supply.IsZero() || stats.Holders != 0 {
+		t.Fatalf("Expected empty stats for new rune - Got %+v", stats)
+	}
+	if _, err = s.RuneStats(btc_rune.RuneID{Block: 1}); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound - Got %v", err)
+	}
+	if err = s.SaveRuneStats(btc_rune.NewRuneStats(btc_rune.RuneID{Block: 1})); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound saving unregistered stats - Got %v", err)
+	}
+
+	saved := []*btc_rune.RuneStats{
+		{Block: 840000, Tx: 1, Supply: btc_rune.MaxUint128, Burned: btc_rune.NewUint128(5), Holders: 3, Transfers: 1, LastHeight: 840010},
+		{Block: 840000, Tx: 2, Holders: 3, Transfers: 7, LastHeight: 840002},
+		{Block: 840001, Tx: 0, Holders: 9, Transfers: 0, LastHeight: 840001},
+	}
+	for _, stats := range saved {
+		must(t, s.SaveRuneStats(stats))
+	}
+	stats, err = s.RuneStats(ids[0])
+	must(t, err)
+	if *stats != *saved[0] {
+		t.Fatalf("Expected %+v - Got %+v", saved[0], stats)
+	}
+
+	list := func(q storage.RuneQuery) []btc_rune.RuneID {
+		t.Helper()
+		entries, err := s.Runes(q)
+		must(t, err)
+		listed := make([]btc_rune.RuneID, len(entries))
+		for i, e := range entries {
+			if e.Rune.ID != e.Stats.RuneID() {
+				t.Fatalf("Expected rune and stats to match - Got %s and %s", e.Rune.ID, e.Stats.RuneID())
+			}
+			listed[i] = e.Rune.ID
+		}
+		return listed
+	}
+	for name, c := range map[string]struct {
+		q    storage.RuneQuery
+		want []btc_rune.RuneID
+	}{
+		"height asc":     {storage.RuneQuery{Sort: storage.SortHeight}, ids},
+		"height desc":    {storage.RuneQuery{Sort: storage.SortHeight, Desc: true}, []btc_rune.RuneID{ids[2], ids[1], ids[0]}},
+		"holders desc":   {storage.RuneQuery{Sort: storage.SortHolders, Desc: true}, []btc_rune.RuneID{ids[2], ids[0], ids[1]}},
+		"transfers desc": {storage.RuneQuery{Sort: storage.SortTransfers, Desc: true}, []btc_rune.RuneID{ids[1], ids[0], ids[2]}},
+		"transfers asc":  {storage.RuneQuery{Sort: storage.SortTransfers}, []btc_rune.RuneID{ids[2], ids[0], ids[1]}},
+		"page":           {storage.RuneQuery{Sort: storage.SortHeight, Offset: 1, Limit: 1}, []btc_rune.RuneID{ids[1]}},
+		"offset only":    {storage.RuneQuery{Sort: storage.SortHeight, Offset: 2}, []btc_rune.RuneID{ids[2]}},
+		"past the end":   {storage.RuneQuery{Sort: storage.SortHeight, Offset: 3, Limit: 10}, []btc_rune.RuneID{}},
+		"prefix":         {storage.RuneQuery{Prefix: "ALP", Sort: storage.SortHolders}, []btc_rune.RuneID{ids[0], ids[1]}},
+		"no match":       {storage.RuneQuery{Prefix: "C", Sort: storage.SortHeight}, []btc_rune.RuneID{}},
+	} {
+		got := list(c.q)
+		if len(got) != len(c.want) {
+			t.Fatalf("%s: Expected %v - Got %v", name, c.want, got)
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Fatalf("%s: Expected %v - Got %v", name, c.want, got)
+			}
+		}
+	}
+
+	must(t, s.DeleteRune(ids[0]))
+	if _, err = s.RuneStats(ids[0]); err != storage.ErrNotFound {
+		t.Fatalf("Expected stats dropped with rune - Got %v", err)
+	}
+	if got := list(storage.RuneQuery{}); len(got) != 2 {
+		t.Fatalf("Expected deleted rune unlisted - Got %v", got)
+	}
+}
+
+func testHoldings(t *testing.T, s storage.Store) {
+	id := btc_rune.RuneID{Block: 840000, Tx: 1}
+	other := btc_rune.RuneID{Block: 840000, Tx: 2}
+
+	amount, err := s.Holding("bc1qa", id)
+	must(t, err)
+	if !amount.IsZero() {
+		t.Fatalf("Expected no holding - Got %s", amount)
+	}
+
+	// 9 and 10 order numerically, not as text
+	for _, h := range []*btc_rune.Holding{
+		{Address: "bc1qa", Rune: id, Amount: btc_rune.NewUint128(9)},
+		{Address: "bc1qb", Rune: id, Amount: btc_rune.NewUint128(10)},
+		{Address: "bc1qc", Rune: id, Amount: btc_rune.NewUint128(9)},
+		{Address: "bc1qd", Rune: id, Amount: btc_rune.MaxUint128},
+		{Address: "bc1qa", Rune: other, Amount: btc_rune.NewUint128(1)},
+	} {
+		must(t, s.SetHolding(h))
+	}
+	// Replacing a holding moves it in the ranking
+	must(t, s.SetHolding(&btc_rune.Holding{Address: "bc1qd", Rune: id, Amount: btc_rune.NewUint128(2)}))
+
+	holders, err := s.Holders(id, 0, 10)
+	must(t, err)
+	want := []string{"bc1qb", "bc1qa", "bc1qc", "bc1qd"}
+	if len(holders) != len(want) {
+		t.Fatalf("Expected %d holders - Got %+v", len(want), holders)
+	}
+	for i, h := range holders {
+		if h.Address != want[i] || h.Rune != id {
+			t.Fatalf("Expected holder %d to be %s - Got %+v", i, want[i], h)
+		}
+	}
+	if holders[0].Amount != btc_rune.NewUint128(10) || holders[3].Amount != btc_rune.NewUint128(2) {
+		t.Fatalf("Expected holding amounts - Got %s and %s", holders[0].Amount, holders[3].Amount)
+	}
+
+	holders, err = s.Holders(id, 1, 2)
+	must(t, err)
+	if len(holders) != 2 || holders[0].Address != "bc1qa" || holders[1].Address != "bc1qc" {
+		t.Fatalf("Expected second page - Got %+v", holders)
+	}
+
+	// A zero amount removes the holding
+	must(t, s.SetHolding(&btc_rune.Holding{Address: "bc1qb", Rune: id}))
+	amount, err = s.Holding("bc1qb", id)
+	must(t, err)
+	holders, err = s.Holders(id, 0, 10)
+	must(t, err)
+	if !amount.IsZero() || len(holders) != 3 {
+		t.Fatalf("Expected holding removed - Got %s and %d holders", amount, len(holders))
+	}
+	amount, err = s.Holding("bc1qa", other)
+	must(t, err)
+	if amount != btc_rune.NewUint128(1) {
+		t.Fatalf("Expected other rune holding untouched - Got %s", amount)
+	}
+}
+
+func testBalances(t *testing.T, s storage.Store) {
+	a := btc_rune.RuneID{Block: 840000, Tx: 1}
+	b := btc_rune.RuneID{Block: 840000, Tx: 2}
+
+	// Two runes on vout 0, one on vout 1 of the same tx
+	balances := []*btc_rune.RuneBalance{Balance(1, 0, a, 10), Balance(1, 0, b, 20), Balance(1, 1, a, 30), Balance(2, 0, a, 40)}
+	balances[0].Amount = btc_rune.MaxUint128
+	must(t, s.CreateBalances(balances))
+	must(t, s.CreateBalances(nil))
+
+	got, err := s.Balances([]wire.OutPoint{{Hash: Hash(1), Index: 0}, {Hash: Hash(3), Index: 0}})
+	must(t, err)
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 balances on 1:0 - Got %d", len(got))
+	}
+	for _, g := range got {
+		if g.Vout != 0 || g.TxID != balances[0].TxID || g.Address != "bc1qtest" || g.Value != 546 || g.Height != 840000 {
+			t.Fatalf("Unexpected balance %+v", g)
+		}
+		if g.Rune == a && g.Amount != btc_rune.MaxUint128 {
+			t.Fatalf("Expected max amount to round trip - Got %s", g.Amount)
+		}
+	}
+
+	// Deleting vout 0 must leave vout 1 of the same tx alone
+	must(t, s.DeleteBalances([]*btc_rune.RuneBalance{balances[0]}))
+	got, err = s.Balances([]wire.OutPoint{{Hash: Hash(1), Index: 0}, {Hash: Hash(1), Index: 1}, {Hash: Hash(2), Index: 0}})
+	must(t, err)
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 balances left - Got %d", len(got))
+	}
+
+	got, err = s.AddressBalances("bc1qtest")
+	must(t, err)
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 balances held by the address - Got %d", len(got))
+	}
+
+	// An outpoint leaves the address once all its runes are gone
+	must(t, s.DeleteBalances([]*btc_rune.RuneBalance{balances[1]}))
+	other := Balance(3, 0, a, 50)
+	other.Address = "bc1qother"
+	must(t, s.CreateBalances([]*btc_rune.RuneBalance{other}))
+	got, err = s.AddressBalances("bc1qtest")
+	must(t, err)
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 balances held by the address - Got %d", len(got))
+	}
+	for _, g := range got {
+		if g.TxID == balances[0].TxID && g.Vout == 0 {
+			t.Fatalf("Expected spent outpoint to leave the address - Got %+v", g)
+		}
+	}
+	got, err = s.AddressBalances("bc1qother")
+	must(t, err)
+	if len(got) != 1 || got[0].Amount != btc_rune.NewUint128(50) {
+		t.Fatalf("Expected balance 3:0 of the other address - Got %+v", got)
+	}
+	got, err = s.AddressBalances("bc1qnone")
+	must(t, err)
+	if len(got) != 0 {
+		t.Fatalf("Expected no balances - Got %+v", got)
+	}
+
+	// Lookups larger than any single query chunk
+	outpoints := make([]wire.OutPoint, 2000)
+	for i := range outpoints {
+		outpoints[i] = wire.OutPoint{Hash: Hash(9), Index: uint32(i)}
+	}
+	outpoints = append(outpoints, wire.OutPoint{Hash: Hash(2), Index: 0})
+	got, err = s.Balances(outpoints)
+	must(t, err)
+	if len(got) != 1 || got[0].Amount != btc_rune.NewUint128(40) {
+		t.Fatalf("Expected balance 2:0 - Got %+v", got)
+	}
+}
+
+func testEvents(t *testing.T, s storage.Store) {
+	for i, typ := range []btc_rune.EventType{btc_rune.EventBlockConnected, btc_rune.EventReorg, btc_rune.EventBlockConnected} {
+		e := &btc_rune.Event{Type: typ, Height: int64(i), BlockHash: "hash", Data: []byte(`{"depth":1}`)}
+		must(t, s.CreateEvent(e))
+		if e.ID == 0 {
+			t.Fatal("Expected event id to be assigned")
+		}
+	}
+
+	all, err := s.Events(0, "", 10)
+	must(t, err)
+	if len(all) != 3 || all[0].ID >= all[1].ID || all[1].ID >= all[2].ID || string(all[1].Data) != `{"depth":1}` {
+		t.Fatalf("Expected 3 events in id order - Got %+v", all)
+	}
+
+	connected, err := s.Events(all[0].ID, btc_rune.EventBlockConnected, 10)
+	must(t, err)
+	if len(connected) != 1 || connected[0].ID != all[2].ID {
+		t.Fatalf("Expected the last connected event - Got %+v", connected)
+	}
+
+	limited, err := s.Events(0, "", 2)
+	must(t, err)
+	if len(limited) != 2 {
+		t.Fatalf("Expected 2 events - Got %d", len(limited))
+	}
+}
+
+func testBlockEvents(t *testing.T, s storage.Store) {
+	last, err := s.LastEvent()
+	must(t, err)
+	if last != nil {
+		t.Fatalf("Expected no last event - Got %+v", last)
+	}
+
+	create := func(typ btc_rune.EventType, height int64, hash string) *btc_rune.Event {
+		e := &btc_rune.Event{Type: typ, Height: height, BlockHash: hash}
+		must(t, s.CreateEvent(e))
+		return e
+	}
+	// Block a connected, disconnected and connected again, then block b
+	create(btc_rune.EventTransfer, 1, "a")
+	create(btc_rune.EventBlockConnected, 1, "a")
+	create(btc_rune.EventBlockDisconnected, 1, "a")
+	issuance := create(btc_rune.EventIssuance, 1, "a")
+	burn := create(btc_rune.EventBurn, 1, "a")
+	create(btc_rune.EventBlockConnected, 1, "a")
+	create(btc_rune.EventBlockConnected, 2, "b")
+	transfer := create(btc_rune.EventTransfer, 3, "c")
+
+	events, err := s.BlockEvents(1, "a")
+	must(t, err)
+	if len(events) != 2 || events[0].ID != issuance.ID || events[1].ID != burn.ID {
+		t.Fatalf("Expected the events of the last connect - Got %+v", events)
+	}
+
+	for _, block := range []struct {
+		height int64
+		hash   string
+	}{{2, "b"}, {1, "b"}, {3, "c"}} {
+		events, err = s.BlockEvents(block.height, block.hash)
+		must(t, err)
+		if len(events) != 0 {
+			t.Fatalf("Expected no events of %s at %d - Got %+v", block.hash, block.height, events)
+		}
+	}
+
+	last, err = s.LastEvent()
+	must(t, err)
+	if last == nil || last.ID != transfer.ID {
+		t.Fatalf("Expected last event %d - Got %+v", transfer.ID, last)
+	}
+}
+
+func testWebhooks(t *testing.T, s storage.Store) {
+	_, err := s.Webhook(1)
+	if err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound - Got %v", err)
+	}
+
+	runeA := btc_rune.RuneID{Block: 840000, Tx: 1}
+	hooks := []*btc_rune.Webhook{
+		{URL: "https://a.example", Secret: "a", Runes: []btc_rune.RuneID{runeA}, Types: []btc_rune.EventType{btc_rune.EventTransfer}},
+		{URL: "https://b.example", Secret: "b", Addresses: []string{"bc1qb"}},
+	}
+	for _, w := range hooks {
+		must(t, s.CreateWebhook(w))
+	}
+	if hooks[0].ID == 0 || hooks[1].ID <= hooks[0].ID {
+		t.Fatalf("Expected ids assigned in order - Got %d %d", hooks[0].ID, hooks[1].ID)
+	}
+
+	hooks[0].Cursor = 7
+	must(t, s.SaveWebhook(hooks[0]))
+	w, err := s.Webhook(hooks[0].ID)
+	must(t, err)
+	if w.Cursor != 7 || w.Secret != "a" || len(w.Runes) != 1 || w.Runes[0] != runeA || len(w.Types) != 1 || w.Types[0] != btc_rune.EventTransfer {
+		t.Fatalf("Expected saved webhook - Got %+v", w)
+	}
+	if err = s.SaveWebhook(&btc_rune.Webhook{ID: 99}); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound saving an unknown webhook - Got %v", err)
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	delivery := func(hook uint64, event uint64, due time.Duration) *btc_rune.Delivery {
+		return &btc_rune.Delivery{
+			Webhook:     hook,
+			Event:       event,
+			Type:        btc_rune.EventTransfer,
+			Payload:     []byte(`{"id":1}`),
+			Status:      btc_rune.DeliveryPending,
+			NextAttempt: now.Add(due),
+		}
+	}
+	deliveries := []*btc_rune.Delivery{
+		delivery(hooks[0].ID, 1, time.Second),
+		delivery(hooks[0].ID, 2, -time.Minute),
+		delivery(hooks[1].ID, 2, -time.Second),
+		delivery(hooks[0].ID, 3, time.Hour),
+	}
+	must(t, s.CreateDeliveries(deliveries))
+	must(t, s.CreateDeliveries(nil))
+	for i := 1; i < len(deliveries); i++ {
+		if deliveries[i].ID <= deliveries[i-1].ID {
+			t.Fatalf("Expected delivery ids assigned in order - Got %+v", deliveries)
+		}
+	}
+
+	due, err := s.DueDeliveries(now.Add(time.Second), 10)
+	must(t, err)
+	if len(due) != 3 || due[0].ID != deliveries[1].ID || due[1].ID != deliveries[2].ID || due[2].ID != deliveries[0].ID {
+		t.Fatalf("Expected 3 due deliveries most overdue first - Got %+v", due)
+	}
+	if string(due[0].Payload) != `{"id":1}` || !due[0].NextAttempt.Equal(now.Add(-time.Minute)) {
+		t.Fatalf("Expected stored delivery - Got %+v", due[0])
+	}
+
+	// Delivered and rescheduled deliveries leave the due set
+	delivered := deliveries[1]
+	delivered.Status = btc_rune.DeliveryDelivered
+	delivered.Attempts = 1
+	delivered.LastStatus = 200
+	delivered.DeliveredAt = &now
+	must(t, s.SaveDelivery(delivered))
+	retried := deliveries[2]
+	retried.Attempts = 1
+	retried.LastError = "timeout"
+	retried.NextAttempt = now.Add(time.Minute)
+	must(t, s.SaveDelivery(retried))
+
+	due, err = s.DueDeliveries(now.Add(time.Second), 10)
+	must(t, err)
+	if len(due) != 1 || due[0].ID != deliveries[0].ID {
+		t.Fatalf("Expected only the first delivery due - Got %+v", due)
+	}
+	due, err = s.DueDeliveries(now.Add(2*time.Hour), 1)
+	must(t, err)
+	if len(due) != 1 || due[0].ID != deliveries[0].ID {
+		t.Fatalf("Expected the limit applied - Got %+v", due)
+	}
+
+	history, err := s.Deliveries(hooks[0].ID, "", 0, 10)
+	must(t, err)
+	if len(history) != 3 || history[1].Status != btc_rune.DeliveryDelivered || history[1].LastStatus != 200 || history[1].DeliveredAt == nil {
+		t.Fatalf("Expected 3 deliveries in id order - Got %+v", history)
+	}
+	pending, err := s.Deliveries(hooks[0].ID, btc_rune.DeliveryPending, deliveries[0].ID, 10)
+	must(t, err)
+	if len(pending) != 1 || pending[0].ID != deliveries[3].ID {
+		t.Fatalf("Expected the last pending delivery - Got %+v", pending)
+	}
+
+	must(t, s.DeleteWebhook(hooks[0].ID))
+	if _, err = s.Webhook(hooks[0].ID); err != storage.ErrNotFound {
+		t.Fatalf("Expected deleted webhook - Got %v", err)
+	}
+	history, err = s.Deliveries(hooks[0].ID, "", 0, 10)
+	must(t, err)
+	if len(history) != 0 {
+		t.Fatalf("Expected deliveries deleted - Got %+v", history)
+	}
+	if err = s.SaveDelivery(deliveries[0]); err != storage.ErrNotFound {
+		t.Fatalf("Expected ErrNotFound saving a deleted delivery - Got %v", err)
+	}
+
+	due, err = s.DueDeliveries(now.Add(2*time.Hour), 10)
+	must(t, err)
+	if len(due) != 1 || due[0].ID != retried.ID || due[0].LastError != "timeout" {
+		t.Fatalf("Expected only the other webhook's delivery due - Got %+v", due)
+	}
+	remaining, err := s.Webhooks()
+	must(t, err)
+	if len(remaining) != 1 || remaining[0].ID != hooks[1].ID || remaining[0].Addresses[0] != "bc1qb" {
+		t.Fatalf("Expected the other webhook - Got %+v", remaining)
+	}
+}
+
+func testBlocks(t *testing.T, s storage.Store) {
+	tip, err := s.Tip()
+	must(t, err)
+	if tip != nil {
+		t.Fatalf("Expected no tip - Got %+v", tip)
+	}
+
+	for h := int64(0); h < 3; h++ {
+		must(t, s.CreateBlock(&btc_rune.Block{Height: h, Hash: Hash(byte(h)).String(), PrevHash: Hash(byte(h - 1)).String()}))
+	}
+
+	tip, err = s.Tip()
+	must(t, err)
+	if tip == nil || tip.Height != 2 || tip.Hash != Hash(2).String() || tip.PrevHash != Hash(1).String() {
+		t.Fatalf("Expected tip at 2 - Got %+v", tip)
+	}
+
+	must(t, s.DeleteBlock(2))
+	must(t, s.DeleteBlock(0))
+	for h, expected := range map[int64]bool{0: false, 1: true, 2: false} {
+		block, err := s.Block(h)
+		must(t, err)
+		if (block != nil) != expected {
+			t.Fatalf("%d: Expected block %v - Got %+v", h, expected, block)
+		}
+	}
+}
+
+func testUndo(t *testing.T, s storage.Store) {
+	var records []*btc_rune.UndoRecord
+	for h := int64(10); h < 13; h++ {
+		for op := btc_rune.UndoRestoreBalance; op <= btc_rune.UndoUnmint; op++ {
+			records = append(records, &btc_rune.UndoRecord{Height: h, Op: op, Data: []byte{byte(h), byte(op)}})
+		}
+	}
+	must(t, s.CreateUndo(records[:6]))
+	must(t, s.CreateUndo(records[6:]))
+	must(t, s.CreateUndo(nil))
+
+	got, err := s.Undo(11)
+	must(t, err)
+	if len(got) != 4 {
+		t.Fatalf("Expected 4 records at 11 - Got %d", len(got))
+	}
+	for i, r := range got {
+		if r.Op != btc_rune.UndoOp(i+1) || r.Data[0] != 11 || (i > 0 && r.ID <= got[i-1].ID) {
+			t.Fatalf("Expected records in id order - Got %+v", got)
+		}
+	}
+
+	must(t, s.PruneUndo(10))
+	must(t, s.DeleteUndo(12))
+	for h, expected := range map[int64]int{10: 0, 11: 4, 12: 0} {
+		got, err := s.Undo(h)
+		must(t, err)
+		if len(got) != expected {
+			t.Fatalf("%d: Expected %d records - Got %d", h, expected, len(got))
+		}
+	}
+}
+
+func testAtomic(t *testing.T, s storage.Store) {
+	id := btc_rune.RuneID{Block: 840000, Tx: 1}
+
+	err := s.Atomic(func(tx storage.Tx) error {
+		must(t, tx.CreateRune(&btc_rune.Rune{ID: id, Symbol: "ROLLEDBACK"}))
+		must(t, tx.CreateBalances([]*btc_rune.RuneBalance{Balance(1, 0, id, 1)}))
+		must(t, tx.CreateBlock(&btc_rune.Block{Height: 1, Hash: "rolledback"}))
+
+		// Reads within the transaction see its writes
+		r, err := tx.RuneBySymbol("ROLLEDBACK")
+		if err != nil || r.ID != id {
+			t.Fatalf("Expected uncommitted rune - Got %v", err)
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("Expected errAbort - Got %v", err)
+	}
+
+	if _, err = s.Rune(id); err != storage.ErrNotFound {
+		t.Fatalf("Expected rolled back rune - Got %v", err)
+	}
+	tip, err := s.Tip()
+	must(t, err)
+	if tip != nil {
+		t.Fatalf("Expected rolled back block - Got %+v", tip)
+	}
+
+	must(t, s.Atomic(func(tx storage.Tx) error {
+		must(t, tx.CreateBlock(&btc_rune.Block{Height: 1, Hash: "committed"}))
+		return tx.CreateEvent(&btc_rune.Event{Type: btc_rune.EventBlockConnected, Height: 1})
+	}))
+	tip, err = s.Tip()
+	must(t, err)
+	if tip == nil || tip.Hash != "committed" {
+		t.Fatalf("Expected committed block - Got %+v", tip)
+	}
+}
+
+func testWriteBlock(t *testing.T, s storage.Store) {
+	id := btc_rune.RuneID{Block: 840000, Tx: 1}
+	spent := Balance(1, 0, id, 10)
+	must(t, s.CreateBalances([]*btc_rune.RuneBalance{spent}))
+	must(t, s.CreateUndo([]*btc_rune.UndoRecord{{Height: 1, Op: btc_rune.UndoUnmint}}))
+
+	write := func(height int64, hash string) *storage.BlockWrite {
+		return &storage.BlockWrite{
+			Block:     &btc_rune.Block{Height: height, Hash: hash},
+			Spent:     []*btc_rune.RuneBalance{spent},
+			Credits:   []*btc_rune.RuneBalance{Balance(2, 1, id, 10)},
+			Undo:      []*btc_rune.UndoRecord{{Height: height, Op: btc_rune.UndoDeleteBalance}},
+			Events:    []*btc_rune.Event{{Type: btc_rune.EventBlockConnected, Height: height, BlockHash: hash}},
+			UndoDepth: 2,
+		}
+	}
+
+	// A block failing after its writes leaves nothing behind
+	err := s.Atomic(func(tx storage.Tx) error {
+		must(t, storage.WriteBlock(tx, write(3, "aborted")))
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("Expected errAbort - Got %v", err)
+	}
+	tip, err := s.Tip()
+	must(t, err)
+	balances, err := s.Balances([]wire.OutPoint{{Hash: Hash(1), Index: 0}, {Hash: Hash(2), Index: 1}})
+	must(t, err)
+	events, err := s.Events(0, "", 10)
+	must(t, err)
+	if tip != nil || len(balances) != 1 || balances[0].TxID != spent.TxID || len(events) != 0 {
+		t.Fatalf("Expected aborted block to leave no trace - Got tip %+v, %d balances, %d events", tip, len(balances), len(events))
+	}
+
+	must(t, s.Atomic(func(tx storage.Tx) error {
+		return storage.WriteBlock(tx, write(3, "written"))
+	}))
+	tip, err = s.Tip()
+	must(t, err)
+	if tip == nil || tip.Hash != "written" {
+		t.Fatalf("Expected written tip - Got %+v", tip)
+	}
+	balances, err = s.Balances([]wire.OutPoint{{Hash: Hash(1), Index: 0}, {Hash: Hash(2), Index: 1}})
+	must(t, err)
+	if len(balances) != 1 || balances[0].TxID != Balance(2, 1, id, 10).TxID {
+		t.Fatalf("Expected spent balance replaced by credit - Got %+v", balances)
+	}
+	undo, err := s.Undo(3)
+	must(t, err)
+	pruned, err := s.Undo(1)
+	must(t, err)
+	if len(undo) != 1 || len(pruned) != 0 {
+		t.Fatalf("Expected undo at 3 and journal at 1 pruned - Got %d and %d", len(undo), len(pruned))
+	}
+	events, err = s.Events(0, "", 10)
+	must(t, err)
+	if len(events) != 1 || events[0].BlockHash != "written" {
+		t.Fatalf("Expected block event - Got %+v", events)
+	}
+}
+
+func testMigrations(t *testing.T, s storage.Store) {
+	latest := s.LatestSchema()
+	version, err := s.SchemaVersion()
+	must(t, err)
+	if latest < 1 || version != latest {
+		t.Fatalf("Expected schema at latest %d - Got %d", latest, version)
+	}
+	must(t, s.CreateBlock(&btc_rune.Block{Height: 1, Hash: "dropped"}))
+
+	if err = s.MigrateTo(latest + 1); err == nil {
+		t.Fatalf("Expected unknown version error")
+	}
+
+	// Down to empty and back up leaves a fresh, usable schema
+	must(t, s.MigrateTo(0))
+	version, err = s.SchemaVersion()
+	must(t, err)
+	if version != 0 {
+		t.Fatalf("Expected version 0 - Got %d", version)
+	}
+
+	must(t, s.Migrate())
+	must(t, s.Migrate())
+	version, err = s.SchemaVersion()
+	must(t, err)
+	if version != latest {
+		t.Fatalf("Expected version %d - Got %d", latest, version)
+	}
+
+	tip, err := s.Tip()
+	must(t, err)
+	if tip != nil {
+		t.Fatalf("Expected empty index after down migration - Got %+v", tip)
+	}
+	must(t, s.CreateBlock(&btc_rune.Block{Height: 1, Hash: "migrated"}))
+}
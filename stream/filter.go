@@ -0,0 +1,153 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/mempool"
+)
+
+// Filter narrows a stream to the events of some runes or addresses, and optionally of some types.
+// Block, disconnect and reorg events pass unless excluded by type, they tell subscribers where the chain is.
+// A reverted event passes when the event it reverts would.
+type Filter struct {
+	Runes     map[btc_rune.RuneID]bool
+	Addresses map[string]bool
+	// Types is empty for every type, a reverted event passes by its own type or that of the event it reverts
+	Types map[btc_rune.EventType]bool
+
+	// pending holds the mempool transactions passed, so their removal passes too
+	pending map[string]bool
+}
+
+// mempoolRemoved is the data of an EventMempoolRemove
+type mempoolRemoved struct {
+	TxID string `json:"txid"`
+}
+
+// NewFilter returns a filter passing the events of any of runes or addresses, every event when both are empty,
+// of any of types
+func NewFilter(runes []btc_rune.RuneID, addresses []string, types []btc_rune.EventType) *Filter {
+	f := &Filter{
+		Runes:     map[btc_rune.RuneID]bool{},
+		Addresses: map[string]bool{},
+		Types:     map[btc_rune.EventType]bool{},
+		pending:   map[string]bool{},
+	}
+	for _, t := range types {
+		f.Types[t] = true
+	}
+	for _, id := range runes {
+		f.Runes[id] = true
+	}
+	for _, address := range addresses {
+		f.Addresses[address] = true
+	}
+	return f
+}
+
+// Match reports whether e passes the filter
+func (f *Filter) Match(e *btc_rune.Event) (bool, error) {
+	inner := e
+	if e.Type == btc_rune.EventReverted {
+		var d btc_rune.RevertedData
+		err := json.Unmarshal(e.Data, &d)
+		if err != nil {
+			return false, err
+		}
+		if d.Event == nil {
+			return false, fmt.Errorf("reverted event %d reverts nothing", e.ID)
+		}
+		inner = d.Event
+	}
+
+	if len(f.Types) > 0 && !f.Types[e.Type] && !f.Types[inner.Type] {
+		return false, nil
+	}
+	return f.match(inner)
+}
+
+func (f *Filter) match(e *btc_rune.Event) (bool, error) {
+	all := len(f.Runes) == 0 && len(f.Addresses) == 0
+
+	switch e.Type {
+	case btc_rune.EventIssuance, btc_rune.EventTransfer, btc_rune.EventBurn:
+		if all {
+			return true, nil
+		}
+		var d btc_rune.TxEventData
+		err := json.Unmarshal(e.Data, &d)
+		if err != nil {
+			return false, err
+		}
+		if f.Runes[d.Rune] {
+			return true, nil
+		}
+		for address := range f.Addresses {
+			if d.Involves(address) {
+				return true, nil
+			}
+		}
+		return false, nil
+
+	case btc_rune.EventMempoolAdd:
+		if all {
+			return true, nil
+		}
+		var entry mempool.Entry
+		err := json.Unmarshal(e.Data, &entry)
+		if err != nil {
+			return false, err
+		}
+		if f.matchEntry(&entry) {
+			f.pending[entry.TxID] = true
+			return true, nil
+		}
+		return false, nil
+
+	case btc_rune.EventMempoolRemove:
+		if all {
+			return true, nil
+		}
+		var d mempoolRemoved
+		err := json.Unmarshal(e.Data, &d)
+		if err != nil {
+			return false, err
+		}
+		if f.pending[d.TxID] {
+			delete(f.pending, d.TxID)
+			return true, nil
+		}
+		return false, nil
+
+	default:
+		return true, nil
+	}
+}
+
+func (f *Filter) matchEntry(e *mempool.Entry) bool {
+	for address := range f.Addresses {
+		if e.Involves(address) {
+			return true
+		}
+	}
+	for _, balances := range [][]*btc_rune.RuneBalance{e.Inputs, e.Outputs} {
+		for _, b := range balances {
+			if f.Runes[b.Rune] {
+				return true
+			}
+		}
+	}
+	for id := range e.Burned {
+		if f.Runes[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// MempoolRemoveData returns the data of the EventMempoolRemove of txid
+func MempoolRemoveData(txid string) (json.RawMessage, error) {
+	return json.Marshal(&mempoolRemoved{TxID: txid})
+}
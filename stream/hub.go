@@ -0,0 +1,93 @@
+// Package stream fans the index events out to live subscribers.
+//
+// Stored events are identified by their ID, a Token pairs it with the event's height
+// so a reconnecting subscriber resumes right after the last event it received.
+package stream
+
+import (
+	"sync"
+
+	"github.com/alphabatem/btc_rune"
+)
+
+// Hub publishes events to every subscription, it never blocks the publisher:
+// a subscription whose buffer is full is dropped and its channel closed.
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]bool
+	buffer int
+}
+
+// Subscription receives the events published after it was made, in order
+type Subscription struct {
+	C <-chan *btc_rune.Event
+
+	c       chan *btc_rune.Event
+	hub     *Hub
+	dropped bool
+}
+
+// NewHub returns a hub buffering up to buffer events per subscription
+func NewHub(buffer int) *Hub {
+	return &Hub{
+		subs:   map[*Subscription]bool{},
+		buffer: buffer,
+	}
+}
+
+// Subscribe starts receiving published events
+func (h *Hub) Subscribe() *Subscription {
+	c := make(chan *btc_rune.Event, h.buffer)
+	s := &Subscription{C: c, c: c, hub: h}
+
+	h.mu.Lock()
+	h.subs[s] = true
+	h.mu.Unlock()
+	return s
+}
+
+// Publish sends events to every subscription
+func (h *Hub) Publish(events ...*btc_rune.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subs {
+		for _, e := range events {
+			select {
+			case s.c <- e:
+				continue
+			default:
+			}
+
+			s.dropped = true
+			close(s.c)
+			delete(h.subs, s)
+			break
+		}
+	}
+}
+
+// Len returns the number of subscriptions
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// Dropped reports whether the subscription fell behind and was dropped, once C is closed
+func (s *Subscription) Dropped() bool {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.dropped
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if s.hub.subs[s] {
+		delete(s.hub.subs, s)
+		close(s.c)
+	}
+}
@@ -0,0 +1,132 @@
+package stream
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/alphabatem/btc_rune"
+	"github.com/alphabatem/btc_rune/mempool"
+)
+
+func TestHubDropsSlowSubscriber(t *testing.T) {
+	h := NewHub(2)
+	fast := h.Subscribe()
+	slow := h.Subscribe()
+
+	h.Publish(&btc_rune.Event{ID: 1}, &btc_rune.Event{ID: 2})
+	for _, id := range []uint64{1, 2} {
+		if e := <-fast.C; e.ID != id {
+			t.Fatalf("Expected event %d - Got %d", id, e.ID)
+		}
+	}
+
+	h.Publish(&btc_rune.Event{ID: 3})
+	if e := <-fast.C; e.ID != 3 {
+		t.Fatalf("Expected event 3 - Got %d", e.ID)
+	}
+
+	// slow still holds 1 and 2, then its channel closes
+	<-slow.C
+	<-slow.C
+	if _, ok := <-slow.C; ok || !slow.Dropped() {
+		t.Fatalf("Expected slow subscriber dropped")
+	}
+	if fast.Dropped() || h.Len() != 1 {
+		t.Fatalf("Expected fast subscriber kept - Got %d subscriptions", h.Len())
+	}
+
+	fast.Close()
+	fast.Close()
+	if _, ok := <-fast.C; ok || fast.Dropped() || h.Len() != 0 {
+		t.Fatalf("Expected closed subscription")
+	}
+}
+
+func TestParseToken(t *testing.T) {
+	token, err := ParseToken("840000:17")
+	if err != nil || token != (Token{Height: 840000, ID: 17}) || token.String() != "840000:17" {
+		t.Fatalf("Expected 840000:17 - Got %v %v", token, err)
+	}
+	for _, s := range []string{"", "840000", "a:1", "1:b", "-1:2", "1:-2"} {
+		if _, err = ParseToken(s); err != ErrInvalidToken {
+			t.Fatalf("Expected ErrInvalidToken for %q - Got %v", s, err)
+		}
+	}
+	if _, ok := EventToken(&btc_rune.Event{Type: btc_rune.EventMempoolAdd}); ok {
+		t.Fatalf("Expected no token for an unstored event")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	runeA := btc_rune.RuneID{Block: 1, Tx: 1}
+	runeB := btc_rune.RuneID{Block: 2, Tx: 2}
+	event := func(eventType btc_rune.EventType, v interface{}) *btc_rune.Event {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &btc_rune.Event{Type: eventType, Data: data}
+	}
+	removed := func(txid string) *btc_rune.Event {
+		data, err := MempoolRemoveData(txid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &btc_rune.Event{Type: btc_rune.EventMempoolRemove, Data: data}
+	}
+
+	transferA := event(btc_rune.EventTransfer, &btc_rune.TxEventData{Rune: runeA, To: []*btc_rune.Movement{{Address: "carol"}}})
+	burnB := event(btc_rune.EventBurn, &btc_rune.TxEventData{Rune: runeB, From: []string{"alice"}})
+	pendingA := event(btc_rune.EventMempoolAdd, &mempool.Entry{TxID: "a", Outputs: []*btc_rune.RuneBalance{{Rune: runeA}}})
+	pendingB := event(btc_rune.EventMempoolAdd, &mempool.Entry{TxID: "b", Inputs: []*btc_rune.RuneBalance{{Rune: runeB, Address: "bob"}}})
+	block := &btc_rune.Event{Type: btc_rune.EventBlockConnected}
+	revertedA := event(btc_rune.EventReverted, &btc_rune.RevertedData{Event: transferA})
+	revertedB := event(btc_rune.EventReverted, &btc_rune.RevertedData{Event: burnB})
+
+	for name, c := range map[string]struct {
+		filter *Filter
+		events []*btc_rune.Event
+		want   []bool
+	}{
+		"all": {
+			NewFilter(nil, nil, nil),
+			[]*btc_rune.Event{transferA, burnB, pendingB, removed("b"), block},
+			[]bool{true, true, true, true, true},
+		},
+		"rune": {
+			NewFilter([]btc_rune.RuneID{runeA}, nil, nil),
+			[]*btc_rune.Event{transferA, burnB, pendingA, pendingB, removed("b"), removed("a"), removed("a"), block},
+			[]bool{true, false, true, false, false, true, false, true},
+		},
+		"address": {
+			NewFilter(nil, []string{"alice", "bob"}, nil),
+			[]*btc_rune.Event{transferA, burnB, pendingA, pendingB, removed("a"), removed("b"), block},
+			[]bool{false, true, false, true, false, true, true},
+		},
+		"reverted": {
+			NewFilter([]btc_rune.RuneID{runeA}, nil, nil),
+			[]*btc_rune.Event{revertedA, revertedB},
+			[]bool{true, false},
+		},
+		"types": {
+			NewFilter(nil, nil, []btc_rune.EventType{btc_rune.EventTransfer}),
+			[]*btc_rune.Event{transferA, burnB, revertedA, revertedB, pendingA, block},
+			[]bool{true, false, true, false, false, false},
+		},
+		"reverted type": {
+			NewFilter(nil, []string{"carol"}, []btc_rune.EventType{btc_rune.EventReverted}),
+			[]*btc_rune.Event{transferA, revertedA, revertedB, block},
+			[]bool{false, true, false, false},
+		},
+	} {
+		for i, e := range c.events {
+			ok, err := c.filter.Match(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != c.want[i] {
+				t.Fatalf("%s: Expected event %d (%s) match %v - Got %v", name, i, e.Type, c.want[i], ok)
+			}
+		}
+	}
+}
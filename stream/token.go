@@ -0,0 +1,51 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alphabatem/btc_rune"
+)
+
+// ErrInvalidToken is returned for a resume token that does not parse
+var ErrInvalidToken = errors.New("invalid resume token")
+
+// Token marks a stored event as HEIGHT:ID, streams resume right after it
+type Token struct {
+	Height int64
+	ID     uint64
+}
+
+// EventToken returns the token of a stored event, false for events that are never stored
+func EventToken(e *btc_rune.Event) (Token, bool) {
+	if e.ID == 0 {
+		return Token{}, false
+	}
+	return Token{Height: e.Height, ID: e.ID}, true
+}
+
+// ParseToken parses the HEIGHT:ID form returned by Token.String
+func ParseToken(s string) (Token, error) {
+	height, id, ok := strings.Cut(s, ":")
+	if !ok {
+		return Token{}, ErrInvalidToken
+	}
+
+	h, err := strconv.ParseInt(height, 10, 64)
+	if err != nil || h < 0 {
+		return Token{}, ErrInvalidToken
+	}
+
+	i, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return Token{}, ErrInvalidToken
+	}
+
+	return Token{Height: h, ID: i}, nil
+}
+
+func (t Token) String() string {
+	return fmt.Sprintf("%d:%d", t.Height, t.ID)
+}
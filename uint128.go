@@ -0,0 +1,218 @@
+package btc_rune
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	ErrUint128Overflow  = errors.New("uint128 overflow")
+	ErrUint128Underflow = errors.New("uint128 underflow")
+	ErrUint128Invalid   = errors.New("invalid uint128")
+)
+
+// Uint128 is an unsigned 128 bit rune amount.
+//
+// Arithmetic is checked and fails rather than wrapping. Values serialize to JSON
+// and the database as decimal strings so no client loses precision.
+type Uint128 struct {
+	Hi uint64
+	Lo uint64
+}
+
+// MaxUint128 is the largest representable amount
+var MaxUint128 = Uint128{Hi: ^uint64(0), Lo: ^uint64(0)}
+
+// NewUint128 returns v as a Uint128
+func NewUint128(v uint64) Uint128 {
+	return Uint128{Lo: v}
+}
+
+// Uint128FromBig converts a non-negative big.Int of at most 128 bits
+func Uint128FromBig(v *big.Int) (Uint128, error) {
+	if v.Sign() < 0 {
+		return Uint128{}, ErrUint128Underflow
+	}
+	if v.BitLen() > 128 {
+		return Uint128{}, ErrUint128Overflow
+	}
+
+	lo := new(big.Int).And(v, new(big.Int).SetUint64(^uint64(0)))
+	hi := new(big.Int).Rsh(v, 64)
+	return Uint128{Hi: hi.Uint64(), Lo: lo.Uint64()}, nil
+}
+
+// ParseUint128 parses a base 10 string
+func ParseUint128(s string) (Uint128, error) {
+	v, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return Uint128{}, fmt.Errorf("%w: %q", ErrUint128Invalid, s)
+	}
+	return Uint128FromBig(v)
+}
+
+func (u Uint128) IsZero() bool {
+	return u.Hi == 0 && u.Lo == 0
+}
+
+// IsUint64 reports whether u fits in a uint64
+func (u Uint128) IsUint64() bool {
+	return u.Hi == 0
+}
+
+// Cmp returns -1, 0 or +1 as u is less than, equal to or greater than v
+func (u Uint128) Cmp(v Uint128) int {
+	switch {
+	case u.Hi < v.Hi || (u.Hi == v.Hi && u.Lo < v.Lo):
+		return -1
+	case u == v:
+		return 0
+	default:
+		return 1
+	}
+}
+
+// Add returns u+v or ErrUint128Overflow
+func (u Uint128) Add(v Uint128) (Uint128, error) {
+	lo, carry := bits.Add64(u.Lo, v.Lo, 0)
+	hi, carry := bits.Add64(u.Hi, v.Hi, carry)
+	if carry != 0 {
+		return Uint128{}, ErrUint128Overflow
+	}
+	return Uint128{Hi: hi, Lo: lo}, nil
+}
+
+// Sub returns u-v or ErrUint128Underflow
+func (u Uint128) Sub(v Uint128) (Uint128, error) {
+	lo, borrow := bits.Sub64(u.Lo, v.Lo, 0)
+	hi, borrow := bits.Sub64(u.Hi, v.Hi, borrow)
+	if borrow != 0 {
+		return Uint128{}, ErrUint128Underflow
+	}
+	return Uint128{Hi: hi, Lo: lo}, nil
+}
+
+// Mul64 returns u*v or ErrUint128Overflow
+func (u Uint128) Mul64(v uint64) (Uint128, error) {
+	hiHi, hiLo := bits.Mul64(u.Hi, v)
+	carry, lo := bits.Mul64(u.Lo, v)
+	hi, c := bits.Add64(hiLo, carry, 0)
+	if hiHi != 0 || c != 0 {
+		return Uint128{}, ErrUint128Overflow
+	}
+	return Uint128{Hi: hi, Lo: lo}, nil
+}
+
+// Div64 returns u/v and the remainder, v must not be zero
+func (u Uint128) Div64(v uint64) (Uint128, uint64) {
+	hi, r := bits.Div64(0, u.Hi, v)
+	lo, r := bits.Div64(r, u.Lo, v)
+	return Uint128{Hi: hi, Lo: lo}, r
+}
+
+// Min returns the smaller of u and v
+func (u Uint128) Min(v Uint128) Uint128 {
+	if u.Cmp(v) <= 0 {
+		return u
+	}
+	return v
+}
+
+// Big returns u as a big.Int
+func (u Uint128) Big() *big.Int {
+	v := new(big.Int).SetUint64(u.Hi)
+	v.Lsh(v, 64)
+	return v.Or(v, new(big.Int).SetUint64(u.Lo))
+}
+
+func (u Uint128) String() string {
+	if u.Hi == 0 {
+		return fmt.Sprintf("%d", u.Lo)
+	}
+	return u.Big().String()
+}
+
+// Decimal formats u in units of 10^-decimals, the way a rune's divisibility displays it.
+// Trailing fractional zeros are dropped, 1050 with 2 decimals is "10.5".
+func (u Uint128) Decimal(decimals uint64) string {
+	s := u.String()
+	if decimals == 0 {
+		return s
+	}
+	if uint64(len(s)) <= decimals {
+		s = strings.Repeat("0", int(decimals)-len(s)+1) + s
+	}
+
+	point := uint64(len(s)) - decimals
+	whole, frac := s[:point], strings.TrimRight(s[point:], "0")
+	if frac == "" {
+		return whole
+	}
+	return whole + "." + frac
+}
+
+func (u Uint128) MarshalJSON() ([]byte, error) {
+	return json.Marshal(u.String())
+}
+
+// UnmarshalJSON accepts both decimal strings and bare JSON numbers
+func (u *Uint128) UnmarshalJSON(data []byte) error {
+	var s string
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	} else {
+		s = string(data)
+	}
+
+	v, err := ParseUint128(s)
+	if err != nil {
+		return err
+	}
+	*u = v
+	return nil
+}
+
+// Value stores u as a decimal string
+func (u Uint128) Value() (driver.Value, error) {
+	return u.String(), nil
+}
+
+// GormDBDataType stores amounts as exact numerics where the database has them,
+// other dialects keep the decimal string
+func (Uint128) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	if db.Dialector.Name() == "postgres" {
+		return "numeric(39,0)"
+	}
+	return ""
+}
+
+// Scan reads a decimal string or integer column
+func (u *Uint128) Scan(src interface{}) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*u = Uint128{}
+	case string:
+		*u, err = ParseUint128(v)
+	case []byte:
+		*u, err = ParseUint128(string(v))
+	case int64:
+		if v < 0 {
+			return ErrUint128Underflow
+		}
+		*u = NewUint128(uint64(v))
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrUint128Invalid, src)
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+package btc_rune
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+	"testing/quick"
+)
+
+func TestUint128Arithmetic(t *testing.T) {
+	one := NewUint128(1)
+
+	sum, err := NewUint128(^uint64(0)).Add(one)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != (Uint128{Hi: 1}) {
+		t.Fatalf("Expected carry into Hi - Got %+v", sum)
+	}
+
+	if _, err := MaxUint128.Add(one); err != ErrUint128Overflow {
+		t.Fatalf("Expected overflow - Got %v", err)
+	}
+
+	if _, err := one.Sub(NewUint128(2)); err != ErrUint128Underflow {
+		t.Fatalf("Expected underflow - Got %v", err)
+	}
+
+	if _, err := MaxUint128.Mul64(2); err != ErrUint128Overflow {
+		t.Fatalf("Expected overflow - Got %v", err)
+	}
+
+	q, r := MaxUint128.Div64(10)
+	if q.String() != "34028236692093846346337460743176821145" || r != 5 {
+		t.Fatalf("Unexpected division %s r %d", q, r)
+	}
+}
+
+func TestUint128MatchesBig(t *testing.T) {
+	max := MaxUint128.Big()
+
+	check := func(a, b Uint128, m uint64) bool {
+		sum := new(big.Int).Add(a.Big(), b.Big())
+		got, err := a.Add(b)
+		if (sum.Cmp(max) > 0) != (err != nil) || (err == nil && got.Big().Cmp(sum) != 0) {
+			return false
+		}
+
+		diff := new(big.Int).Sub(a.Big(), b.Big())
+		got, err = a.Sub(b)
+		if (diff.Sign() < 0) != (err != nil) || (err == nil && got.Big().Cmp(diff) != 0) {
+			return false
+		}
+
+		prod := new(big.Int).Mul(a.Big(), new(big.Int).SetUint64(m))
+		got, err = a.Mul64(m)
+		if (prod.Cmp(max) > 0) != (err != nil) || (err == nil && got.Big().Cmp(prod) != 0) {
+			return false
+		}
+
+		return a.Cmp(b) == a.Big().Cmp(b.Big())
+	}
+
+	if err := quick.Check(check, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUint128JSON(t *testing.T) {
+	v := MaxUint128
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `"340282366920938463463374607431768211455"` {
+		t.Fatalf("Unexpected JSON %s", out)
+	}
+
+	var back Uint128
+	if err := json.Unmarshal(out, &back); err != nil || back != v {
+		t.Fatalf("Round trip failed %v %+v", err, back)
+	}
+
+	if err := json.Unmarshal([]byte(`42`), &back); err != nil || back != NewUint128(42) {
+		t.Fatalf("Bare number failed %v %+v", err, back)
+	}
+
+	if err := json.Unmarshal([]byte(`"340282366920938463463374607431768211456"`), &back); err == nil {
+		t.Fatal("Expected overflow")
+	}
+}
+
+func TestUint128Decimal(t *testing.T) {
+	for _, tt := range []struct {
+		amount   Uint128
+		decimals uint64
+		want     string
+	}{
+		{NewUint128(1050), 0, "1050"},
+		{NewUint128(1050), 2, "10.5"},
+		{NewUint128(1000), 3, "1"},
+		{NewUint128(5), 3, "0.005"},
+		{NewUint128(0), 2, "0"},
+		{NewUint128(123), 3, "0.123"},
+		{MaxUint128, 38, "3.40282366920938463463374607431768211455"},
+	} {
+		if got := tt.amount.Decimal(tt.decimals); got != tt.want {
+			t.Fatalf("%s with %d decimals: Expected %s - Got %s", tt.amount, tt.decimals, tt.want, got)
+		}
+	}
+}
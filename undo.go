@@ -0,0 +1,59 @@
+package btc_rune
+
+import "encoding/json"
+
+// UndoOp is the mutation an UndoRecord performs to revert a block
+type UndoOp uint8
+
+const (
+	// UndoRestoreBalance re-creates a balance the block spent
+	UndoRestoreBalance UndoOp = iota + 1
+	// UndoDeleteBalance deletes a balance the block credited
+	UndoDeleteBalance
+	// UndoDeleteRune unregisters a rune the block etched
+	UndoDeleteRune
+	// UndoUnmint takes back a mint the block counted
+	UndoUnmint
+	// UndoRestoreStats puts back the stats a rune had before the block
+	UndoRestoreStats
+	// UndoRestoreHolding puts back what an address held of a rune before the block
+	UndoRestoreHolding
+)
+
+// UndoRecord reverts one ledger mutation made while applying the block at Height.
+// Records of a block are reverted in reverse ID order.
+type UndoRecord struct {
+	ID     uint64 `gorm:"primaryKey"`
+	Height int64  `gorm:"index"`
+	Op     UndoOp
+	// Data is the JSON encoded balance, rune id, stats or holding the op applies to
+	Data []byte
+}
+
+// Balance decodes the balance of a balance op
+func (r *UndoRecord) Balance() (*RuneBalance, error) {
+	var b RuneBalance
+	err := json.Unmarshal(r.Data, &b)
+	return &b, err
+}
+
+// Stats decodes the stats of a stats op
+func (r *UndoRecord) Stats() (*RuneStats, error) {
+	var s RuneStats
+	err := json.Unmarshal(r.Data, &s)
+	return &s, err
+}
+
+// Holding decodes the holding of a holding op
+func (r *UndoRecord) Holding() (*Holding, error) {
+	var h Holding
+	err := json.Unmarshal(r.Data, &h)
+	return &h, err
+}
+
+// RuneID decodes the rune of a rune op
+func (r *UndoRecord) RuneID() (RuneID, error) {
+	var id RuneID
+	err := json.Unmarshal(r.Data, &id)
+	return id, err
+}
@@ -1,119 +1,127 @@
+// Package util holds the prefix varint, a compact integer encoding where the first byte gives the length.
+// Runestones are LEB128 encoded by the protocol, see the codec package, so the rune decode paths do not use it.
 package util
 
 import (
 	"bytes"
 	"encoding/binary"
 	"errors"
-	"fmt"
+	"io"
 	"math/big"
+	"math/bits"
 )
 
-const PREFIX_VARINT_BUF_SIZE = 9
+// PREFIX_VARINT_MAX_BITS is the widest value a prefix varint holds
+const PREFIX_VARINT_MAX_BITS = 128
 
-func countLeadingZeros(input string) int {
-	count := 0
-	for _, ch := range input {
-		if ch != '0' {
-			break
-		}
-		count++
+// PREFIX_VARINT_MAX_LEN is the longest prefix varint, 0xff, a length byte and 16 little endian bytes
+const PREFIX_VARINT_MAX_LEN = 2 + PREFIX_VARINT_MAX_BITS/8
+
+var (
+	ErrPrefixVarintNegative  = errors.New("prefix varint value is negative")
+	ErrPrefixVarintOverflow  = errors.New("prefix varint value exceeds 128 bits")
+	ErrPrefixVarintTruncated = errors.New("prefix varint truncated")
+	ErrPrefixVarintLength    = errors.New("prefix varint extended length out of range")
+)
+
+// PrefixVarintLen returns the number of bytes EncodePrefixVarint writes for value
+func PrefixVarintLen(value *big.Int) int {
+	n := (value.BitLen() + 6) / 7
+	switch {
+	case n == 0:
+		return 1
+	case n <= 8:
+		return n
+	default:
+		return 2 + (value.BitLen()+7)/8
 	}
-	return count
 }
 
-func EncodePrefixVarint(value *big.Int, buf *bytes.Buffer) int {
-	leadingZeros := countLeadingZeros(fmt.Sprintf("%064b", value))
-	bytesRequired := 1
+// EncodePrefixVarint writes value to buf and returns the number of bytes written.
+//
+// Values of up to 56 bits are written in at most 8 bytes: the number of leading
+// ones in the first byte is the number of bytes that follow, the remaining bits
+// of the first byte hold the low bits of value and the following bytes hold the
+// rest little endian. Wider values use the extended form, 0xff followed by a
+// length byte of 8 to 16 and that many little endian bytes, so values of more
+// than 128 bits cannot be encoded. value is not modified.
+func EncodePrefixVarint(value *big.Int, buf *bytes.Buffer) (int, error) {
+	if value.Sign() < 0 {
+		return 0, ErrPrefixVarintNegative
+	}
+	if value.BitLen() > PREFIX_VARINT_MAX_BITS {
+		return 0, ErrPrefixVarintOverflow
+	}
 
-	thresholds := []int{7, 14, 21, 28, 35, 42, 49, 56}
-	for i, threshold := range thresholds {
-		if leadingZeros <= threshold {
-			bytesRequired = PREFIX_VARINT_BUF_SIZE - i
-			break
+	n := PrefixVarintLen(value)
+
+	var out [PREFIX_VARINT_MAX_LEN]byte
+	if n > 8 {
+		out[0] = 0xff
+		out[1] = byte(n - 2)
+		value.FillBytes(out[2:n])
+		reverse(out[2:n])
+	} else {
+		v := value.Uint64()
+		extra := uint(n - 1)
+		lowBits := 7 - extra
+		out[0] = ^byte(0xff>>extra) | byte(v&(1<<lowBits-1))
+
+		rest := v >> lowBits
+		for i := 1; i < n; i++ {
+			out[i] = byte(rest)
+			rest >>= 8
 		}
 	}
 
-	switch bytesRequired {
-	case 9:
-		buf.WriteByte(255)
-		_ = binary.Write(buf, binary.LittleEndian, value.Bytes())
-		return bytesRequired
-
-	case 8:
-		buf.WriteByte(254)
-		for i := 1; i <= 7; i++ {
-			shift := 8 * (i - 1)
-			b := big.NewInt(0).Rsh(value, uint(shift)).And(value, big.NewInt(0xFF))
-			buf.WriteByte(byte(b.Int64()))
-		}
-		return bytesRequired
+	buf.Write(out[:n])
+	return n, nil
+}
+
+// DecodePrefixVarint reads one prefix varint from buf.
+// A varint cut short returns ErrPrefixVarintTruncated.
+func DecodePrefixVarint(buf *bytes.Buffer) (*big.Int, error) {
+	first, err := buf.ReadByte()
+	if err != nil {
+		return nil, err
+	}
 
-	case 1:
-		buf.WriteByte(byte(value.Int64() & 0xFF))
-		return bytesRequired
+	extra := bits.LeadingZeros8(^first)
+	if extra == 8 {
+		return decodeExtended(buf)
+	}
 
-	default:
-		prefixMask := 256 - (1 << (PREFIX_VARINT_BUF_SIZE - bytesRequired))
-		value.Lsh(value, uint(bytesRequired))
-		b := big.NewInt(0).Rsh(value, uint(bytesRequired)).Or(value, big.NewInt(int64(prefixMask)))
-		buf.WriteByte(byte(b.Int64()))
-		for i := 1; i < bytesRequired; i++ {
-			shift := 8 * i
-			b := big.NewInt(0).Rsh(value, uint(shift)).And(value, big.NewInt(0xFF))
-			buf.WriteByte(byte(b.Int64()))
-		}
-		return bytesRequired
+	var rest [8]byte
+	if _, err := io.ReadFull(buf, rest[:extra]); err != nil {
+		return nil, ErrPrefixVarintTruncated
 	}
+	high := binary.LittleEndian.Uint64(rest[:])
+
+	lowBits := uint(7 - extra)
+	low := uint64(first) & (1<<lowBits - 1)
+	return new(big.Int).SetUint64(high<<lowBits | low), nil
 }
 
-func DecodePrefixVarint(buf *bytes.Buffer) (*big.Int, error) {
-	firstByte, err := buf.ReadByte()
+// decodeExtended reads the length byte and little endian bytes following a 0xff prefix
+func decodeExtended(buf *bytes.Buffer) (*big.Int, error) {
+	l, err := buf.ReadByte()
 	if err != nil {
-		return nil, err
+		return nil, ErrPrefixVarintTruncated
+	}
+	if l < 8 || l > PREFIX_VARINT_MAX_BITS/8 {
+		return nil, ErrPrefixVarintLength
 	}
 
-	switch {
-	case (firstByte & 128) == 0:
-		return big.NewInt(int64(firstByte)), nil
-
-	case (firstByte & 192) == 128:
-		secondByte, _ := buf.ReadByte()
-		return new(big.Int).Or(new(big.Int).And(big.NewInt(int64(firstByte)), big.NewInt(0x3F)), new(big.Int).Lsh(big.NewInt(int64(secondByte)), 6)), nil
-
-	case (firstByte & 224) == 192:
-		secondByte, _ := buf.ReadByte()
-		thirdByte, _ := buf.ReadByte()
-		value := new(big.Int).Or(new(big.Int).And(big.NewInt(int64(firstByte)), big.NewInt(0x1F)), new(big.Int).Lsh(new(big.Int).Or(big.NewInt(int64(secondByte)), new(big.Int).Lsh(big.NewInt(int64(thirdByte)), 8)), 5))
-		return value, nil
-
-	case (firstByte & 240) == 224:
-		byt := buf.Next(3)
-		value := new(big.Int).Or(new(big.Int).And(big.NewInt(int64(firstByte)), big.NewInt(0x0F)), new(big.Int).Lsh(big.NewInt(int64(byt[0])|int64(byt[1])<<8|int64(byt[2])<<16), 4))
-		return value, nil
-
-	case (firstByte & 248) == 240:
-		var value uint32
-		_ = binary.Read(buf, binary.LittleEndian, &value)
-		return new(big.Int).Lsh(new(big.Int).Or(new(big.Int).And(big.NewInt(int64(firstByte)), big.NewInt(0x07)), big.NewInt(int64(value))), 3), nil
-
-	case (firstByte & 252) == 248:
-		var value1 uint32
-		var value2 uint16
-		_ = binary.Read(buf, binary.LittleEndian, &value1)
-		_ = binary.Read(buf, binary.LittleEndian, &value2)
-		return new(big.Int).Lsh(new(big.Int).Or(new(big.Int).And(big.NewInt(int64(firstByte)), big.NewInt(0x01)), new(big.Int).Or(big.NewInt(int64(value1)), new(big.Int).Lsh(big.NewInt(int64(value2)), 32))), 1), nil
-
-	case firstByte == 254:
-		var value uint64
-		_ = binary.Read(buf, binary.LittleEndian, &value)
-		return new(big.Int).Rsh(big.NewInt(int64(value)), 8), nil
-
-	case firstByte == 255:
-		var value uint64
-		_ = binary.Read(buf, binary.LittleEndian, &value)
-		return big.NewInt(int64(value)), nil
+	rest := make([]byte, l)
+	if _, err := io.ReadFull(buf, rest); err != nil {
+		return nil, ErrPrefixVarintTruncated
+	}
+	reverse(rest)
+	return new(big.Int).SetBytes(rest), nil
+}
 
-	default:
-		return nil, errors.New("invalid prefix varint")
+func reverse(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
 	}
 }
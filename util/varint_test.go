@@ -3,57 +3,137 @@ package util
 import (
 	"bytes"
 	"encoding/hex"
-	"log"
 	"math/big"
 	"testing"
+	"testing/quick"
 )
 
+type vector struct {
+	value   string
+	encoded string
+}
+
+// prefixVarintVectors are checked in both the encode and decode direction
+var prefixVarintVectors = []vector{
+	{value: "0", encoded: "00"},
+	{value: "1", encoded: "01"},
+	{value: "127", encoded: "7f"},
+	{value: "128", encoded: "8002"},
+	{value: "16383", encoded: "bfff"},
+	{value: "16384", encoded: "c00002"},
+	{value: "2097151", encoded: "dfffff"},
+	{value: "2097152", encoded: "e0000002"},
+	{value: "21000000", encoded: "e0f40614"},
+	{value: "268435455", encoded: "efffffff"},
+	{value: "268435456", encoded: "f000000002"},
+	{value: "34359738367", encoded: "f7ffffffff"},
+	{value: "34359738368", encoded: "f80000000002"},
+	{value: "4398046511103", encoded: "fbffffffffff"},
+	{value: "4398046511104", encoded: "fc000000000002"},
+	{value: "562949953421311", encoded: "fdffffffffffff"},
+	{value: "562949953421312", encoded: "fe00000000000002"},
+	{value: "72057594037927935", encoded: "feffffffffffffff"},
+	{value: "72057594037927936", encoded: "ff080000000000000001"},
+	{value: "9223372036854775807", encoded: "ff08ffffffffffffff7f"},
+	{value: "9223372036854775808", encoded: "ff080000000000000080"},
+	{value: "18446744073709551615", encoded: "ff08ffffffffffffffff"},
+	{value: "18446744073709551616", encoded: "ff09000000000000000001"},
+	{value: "5192296858534827628530496329220096", encoded: "ff0f000000000000000000000000000001"},
+	{value: "340282366920938463463374607431768211455", encoded: "ff10ffffffffffffffffffffffffffffffff"},
+}
+
 func TestEncodePrefixVarint(t *testing.T) {
-	type testCase struct {
-		value    *big.Int
-		expected string
-	}
-
-	testCases := []testCase{
-		{value: big.NewInt(0), expected: "00"},
-		{value: big.NewInt(1), expected: "01"},
-		{value: big.NewInt(127), expected: "7f"},
-		{value: big.NewInt(128), expected: "8002"},
-		{value: big.NewInt(16383), expected: "bfff"},
-		{value: big.NewInt(16384), expected: "c00002"},
-		{value: big.NewInt(2097151), expected: "dfffff"},
-		{value: big.NewInt(2097152), expected: "e0000002"},
-		{value: big.NewInt(21000000), expected: "e0f40614"},
-		{value: big.NewInt(268435455), expected: "efffffff"},
-		{value: big.NewInt(268435456), expected: "f000000002"},
-		{value: big.NewInt(34359738367), expected: "f7ffffffff"},
-		{value: big.NewInt(34359738368), expected: "f80000000002"},
-		{value: big.NewInt(4398046511103), expected: "fbffffffffff"},
-		{value: big.NewInt(4398046511104), expected: "fc000000000002"},
-		{value: big.NewInt(562949953421311), expected: "fdffffffffffff"},
-		{value: big.NewInt(562949953421312), expected: "fe00000000000002"},
-		{value: big.NewInt(72057594037927935), expected: "feffffffffffffff"},
-		{value: big.NewInt(72057594037927936), expected: "ff0000000000000001"},
-	}
-
-	for _, tc := range testCases {
+	for _, tc := range prefixVarintVectors {
+		value, _ := new(big.Int).SetString(tc.value, 10)
+		before := new(big.Int).Set(value)
+
 		buf := bytes.NewBuffer([]byte{})
-		iout := EncodePrefixVarint(tc.value, buf)
-		hexOut := hex.EncodeToString(buf.Bytes()[:iout])
-		log.Printf("%v %v %v", hexOut, iout, buf.Bytes())
+		n, err := EncodePrefixVarint(value, buf)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.value, err)
+		}
 
-		if tc.expected != hexOut {
-			t.Fatalf("Expected %s - Got %s", tc.expected, hexOut)
+		hexOut := hex.EncodeToString(buf.Bytes())
+		if tc.encoded != hexOut || n != len(tc.encoded)/2 {
+			t.Fatalf("%s: Expected %s - Got %s (%d bytes)", tc.value, tc.encoded, hexOut, n)
+		}
+		if value.Cmp(before) != 0 {
+			t.Fatalf("%s: input modified to %s", tc.value, value)
 		}
 	}
 }
 
 func TestDecodePrefixVarint(t *testing.T) {
-	buf := bytes.NewBuffer([]byte{255, 152, 120, 6, 1, 0, 0, 0, 0, 18})
-	i, err := DecodePrefixVarint(buf)
-	if err != nil {
-		t.Fatal(err)
+	for _, tc := range prefixVarintVectors {
+		encoded, _ := hex.DecodeString(tc.encoded)
+
+		// Trailing byte must be left for the next read
+		buf := bytes.NewBuffer(append(encoded, 0x2a))
+		value, err := DecodePrefixVarint(buf)
+		if err != nil {
+			t.Fatalf("%s: %s", tc.encoded, err)
+		}
+
+		if value.String() != tc.value {
+			t.Fatalf("%s: Expected %s - Got %s", tc.encoded, tc.value, value)
+		}
+		if buf.Len() != 1 {
+			t.Fatalf("%s: consumed %d trailing bytes", tc.encoded, 1-buf.Len())
+		}
 	}
+}
 
-	log.Println("I", i)
+func TestDecodePrefixVarintTruncated(t *testing.T) {
+	for _, tc := range prefixVarintVectors {
+		encoded, _ := hex.DecodeString(tc.encoded)
+		if len(encoded) == 1 {
+			continue
+		}
+
+		_, err := DecodePrefixVarint(bytes.NewBuffer(encoded[:len(encoded)-1]))
+		if err != ErrPrefixVarintTruncated {
+			t.Fatalf("%s: Expected ErrPrefixVarintTruncated - Got %v", tc.encoded, err)
+		}
+	}
+}
+
+func TestEncodePrefixVarintOutOfRange(t *testing.T) {
+	overflow := new(big.Int).Lsh(big.NewInt(1), 128)
+	if _, err := EncodePrefixVarint(overflow, &bytes.Buffer{}); err != ErrPrefixVarintOverflow {
+		t.Fatalf("Expected ErrPrefixVarintOverflow - Got %v", err)
+	}
+
+	if _, err := EncodePrefixVarint(big.NewInt(-1), &bytes.Buffer{}); err != ErrPrefixVarintNegative {
+		t.Fatalf("Expected ErrPrefixVarintNegative - Got %v", err)
+	}
+}
+
+func TestDecodePrefixVarintLength(t *testing.T) {
+	for _, encoded := range []string{"ff07ffffffffffffff", "ff1100"} {
+		b, _ := hex.DecodeString(encoded)
+		if _, err := DecodePrefixVarint(bytes.NewBuffer(b)); err != ErrPrefixVarintLength {
+			t.Fatalf("%s: Expected ErrPrefixVarintLength - Got %v", encoded, err)
+		}
+	}
+}
+
+func TestPrefixVarintRoundTrip(t *testing.T) {
+	check := func(hi, lo uint64, shift uint8) bool {
+		value := new(big.Int).SetUint64(hi)
+		value.Lsh(value, 64).Or(value, new(big.Int).SetUint64(lo))
+		value.Rsh(value, uint(shift%128))
+
+		buf := bytes.NewBuffer([]byte{})
+		n, err := EncodePrefixVarint(value, buf)
+		if err != nil || n != PrefixVarintLen(value) {
+			return false
+		}
+
+		out, err := DecodePrefixVarint(buf)
+		return err == nil && out.Cmp(value) == 0 && buf.Len() == 0
+	}
+
+	if err := quick.Check(check, nil); err != nil {
+		t.Fatal(err)
+	}
 }
@@ -0,0 +1,54 @@
+package btc_rune
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook is a URL the stored events are pushed to, optionally only those of some runes, addresses or types
+type Webhook struct {
+	ID  uint64 `json:"id" gorm:"primaryKey"`
+	URL string `json:"url"`
+	// Secret signs every delivery, it is only returned when the webhook is registered
+	Secret string `json:"secret,omitempty"`
+
+	Runes     []RuneID    `json:"runes,omitempty" gorm:"serializer:json"`
+	Addresses []string    `json:"addresses,omitempty" gorm:"serializer:json"`
+	Types     []EventType `json:"types,omitempty" gorm:"serializer:json"`
+
+	// Since is the last event stored before the webhook was registered
+	Since uint64 `json:"since"`
+	// Cursor is the last event queued for delivery
+	Cursor    uint64    `json:"cursor"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// DeliveryStatus is where a delivery stands
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// DeliveryFailed gave up after the last attempt
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// Delivery is an event queued for a webhook and the outcome of its attempts
+type Delivery struct {
+	ID      uint64    `json:"id" gorm:"primaryKey"`
+	Webhook uint64    `json:"webhook" gorm:"index"`
+	Event   uint64    `json:"event"`
+	Type    EventType `json:"type"`
+	// Payload is the body posted on every attempt
+	Payload json.RawMessage `json:"payload"`
+
+	Status   DeliveryStatus `json:"status" gorm:"index"`
+	Attempts int            `json:"attempts"`
+	// NextAttempt is when a pending delivery is due, always UTC
+	NextAttempt time.Time `json:"nextAttempt" gorm:"index"`
+	// LastStatus is the HTTP status of the last attempt, 0 when it got no response
+	LastStatus  int        `json:"lastStatus,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	DeliveredAt *time.Time `json:"deliveredAt,omitempty"`
+}
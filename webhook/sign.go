@@ -0,0 +1,94 @@
+// Package webhook signs webhook deliveries and schedules their retries.
+//
+// Every delivery carries an X-Webhook-Signature header of the form
+//
+//	t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>" keyed by the webhook secret>
+//
+// Receivers recompute the HMAC over the raw body and reject stale timestamps to stop replays.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader is the header carrying a delivery's signature
+const SignatureHeader = "X-Webhook-Signature"
+
+// ErrInvalidSignature is returned for a signature that does not verify
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// NewSecret returns a random 32 byte secret, hex encoded
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header value of body sent at t
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac(secret, ts, body)))
+}
+
+// Verify checks header signs body with secret and was made within tolerance of now, zero skips the age check
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, mac(secret, ts, body)) {
+		return ErrInvalidSignature
+	}
+
+	age := now.Sub(time.Unix(unix, 0))
+	if tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return fmt.Errorf("%w: signed %s ago", ErrInvalidSignature, age)
+	}
+	return nil
+}
+
+func mac(secret, ts string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(ts))
+	h.Write([]byte{'.'})
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Backoff returns the wait after the n'th failed attempt, base doubled per attempt and capped at max
+func Backoff(base, max time.Duration, n int) time.Duration {
+	d := base
+	for i := 1; i < n; i++ {
+		if d >= max/2 {
+			return max
+		}
+		d *= 2
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil || len(secret) != 64 {
+		t.Fatalf("Expected 64 hex secret - Got %q %v", secret, err)
+	}
+
+	body := []byte(`{"id":1}`)
+	at := time.Unix(1700000000, 0)
+	header := Sign(secret, at, body)
+
+	err = Verify(secret, header, body, at.Add(time.Minute), 5*time.Minute)
+	if err != nil {
+		t.Fatalf("Expected valid signature - Got %v", err)
+	}
+
+	for name, c := range map[string]struct {
+		secret, header string
+		body           []byte
+		now            time.Time
+	}{
+		"secret":  {"other", header, body, at},
+		"body":    {secret, header, []byte(`{"id":2}`), at},
+		"stale":   {secret, header, body, at.Add(time.Hour)},
+		"future":  {secret, header, body, at.Add(-time.Hour)},
+		"missing": {secret, "", body, at},
+		"garbled": {secret, "t=1700000000,v1=zz", body, at},
+	} {
+		err = Verify(c.secret, c.header, c.body, c.now, 5*time.Minute)
+		if !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("%s: Expected ErrInvalidSignature - Got %v", name, err)
+		}
+	}
+
+	if err = Verify(secret, header, body, at.Add(time.Hour), 0); err != nil {
+		t.Fatalf("Expected no age check - Got %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for n, want := range map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		4:  80 * time.Second,
+		9:  2560 * time.Second,
+		10: time.Hour,
+		99: time.Hour,
+	} {
+		if got := Backoff(10*time.Second, time.Hour, n); got != want {
+			t.Fatalf("Expected attempt %d backoff %s - Got %s", n, want, got)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Package zmq follows the rawblock, rawtx and sequence notifications bitcoind publishes over ZMQ.
+//
+// Every bitcoind notification is three frames: the topic, the body and a
+// little endian per topic message counter. A jump in the counter, or in the
+// mempool sequence of the sequence topic, means notifications were dropped and
+// is reported as a gap so the caller can re-fetch over RPC.
+package zmq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/lightninglabs/gozmq"
+)
+
+const (
+	TopicRawBlock = "rawblock"
+	TopicRawTx    = "rawtx"
+	TopicSequence = "sequence"
+)
+
+// Topics are the notifications a Subscriber follows
+var Topics = []string{TopicRawBlock, TopicRawTx, TopicSequence}
+
+// SequenceLabel is the event of a sequence notification
+type SequenceLabel byte
+
+const (
+	BlockConnected    SequenceLabel = 'C'
+	BlockDisconnected SequenceLabel = 'D'
+	TxAdded           SequenceLabel = 'A'
+	TxRemoved         SequenceLabel = 'R'
+)
+
+var (
+	ErrInvalidMessage  = errors.New("invalid zmq message")
+	ErrInvalidSequence = errors.New("invalid sequence notification")
+)
+
+// Sequence is a block or mempool change from the sequence topic
+type Sequence struct {
+	Hash  chainhash.Hash
+	Label SequenceLabel
+	// MempoolSequence orders mempool changes, only set for TxAdded and TxRemoved
+	MempoolSequence uint64
+}
+
+// ParseSequence decodes a sequence notification body.
+// bitcoind writes the hash in display order, Hash holds it in internal order.
+func ParseSequence(body []byte) (*Sequence, error) {
+	if len(body) != chainhash.HashSize+1 && len(body) != chainhash.HashSize+9 {
+		return nil, fmt.Errorf("%w: length %d", ErrInvalidSequence, len(body))
+	}
+
+	s := &Sequence{Label: SequenceLabel(body[chainhash.HashSize])}
+	for i := 0; i < chainhash.HashSize; i++ {
+		s.Hash[i] = body[chainhash.HashSize-1-i]
+	}
+
+	switch s.Label {
+	case BlockConnected, BlockDisconnected:
+		if len(body) != chainhash.HashSize+1 {
+			return nil, fmt.Errorf("%w: block event of length %d", ErrInvalidSequence, len(body))
+		}
+	case TxAdded, TxRemoved:
+		if len(body) != chainhash.HashSize+9 {
+			return nil, fmt.Errorf("%w: mempool event of length %d", ErrInvalidSequence, len(body))
+		}
+		s.MempoolSequence = binary.LittleEndian.Uint64(body[chainhash.HashSize+1:])
+	default:
+		return nil, fmt.Errorf("%w: unknown label %q", ErrInvalidSequence, body[chainhash.HashSize])
+	}
+	return s, nil
+}
+
+// Handler receives decoded notifications, calls are made from the Run goroutine
+type Handler interface {
+	OnBlock(block *wire.MsgBlock)
+	OnTx(tx *wire.MsgTx)
+	OnSequence(s *Sequence)
+	// OnGap reports notifications of topic were dropped
+	OnGap(topic string)
+}
+
+// Subscriber reads notifications from a bitcoind ZMQ publisher
+type Subscriber struct {
+	conn *gozmq.Conn
+
+	counters        map[string]uint32
+	mempoolSequence *uint64
+}
+
+// Subscribe connects to the publisher at addr and follows Topics
+func Subscribe(addr string, timeout time.Duration) (*Subscriber, error) {
+	conn, err := gozmq.Subscribe(addr, Topics, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Subscriber{
+		conn:     conn,
+		counters: map[string]uint32{},
+	}, nil
+}
+
+// Run dispatches notifications to h until the subscriber is closed.
+// Reconnects after a dropped connection and unreadable notifications are reported as gaps.
+func (s *Subscriber) Run(h Handler) error {
+	for {
+		parts, err := s.conn.Receive(nil)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				s.reset(h)
+				continue
+			}
+			return err
+		}
+
+		// A notification that cannot be read is as good as dropped
+		if err = s.dispatch(parts, h); err != nil && len(parts) > 0 {
+			h.OnGap(string(parts[0]))
+		}
+	}
+}
+
+func (s *Subscriber) Close() error {
+	return s.conn.Close()
+}
+
+// reset forgets the counters after a reconnect, anything may have been missed meanwhile
+func (s *Subscriber) reset(h Handler) {
+	if len(s.counters) == 0 {
+		return
+	}
+
+	s.counters = map[string]uint32{}
+	s.mempoolSequence = nil
+	for _, topic := range Topics {
+		h.OnGap(topic)
+	}
+}
+
+func (s *Subscriber) dispatch(parts [][]byte, h Handler) error {
+	if len(parts) != 3 || len(parts[2]) != 4 {
+		return fmt.Errorf("%w: %d parts", ErrInvalidMessage, len(parts))
+	}
+
+	topic, body := string(parts[0]), parts[1]
+	counter := binary.LittleEndian.Uint32(parts[2])
+	if last, ok := s.counters[topic]; ok && counter != last+1 {
+		h.OnGap(topic)
+	}
+	s.counters[topic] = counter
+
+	switch topic {
+	case TopicRawBlock:
+		var block wire.MsgBlock
+		if err := block.Deserialize(bytes.NewReader(body)); err != nil {
+			return fmt.Errorf("%w: rawblock: %s", ErrInvalidMessage, err)
+		}
+		h.OnBlock(&block)
+	case TopicRawTx:
+		var tx wire.MsgTx
+		if err := tx.Deserialize(bytes.NewReader(body)); err != nil {
+			return fmt.Errorf("%w: rawtx: %s", ErrInvalidMessage, err)
+		}
+		h.OnTx(&tx)
+	case TopicSequence:
+		seq, err := ParseSequence(body)
+		if err != nil {
+			return err
+		}
+
+		switch seq.Label {
+		case TxAdded, TxRemoved:
+			if s.mempoolSequence != nil && seq.MempoolSequence != *s.mempoolSequence+1 {
+				h.OnGap(topic)
+			}
+			s.mempoolSequence = &seq.MempoolSequence
+		default:
+			// Block connections evict transactions silently, the mempool sequence jumps past them
+			s.mempoolSequence = nil
+		}
+		h.OnSequence(seq)
+	}
+	return nil
+}
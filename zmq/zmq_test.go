@@ -0,0 +1,229 @@
+package zmq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// publisher is a minimal ZMTP 3.0 PUB socket standing in for bitcoind
+type publisher struct {
+	listener net.Listener
+	conn     net.Conn
+	ready    chan struct{}
+}
+
+func newPublisher(t *testing.T) *publisher {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &publisher{listener: l, ready: make(chan struct{})}
+	go p.accept(t)
+	return p
+}
+
+func (p *publisher) addr() string {
+	return "tcp://" + p.listener.Addr().String()
+}
+
+func (p *publisher) accept(t *testing.T) {
+	conn, err := p.listener.Accept()
+	if err != nil {
+		return
+	}
+	p.conn = conn
+
+	greeting := make([]byte, 64)
+	greeting[0], greeting[9], greeting[10] = 0xff, 0x7f, 3
+	copy(greeting[12:], "NULL")
+	conn.Write(greeting)
+	if _, err := io.ReadFull(conn, make([]byte, 64)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := p.readFrame(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	ready := []byte{5}
+	ready = append(ready, "READY"...)
+	ready = append(ready, 11)
+	ready = append(ready, "Socket-Type"...)
+	ready = append(ready, 0, 0, 0, 3)
+	ready = append(ready, "PUB"...)
+	p.writeFrame(0x04, ready)
+
+	for range Topics {
+		if _, err := p.readFrame(); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	close(p.ready)
+}
+
+func (p *publisher) readFrame() ([]byte, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(p.conn, head); err != nil {
+		return nil, err
+	}
+	body := make([]byte, head[1])
+	_, err := io.ReadFull(p.conn, body)
+	return body, err
+}
+
+func (p *publisher) writeFrame(flag byte, body []byte) {
+	if len(body) > 255 {
+		size := make([]byte, 8)
+		binary.BigEndian.PutUint64(size, uint64(len(body)))
+		p.conn.Write(append([]byte{flag | 0x02}, size...))
+	} else {
+		p.conn.Write([]byte{flag, byte(len(body))})
+	}
+	p.conn.Write(body)
+}
+
+// publish sends a bitcoind style three part notification
+func (p *publisher) publish(topic string, body []byte, counter uint32) {
+	seq := make([]byte, 4)
+	binary.LittleEndian.PutUint32(seq, counter)
+
+	p.writeFrame(0x01, []byte(topic))
+	p.writeFrame(0x01, body)
+	p.writeFrame(0x00, seq)
+}
+
+type recorder struct {
+	mu        sync.Mutex
+	blocks    []*wire.MsgBlock
+	txs       []*wire.MsgTx
+	sequences []*Sequence
+	gaps      []string
+	received  chan struct{}
+}
+
+func (r *recorder) record(f func()) {
+	r.mu.Lock()
+	f()
+	r.mu.Unlock()
+	r.received <- struct{}{}
+}
+
+func (r *recorder) OnBlock(block *wire.MsgBlock) {
+	r.record(func() { r.blocks = append(r.blocks, block) })
+}
+func (r *recorder) OnTx(tx *wire.MsgTx)    { r.record(func() { r.txs = append(r.txs, tx) }) }
+func (r *recorder) OnSequence(s *Sequence) { r.record(func() { r.sequences = append(r.sequences, s) }) }
+func (r *recorder) OnGap(topic string)     { r.record(func() { r.gaps = append(r.gaps, topic) }) }
+
+func (r *recorder) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for notification %d of %d", i+1, n)
+		}
+	}
+}
+
+func sequenceBody(hash chainhash.Hash, label SequenceLabel, mempoolSequence uint64) []byte {
+	body := make([]byte, 0, chainhash.HashSize+9)
+	for i := chainhash.HashSize - 1; i >= 0; i-- {
+		body = append(body, hash[i])
+	}
+	body = append(body, byte(label))
+	if label == TxAdded || label == TxRemoved {
+		body = binary.LittleEndian.AppendUint64(body, mempoolSequence)
+	}
+	return body
+}
+
+func TestParseSequence(t *testing.T) {
+	hash := chainhash.Hash{0x01, 0x02}
+
+	s, err := ParseSequence(sequenceBody(hash, TxAdded, 42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Hash != hash || s.Label != TxAdded || s.MempoolSequence != 42 {
+		t.Fatalf("Unexpected sequence %+v", s)
+	}
+
+	for _, body := range [][]byte{
+		nil,
+		append(sequenceBody(hash, BlockConnected, 0), 0),
+		sequenceBody(hash, SequenceLabel('X'), 0),
+		sequenceBody(hash, TxRemoved, 1)[:chainhash.HashSize+1],
+	} {
+		if _, err := ParseSequence(body); err == nil {
+			t.Fatalf("%x: Expected error", body)
+		}
+	}
+}
+
+func TestSubscriberDispatch(t *testing.T) {
+	p := newPublisher(t)
+	defer p.listener.Close()
+
+	sub, err := Subscribe(p.addr(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	<-p.ready
+
+	r := &recorder{received: make(chan struct{}, 16)}
+	go sub.Run(r)
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(546, []byte{txscript.OP_TRUE}))
+	var rawTx bytes.Buffer
+	tx.Serialize(&rawTx)
+
+	block := wire.NewMsgBlock(&wire.BlockHeader{Nonce: 7})
+	block.AddTransaction(tx)
+	var rawBlock bytes.Buffer
+	block.Serialize(&rawBlock)
+
+	p.publish(TopicRawTx, rawTx.Bytes(), 0)
+	p.publish(TopicSequence, sequenceBody(tx.TxHash(), TxAdded, 10), 0)
+	p.publish(TopicRawBlock, rawBlock.Bytes(), 0)
+	p.publish(TopicSequence, sequenceBody(block.BlockHash(), BlockConnected, 0), 1)
+	r.wait(t, 4)
+
+	// The rawtx counter skips 1 and mempool sequence 21 skips 20, a block resets the mempool sequence
+	p.publish(TopicRawTx, rawTx.Bytes(), 2)
+	p.publish(TopicSequence, sequenceBody(tx.TxHash(), TxAdded, 19), 2)
+	p.publish(TopicSequence, sequenceBody(tx.TxHash(), TxRemoved, 21), 3)
+	r.wait(t, 5)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.txs) != 2 || r.txs[0].TxHash() != tx.TxHash() {
+		t.Fatalf("Expected rawtx to decode to %s - Got %+v", tx.TxHash(), r.txs)
+	}
+	if len(r.blocks) != 1 || r.blocks[0].BlockHash() != block.BlockHash() {
+		t.Fatalf("Expected rawblock to decode to %s - Got %+v", block.BlockHash(), r.blocks)
+	}
+	if len(r.sequences) != 4 || r.sequences[1].Hash != block.BlockHash() || r.sequences[1].Label != BlockConnected {
+		t.Fatalf("Unexpected sequences %+v", r.sequences)
+	}
+	if len(r.gaps) != 2 || r.gaps[0] != TopicRawTx || r.gaps[1] != TopicSequence {
+		t.Fatalf("Expected rawtx and sequence gaps - Got %v", r.gaps)
+	}
+}